@@ -0,0 +1,36 @@
+package main
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+var agentCmd = &cobra.Command{
+	Use:   "agent",
+	Short: "Follow agent-based installations",
+	Long: `Agent-based installations (--cloud agent) end when the ISO is created. Boot every host from it and follow the
+installation with the subcommands.`,
+}
+
+var agentWaitForCmd = &cobra.Command{
+	Use:       fmt.Sprintf("wait-for <%s> <cluster-output-dir>", strings.Join(utils.AgentWaitStages, "|")),
+	Short:     "Wait until the agent installation reaches a stage",
+	Long:      `Run openshift-install agent wait-for in the output dir of the installation, it reports progress of the rendezvous host.`,
+	Args:      cobra.ExactArgs(2),
+	ValidArgs: utils.AgentWaitStages,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := utils.AgentWaitFor(cmd.Context(), args[1], args[0]); err != nil {
+			return err
+		}
+		fmt.Printf("Agent installation reached %v.\n", args[0])
+		return nil
+	},
+}
+
+func init() {
+	agentCmd.AddCommand(agentWaitForCmd)
+	rootCmd.AddCommand(agentCmd)
+}
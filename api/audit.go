@@ -0,0 +1,68 @@
+package main
+
+import (
+	"encoding/json"
+	"log"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/RomanBednar/install-tools/utils"
+)
+
+const (
+	envAuditLog     = utils.EnvPrefix + "_API_AUDIT_LOG"
+	defaultAuditLog = "/tmp/.cache/audit.log"
+)
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal"`
+	Remote    string    `json:"remote"`
+	Artifact  string    `json:"artifact"`
+	OutputDir string    `json:"outputDir,omitempty"`
+	Result    string    `json:"result"`
+}
+
+var auditMu sync.Mutex
+
+// audit appends one JSON line per access to a cluster artifact. Failing to write the audit log is
+// logged but does not fail the request.
+func audit(r *http.Request, artifact, outputDir string, err error) {
+	entry := auditEntry{
+		Time:      time.Now().UTC(),
+		Principal: principalFrom(r),
+		Remote:    r.RemoteAddr,
+		Artifact:  artifact,
+		OutputDir: outputDir,
+		Result:    "success",
+	}
+	if err != nil {
+		entry.Result = "error: " + err.Error()
+	}
+
+	path := os.Getenv(envAuditLog)
+	if path == "" {
+		path = defaultAuditLog
+	}
+
+	auditMu.Lock()
+	defer auditMu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		log.Printf("error creating audit log directory: %v", err)
+		return
+	}
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, 0600)
+	if err != nil {
+		log.Printf("error opening audit log: %v", err)
+		return
+	}
+	defer f.Close()
+
+	if err := json.NewEncoder(f).Encode(entry); err != nil {
+		log.Printf("error writing audit log: %v", err)
+	}
+}
@@ -0,0 +1,207 @@
+package main
+
+import (
+	"bufio"
+	"context"
+	"crypto/md5"
+	"crypto/sha1"
+	"crypto/subtle"
+	"encoding/base64"
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"strings"
+)
+
+type principalKey struct{}
+
+// authenticator verifies credentials of a request and returns the name of the authenticated principal.
+type authenticator interface {
+	authenticate(r *http.Request) (principal string, err error)
+	scheme() string
+}
+
+// bearerAuth accepts requests carrying "Authorization: Bearer <token>" with the configured token.
+type bearerAuth struct {
+	token string
+}
+
+func (a *bearerAuth) scheme() string { return "Bearer" }
+
+func (a *bearerAuth) authenticate(r *http.Request) (string, error) {
+	header := r.Header.Get("Authorization")
+	if header == "" {
+		return "", fmt.Errorf("missing Authorization header")
+	}
+	token, ok := strings.CutPrefix(header, "Bearer ")
+	if !ok {
+		return "", fmt.Errorf("unsupported authorization scheme")
+	}
+	if subtle.ConstantTimeCompare([]byte(strings.TrimSpace(token)), []byte(a.token)) != 1 {
+		return "", fmt.Errorf("invalid bearer token")
+	}
+	return "token", nil
+}
+
+// htpasswdAuth accepts HTTP basic auth checked against an htpasswd file.
+// Only SHA1 ("htpasswd -s") and Apache MD5 ("htpasswd -m", the htpasswd default) hashes are supported,
+// bcrypt would require a dependency we don't vendor.
+type htpasswdAuth struct {
+	users map[string]string
+}
+
+func (a *htpasswdAuth) scheme() string { return `Basic realm="install-tools"` }
+
+func (a *htpasswdAuth) authenticate(r *http.Request) (string, error) {
+	user, password, ok := r.BasicAuth()
+	if !ok {
+		return "", fmt.Errorf("missing basic auth credentials")
+	}
+	hash, found := a.users[user]
+	if !found {
+		// Still compute a hash so unknown users can't be told apart by timing.
+		_ = apr1Hash(password, "00000000")
+		return "", fmt.Errorf("unknown user %q", user)
+	}
+	if !htpasswdMatch(password, hash) {
+		return "", fmt.Errorf("invalid password for user %q", user)
+	}
+	return user, nil
+}
+
+func loadHtpasswd(path string) (*htpasswdAuth, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, fmt.Errorf("could not open htpasswd file: %v", err)
+	}
+	defer f.Close()
+
+	users := map[string]string{}
+	scanner := bufio.NewScanner(f)
+	for lineNo := 1; scanner.Scan(); lineNo++ {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		user, hash, ok := strings.Cut(line, ":")
+		if !ok {
+			return nil, fmt.Errorf("%s:%d: malformed entry", path, lineNo)
+		}
+		if !strings.HasPrefix(hash, "{SHA}") && !strings.HasPrefix(hash, "$apr1$") {
+			return nil, fmt.Errorf("%s:%d: unsupported hash for user %q, regenerate it with 'htpasswd -m' or 'htpasswd -s'", path, lineNo, user)
+		}
+		users[user] = hash
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("could not read htpasswd file: %v", err)
+	}
+	if len(users) == 0 {
+		return nil, fmt.Errorf("htpasswd file %s contains no users", path)
+	}
+	return &htpasswdAuth{users: users}, nil
+}
+
+func htpasswdMatch(password, hash string) bool {
+	var computed string
+	switch {
+	case strings.HasPrefix(hash, "{SHA}"):
+		sum := sha1.Sum([]byte(password))
+		computed = "{SHA}" + base64.StdEncoding.EncodeToString(sum[:])
+	case strings.HasPrefix(hash, "$apr1$"):
+		salt, _, _ := strings.Cut(strings.TrimPrefix(hash, "$apr1$"), "$")
+		computed = apr1Hash(password, salt)
+	default:
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(computed), []byte(hash)) == 1
+}
+
+// apr1Hash implements the Apache variant of the MD5 crypt algorithm used by htpasswd.
+func apr1Hash(password, salt string) string {
+	const magic = "$apr1$"
+	const itoa64 = "./0123456789ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz"
+	if len(salt) > 8 {
+		salt = salt[:8]
+	}
+	pw := []byte(password)
+
+	alt := md5.Sum([]byte(password + salt + password))
+
+	h := md5.New()
+	h.Write(pw)
+	h.Write([]byte(magic + salt))
+	for i := len(pw); i > 0; i -= 16 {
+		h.Write(alt[:min(i, 16)])
+	}
+	for i := len(pw); i > 0; i >>= 1 {
+		if i&1 != 0 {
+			h.Write([]byte{0})
+		} else {
+			h.Write(pw[:1])
+		}
+	}
+	final := h.Sum(nil)
+
+	for i := 0; i < 1000; i++ {
+		h := md5.New()
+		if i&1 != 0 {
+			h.Write(pw)
+		} else {
+			h.Write(final)
+		}
+		if i%3 != 0 {
+			h.Write([]byte(salt))
+		}
+		if i%7 != 0 {
+			h.Write(pw)
+		}
+		if i&1 != 0 {
+			h.Write(final)
+		} else {
+			h.Write(pw)
+		}
+		final = h.Sum(nil)
+	}
+
+	var out strings.Builder
+	to64 := func(v uint32, n int) {
+		for ; n > 0; n-- {
+			out.WriteByte(itoa64[v&0x3f])
+			v >>= 6
+		}
+	}
+	for _, g := range [][3]int{{0, 6, 12}, {1, 7, 13}, {2, 8, 14}, {3, 9, 15}, {4, 10, 5}} {
+		to64(uint32(final[g[0]])<<16|uint32(final[g[1]])<<8|uint32(final[g[2]]), 4)
+	}
+	to64(uint32(final[11]), 2)
+
+	return magic + salt + "$" + out.String()
+}
+
+// requireAuth rejects requests that the authenticator does not accept. A nil authenticator means
+// authentication was explicitly disabled.
+func requireAuth(auth authenticator, next http.Handler) http.Handler {
+	if auth == nil {
+		return next
+	}
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		principal, err := auth.authenticate(r)
+		if err != nil {
+			// Never log the Authorization header itself, only why it was rejected.
+			log.Printf("Authentication failed: remote=%s method=%s path=%s reason=%v", r.RemoteAddr, r.Method, r.URL.Path, err)
+			w.Header().Set("WWW-Authenticate", auth.scheme())
+			http.Error(w, "Unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), principalKey{}, principal)))
+	})
+}
+
+// principalFrom returns the name of the authenticated caller, or "anonymous" if authentication is disabled.
+func principalFrom(r *http.Request) string {
+	if p, ok := r.Context().Value(principalKey{}).(string); ok {
+		return p
+	}
+	return "anonymous"
+}
@@ -0,0 +1,125 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestBearerAuth(t *testing.T) {
+	auth := &bearerAuth{token: "s3cret"}
+	tests := []struct {
+		name   string
+		header string
+		ok     bool
+	}{
+		{name: "valid token", header: "Bearer s3cret", ok: true},
+		{name: "missing header"},
+		{name: "wrong token", header: "Bearer s3cre"},
+		{name: "basic auth", header: "Basic czNjcmV0Og=="},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			if tt.header != "" {
+				r.Header.Set("Authorization", tt.header)
+			}
+			principal, err := auth.authenticate(r)
+			if tt.ok && (err != nil || principal != "token") {
+				t.Errorf("expected principal token, got %q, %v", principal, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("expected an error, got principal %q", principal)
+			}
+		})
+	}
+}
+
+func TestApr1Hash(t *testing.T) {
+	// Hashes of htpasswd -m, the first one is the example of the Apache documentation.
+	tests := []struct{ password, salt, want string }{
+		{"myPassword", "r31.....", "$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/"},
+		{"s3cret", "r31.....", "$apr1$r31.....$JeaE8ieUW5Wjt.uFjXhgi1"},
+		{"correct horse", "saltsaltextra", "$apr1$saltsalt$EGVZDNN6gOqijy.tv9axG/"},
+	}
+	for _, tt := range tests {
+		if got := apr1Hash(tt.password, tt.salt); got != tt.want {
+			t.Errorf("apr1Hash(%q, %q) = %v, want %v", tt.password, tt.salt, got, tt.want)
+		}
+	}
+}
+
+func TestHtpasswdAuth(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	content := "# users of the API\n" +
+		"alice:$apr1$r31.....$HqJZimcKQFAMYayBlzkrA/\n" +
+		"bob:{SHA}/vNB+F2HQ559kaLUZbmHHvZrXpg=\n"
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	auth, err := loadHtpasswd(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name           string
+		user, password string
+		ok             bool
+	}{
+		{name: "apr1", user: "alice", password: "myPassword", ok: true},
+		{name: "apr1 with wrong password", user: "alice", password: "mypassword"},
+		{name: "sha", user: "bob", password: "s3cret", ok: true},
+		{name: "sha with wrong password", user: "bob", password: "secret"},
+		{name: "unknown user", user: "mallory", password: "myPassword"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			r := httptest.NewRequest(http.MethodGet, "/", nil)
+			r.SetBasicAuth(tt.user, tt.password)
+			principal, err := auth.authenticate(r)
+			if tt.ok && (err != nil || principal != tt.user) {
+				t.Errorf("expected principal %v, got %q, %v", tt.user, principal, err)
+			}
+			if !tt.ok && err == nil {
+				t.Errorf("expected an error, got principal %q", principal)
+			}
+		})
+	}
+
+	if _, err := auth.authenticate(httptest.NewRequest(http.MethodGet, "/", nil)); err == nil {
+		t.Errorf("expected an error without credentials")
+	}
+}
+
+func TestLoadHtpasswdRejectsBcrypt(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "htpasswd")
+	if err := os.WriteFile(path, []byte("alice:$2y$05$c4WoMPo3SXsafkva.HHa6uXQZWr7oboPiC2bT/r7q1BB8I2s0BRqC\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := loadHtpasswd(path); err == nil {
+		t.Errorf("expected an error for a bcrypt hash")
+	}
+}
+
+func TestRequireAuth(t *testing.T) {
+	handler := requireAuth(&bearerAuth{token: "s3cret"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Write([]byte(principalFrom(r)))
+	}))
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil))
+	if w.Code != http.StatusUnauthorized || w.Header().Get("WWW-Authenticate") != "Bearer" {
+		t.Errorf("expected 401 with a Bearer challenge, got %v %v", w.Code, w.Header())
+	}
+
+	w = httptest.NewRecorder()
+	r := httptest.NewRequest(http.MethodGet, "/api/v1/jobs", nil)
+	r.Header.Set("Authorization", "Bearer s3cret")
+	handler.ServeHTTP(w, r)
+	if w.Code != http.StatusOK || w.Body.String() != "token" {
+		t.Errorf("expected the principal token, got %v %q", w.Code, w.Body)
+	}
+}
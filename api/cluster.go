@@ -0,0 +1,74 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+
+	"github.com/RomanBednar/install-tools/utils"
+)
+
+// clusterInfoHandler returns console URL, API URL and version of the installed cluster.
+func clusterInfoHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadStoredConfig()
+	if err != nil {
+		audit(r, "cluster-info", "", err)
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	access, err := utils.ReadClusterAccess(config.OutputDir)
+	audit(r, "cluster-info", config.OutputDir, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(access)
+}
+
+// kubeconfigHandler serves the admin kubeconfig of the installed cluster as a download.
+func kubeconfigHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadStoredConfig()
+	if err != nil {
+		audit(r, "kubeconfig", "", err)
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	content, err := os.ReadFile(utils.KubeconfigPath(config.OutputDir))
+	audit(r, "kubeconfig", config.OutputDir, err)
+	if err != nil {
+		http.Error(w, "kubeconfig not found, did the installation finish?", http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/yaml")
+	w.Header().Set("Content-Disposition", `attachment; filename="kubeconfig"`)
+	w.Header().Set("Cache-Control", "no-store")
+	w.Write(content)
+}
+
+// kubeadminPasswordHandler reveals the kubeadmin password. It is only served on POST so that it can't
+// be fetched by accident, e.g. by a browser prefetching links.
+func kubeadminPasswordHandler(w http.ResponseWriter, r *http.Request) {
+	config, err := loadStoredConfig()
+	if err != nil {
+		audit(r, "kubeadmin-password", "", err)
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	password, err := utils.ReadKubeadminPassword(config.OutputDir)
+	audit(r, "kubeadmin-password", config.OutputDir, err)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusNotFound)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Header().Set("Cache-Control", "no-store")
+	json.NewEncoder(w).Encode(map[string]string{"username": "kubeadmin", "password": password})
+}
@@ -0,0 +1,173 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/RomanBednar/install-tools/utils"
+)
+
+type jobState string
+
+const (
+	jobRunning   jobState = "running"
+	jobSucceeded jobState = "succeeded"
+	jobFailed    jobState = "failed"
+	jobCancelled jobState = "cancelled"
+)
+
+// job is one create or destroy action running in the background.
+type job struct {
+	ID        string     `json:"id"`
+	Action    string     `json:"action"`
+	State     jobState   `json:"state"`
+	Error     string     `json:"error,omitempty"`
+	StartedBy string     `json:"startedBy"`
+	Started   time.Time  `json:"started"`
+	Finished  *time.Time `json:"finished,omitempty"`
+
+	cancel context.CancelFunc
+}
+
+type jobStore struct {
+	mu   sync.Mutex
+	jobs map[string]*job
+}
+
+var jobs = &jobStore{jobs: map[string]*job{}}
+
+var errJobRunning = errors.New("another job is already running")
+
+// start runs the action in the background. Only one job may run at a time, they would share the output dir.
+func (s *jobStore) start(config *utils.Config, principal string) (job, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for _, j := range s.jobs {
+		if j.State == jobRunning {
+			return job{}, fmt.Errorf("%w: %s", errJobRunning, j.ID)
+		}
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	j := &job{
+		ID:        newJobID(),
+		Action:    config.Action,
+		State:     jobRunning,
+		StartedBy: principal,
+		Started:   time.Now().UTC(),
+		cancel:    cancel,
+	}
+	s.jobs[j.ID] = j
+
+	go func() {
+		defer cancel()
+		err := utils.Run(ctx, config)
+		s.finish(j.ID, err)
+	}()
+
+	return *j, nil
+}
+
+func (s *jobStore) finish(id string, err error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j := s.jobs[id]
+	now := time.Now().UTC()
+	j.Finished = &now
+	switch {
+	case err == nil:
+		j.State = jobSucceeded
+	case errors.Is(err, utils.ErrCancelled):
+		j.State = jobCancelled
+		j.Error = err.Error()
+	default:
+		j.State = jobFailed
+		j.Error = err.Error()
+	}
+	log.Printf("Job %s (%s) finished: %s %s", j.ID, j.Action, j.State, j.Error)
+}
+
+func (s *jobStore) get(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	return *j, true
+}
+
+func (s *jobStore) list() []job {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	list := make([]job, 0, len(s.jobs))
+	for _, j := range s.jobs {
+		list = append(list, *j)
+	}
+	return list
+}
+
+// cancelJob interrupts a running job. The job is marked cancelled once the running command has stopped.
+func (s *jobStore) cancelJob(id string) (job, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	j, ok := s.jobs[id]
+	if !ok {
+		return job{}, false
+	}
+	if j.State == jobRunning {
+		log.Printf("Cancelling job %s (%s)", j.ID, j.Action)
+		j.cancel()
+	}
+	return *j, true
+}
+
+func newJobID() string {
+	b := make([]byte, 8)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("could not generate job id: %v", err))
+	}
+	return hex.EncodeToString(b)
+}
+
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(v)
+}
+
+func listJobsHandler(w http.ResponseWriter, r *http.Request) {
+	writeJSON(w, http.StatusOK, jobs.list())
+}
+
+func getJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.get(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	writeJSON(w, http.StatusOK, j)
+}
+
+func cancelJobHandler(w http.ResponseWriter, r *http.Request) {
+	j, ok := jobs.cancelJob(r.PathValue("id"))
+	if !ok {
+		http.Error(w, "Job not found", http.StatusNotFound)
+		return
+	}
+	log.Printf("Job %s cancel requested by: %s", j.ID, principalFrom(r))
+	writeJSON(w, http.StatusAccepted, j)
+}
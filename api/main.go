@@ -2,6 +2,7 @@ package main
 
 import (
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/RomanBednar/install-tools/utils"
 	"gopkg.in/ini.v1"
@@ -9,6 +10,7 @@ import (
 	"net/http"
 	"os"
 	"path/filepath"
+	"strconv"
 	"strings"
 )
 
@@ -23,30 +25,22 @@ const (
 )
 
 func main() {
-	log.Println("Starting server on :8080")
-	http.HandleFunc("/save", saveInstallerConfig)
-	http.HandleFunc("/action", runAction)
-	http.HandleFunc("/log", logFileHandler)
-	http.HandleFunc("/hello", helloHandler)
+	log.SetOutput(utils.NewRedactingWriter(os.Stderr))
+	opts, err := loadServerOptions()
+	if err != nil {
+		log.Fatalf("Invalid server configuration: %v", err)
+	}
 
-	http.ListenAndServe(":8080", nil)
+	if err := listenAndServe(opts, newRouter(opts)); err != nil {
+		log.Fatalf("Server stopped: %v", err)
+	}
 }
 
 func helloHandler(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request: %#v", r)
 	fmt.Fprintln(w, "Hello, world!")
 }
 
 func runAction(w http.ResponseWriter, r *http.Request) {
-	log.Printf("Received request: %#v", r)
-	if r.Method != http.MethodPost {
-		fmt.Printf("method not allowed: %v", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	log.Printf("Received body: %#v\n", r.Body)
-
 	var action struct {
 		Action string `json:"action"`
 	}
@@ -57,77 +51,72 @@ func runAction(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	log.Printf("Received action: %#v", action)
+	log.Printf("Received action: %#v from: %s", action, principalFrom(r))
 
-	log.Printf("Loading config file location from: %v\n", locationFilePath)
-	configFilePath, err := os.ReadFile(locationFilePath)
+	config, err := loadStoredConfig()
 	if err != nil {
-		fmt.Printf("error reading config location file: %v", err)
-	}
-
-	configFile, err := os.ReadFile(string(configFilePath))
-	if err != nil {
-		fmt.Printf("error reading config file: %v", err)
-		http.Error(w, fmt.Sprintf("Error reading config file: %v", err), http.StatusInternalServerError)
+		fmt.Printf("error loading config: %v", err)
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusInternalServerError)
 		return
 	}
 
-	// Run installer
-	var config utils.Config
-	file, err := ini.Load(configFile)
-	if err != nil {
-		fmt.Printf("Failed to load config file: %v\n", err)
-		os.Exit(1)
-	}
-
-	// Unmarshal the INI file into the struct
-	if err := file.MapTo(&config); err != nil {
-		fmt.Printf("Failed to unmarshal config file: %v\n", err)
-		http.Error(w, fmt.Sprintf("Failed to unmarshal config file: %v", err), http.StatusInternalServerError)
-	}
 	// Add action to config
 	config.Action = action.Action
 
-	fmt.Printf("Running with configuration: %#v\n", config)
+	log.Printf("Running with configuration: %v", config.Redacted())
 
-	utils.Run(&config)
+	j, err := jobs.start(config, principalFrom(r))
+	if errors.Is(err, errJobRunning) {
+		http.Error(w, err.Error(), http.StatusConflict)
+		return
+	}
 
-	// Respond with success message
-	w.WriteHeader(http.StatusOK)
-	fmt.Fprintln(w, "Action received successfully")
+	// The action runs in the background, progress can be followed at /jobs/{id} and /log.
+	writeJSON(w, http.StatusAccepted, j)
 
 }
 
-func saveInstallerConfig(w http.ResponseWriter, r *http.Request) {
+// installerConfig is the configuration schema of the /save and /config endpoints.
+// VSpherePassword is write-only: it is stored in a separate file readable only by the server and
+// never returned, GET /config reports just whether it is set.
+type installerConfig struct {
+	Username                string `json:"username"`
+	SshPublicKeyFile        string `json:"sshPublicKeyFile"`
+	PullSecretFile          string `json:"pullSecretFile"`
+	OutputDir               string `json:"outputDir"`
+	ClusterName             string `json:"clusterName"`
+	Image                   string `json:"image"`
+	CloudRegion             string `json:"cloudRegion"`
+	Cloud                   string `json:"cloud"`
+	DryRun                  string `json:"dryRun"`
+	VSphereBaseDomain       string `json:"vSphereBaseDomain,omitempty"`
+	VSphereVCenterSubdomain string `json:"vSphereVCenterSubdomain,omitempty"`
+	VSphereApiVIP           string `json:"vSphereApiVIP,omitempty"`
+	VSphereIngressVIP       string `json:"vSphereIngressVIP,omitempty"`
+	VSpherePassword         string `json:"vSpherePassword,omitempty"`
+	VSpherePasswordSet      bool   `json:"vSpherePasswordSet"`
+}
 
-	type Config struct {
-		Username         string `json:"username"`
-		SshPublicKeyFile string `json:"sshPublicKeyFile"`
-		PullSecretFile   string `json:"pullSecretFile"`
-		OutputDir        string `json:"outputDir"`
-		ClusterName      string `json:"clusterName"`
-		Image            string `json:"image"`
-		CloudRegion      string `json:"cloudRegion"`
-		Cloud            string `json:"cloud"`
-		DryRun           string `json:"dryRun"`
-	}
-
-	log.Printf("Received request to store installerConfig: %#v", r)
-	if r.Method != http.MethodPost {
-		fmt.Printf("method not allowed: %v", r.Method)
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
+// vSpherePasswordFileName is the file in the output dir where the vSphere password is stored.
+const vSpherePasswordFileName = ".vsphere-password"
 
-	log.Printf("Received body: %#v\n", r.Body)
+func saveInstallerConfig(w http.ResponseWriter, r *http.Request) {
+	log.Printf("Received request to store installerConfig from: %s", principalFrom(r))
 
-	var installerConfig Config
+	var installerConfig installerConfig
 	if err := json.NewDecoder(r.Body).Decode(&installerConfig); err != nil {
 		http.Error(w, fmt.Sprintf("Error decoding request body: %v", err), http.StatusBadRequest)
 		return
 	}
 
-	log.Printf("Storing config: %#v", installerConfig)
+	if _, err := utils.ValidateClusterName(&utils.Config{
+		Cloud:       installerConfig.Cloud,
+		UserName:    installerConfig.Username,
+		ClusterName: installerConfig.ClusterName,
+	}); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
 
 	configFilePath := filepath.Join(installerConfig.OutputDir, "conf.env")
 	if err := os.MkdirAll(filepath.Dir(configFilePath), 0770); err != nil {
@@ -136,14 +125,35 @@ func saveInstallerConfig(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
-	file, err := os.OpenFile(configFilePath, os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		fmt.Printf("error opening conf.env file: %v", err)
-		http.Error(w, fmt.Sprintf("Error opening conf.env file: %v", err), http.StatusInternalServerError)
-		return
+	var vSpherePasswordFile string
+	if installerConfig.Cloud == "vsphere" {
+		// Keep the stored password if the client did not send a new one.
+		vSpherePasswordFile = filepath.Join(installerConfig.OutputDir, vSpherePasswordFileName)
+		if installerConfig.VSpherePassword != "" {
+			if err := os.WriteFile(vSpherePasswordFile, []byte(installerConfig.VSpherePassword), 0600); err != nil {
+				http.Error(w, fmt.Sprintf("Error storing vSphere password: %v", err), http.StatusInternalServerError)
+				return
+			}
+		}
+		installerConfig.VSpherePassword = ""
+		if _, err := os.Stat(vSpherePasswordFile); err != nil {
+			vSpherePasswordFile = ""
+		}
+
+		if err := utils.ValidateVSphereConfig(&utils.Config{
+			UserName:                installerConfig.Username,
+			VSphereBaseDomain:       installerConfig.VSphereBaseDomain,
+			VSphereVCenterSubdomain: installerConfig.VSphereVCenterSubdomain,
+			VSphereApiVIP:           installerConfig.VSphereApiVIP,
+			VSphereIngressVIP:       installerConfig.VSphereIngressVIP,
+			VSpherePasswordFile:     vSpherePasswordFile,
+		}); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
 	}
-	defer file.Close()
-	log.Printf("Saved config to file: %v", configFilePath)
+
+	log.Printf("Storing config: %#v", installerConfig)
 
 	format := fmt.Sprintf(`
 userName=%s
@@ -155,7 +165,12 @@ image=%s
 cloudRegion=%s
 cloud=%s
 dryRun=%s
-engine=%s`,
+engine=%s
+vSphereBaseDomain=%s
+vSphereVCenterSubdomain=%s
+vSphereApiVIP=%s
+vSphereIngressVIP=%s
+vSpherePasswordFile=%s`,
 		installerConfig.Username,
 		installerConfig.SshPublicKeyFile,
 		installerConfig.PullSecretFile,
@@ -166,19 +181,22 @@ engine=%s`,
 		installerConfig.Cloud,
 		installerConfig.DryRun,
 		defaultEngine,
+		installerConfig.VSphereBaseDomain,
+		installerConfig.VSphereVCenterSubdomain,
+		installerConfig.VSphereApiVIP,
+		installerConfig.VSphereIngressVIP,
+		vSpherePasswordFile,
 	)
 
 	format = strings.TrimSpace(format)
 	format += "\n"
 
 	// Write data to conf.env file
-	if _, err := fmt.Fprintf(
-		file,
-		format,
-	); err != nil {
+	if err := os.WriteFile(configFilePath, []byte(format), 0600); err != nil {
 		http.Error(w, fmt.Sprintf("Error writing to conf.env file: %v", err), http.StatusInternalServerError)
 		return
 	}
+	log.Printf("Saved config to file: %v", configFilePath)
 
 	// Store the config location in a file
 	if err := os.MkdirAll(filepath.Dir(locationFilePath), 0770); err != nil {
@@ -188,19 +206,9 @@ engine=%s`,
 	}
 
 	log.Printf("Storing config location to: %v\n", locationFilePath)
-	locationFile, err := os.OpenFile(locationFilePath, os.O_WRONLY|os.O_CREATE, 0644)
-	if err != nil {
-		fmt.Printf("error opening config-location file: %v", err)
-		http.Error(w, fmt.Sprintf("Error opening config-location file: %v", err), http.StatusInternalServerError)
-		return
-	}
-
-	if _, err := fmt.Fprintf(
-		locationFile,
-		configFilePath,
-	); err != nil {
+	if err := os.WriteFile(locationFilePath, []byte(configFilePath), 0644); err != nil {
 		fmt.Printf("error writing to config-location file: %v", err)
-		http.Error(w, fmt.Sprintf("Error writing to conf.env file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error writing to config-location file: %v", err), http.StatusInternalServerError)
 		return
 	}
 
@@ -210,21 +218,45 @@ engine=%s`,
 	fmt.Fprintln(w, "Config stored successfully")
 }
 
-func logFileHandler(w http.ResponseWriter, r *http.Request) {
-
-	// Ensure the request method is GET
-	if r.Method != http.MethodGet {
-		http.Error(w, "Method Not Allowed", http.StatusMethodNotAllowed)
+// getInstallerConfig returns the stored configuration, secrets are never included.
+func getInstallerConfig(w http.ResponseWriter, r *http.Request) {
+	config, err := loadStoredConfig()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusNotFound)
 		return
 	}
 
-	// Load config file location from cache.
-	locationFile, err := os.ReadFile(locationFilePath)
+	response := installerConfig{
+		Username:                config.UserName,
+		SshPublicKeyFile:        config.SshPublicKeyFile,
+		PullSecretFile:          config.PullSecretFile,
+		OutputDir:               config.OutputDir,
+		ClusterName:             config.ClusterName,
+		Image:                   config.Image,
+		CloudRegion:             config.CloudRegion,
+		Cloud:                   config.Cloud,
+		DryRun:                  strconv.FormatBool(config.DryRun),
+		VSphereBaseDomain:       config.VSphereBaseDomain,
+		VSphereVCenterSubdomain: config.VSphereVCenterSubdomain,
+		VSphereApiVIP:           config.VSphereApiVIP,
+		VSphereIngressVIP:       config.VSphereIngressVIP,
+	}
+	if config.VSpherePasswordFile != "" {
+		_, err := os.Stat(config.VSpherePasswordFile)
+		response.VSpherePasswordSet = err == nil
+	}
+
+	writeJSON(w, http.StatusOK, response)
+}
+
+func logFileHandler(w http.ResponseWriter, r *http.Request) {
+
+	config, err := loadStoredConfig()
 	if err != nil {
-		http.Error(w, fmt.Sprintf("Error reading config location file: %v", err), http.StatusInternalServerError)
+		http.Error(w, fmt.Sprintf("Error loading config: %v", err), http.StatusInternalServerError)
 		return
 	}
-	logFile := filepath.Join(string(locationFile), "/.openshift_install.log")
+	logFile := utils.InstallLogPath(config.OutputDir)
 
 	// Read the contents of the log file
 	fmt.Printf("Reading log file from: %v\n", logFile)
@@ -235,6 +267,29 @@ func logFileHandler(w http.ResponseWriter, r *http.Request) {
 	}
 
 	// Write the file contents as the response body
+	// The kubeadmin password is only served by the audited /cluster/kubeadmin-password endpoint.
 	w.Header().Set("Content-Type", "text/plain")
-	w.Write(fileContents)
+	w.Write(utils.MaskInstallLog(fileContents))
+}
+
+// loadStoredConfig loads the configuration saved by the last /save request.
+func loadStoredConfig() (*utils.Config, error) {
+	log.Printf("Loading config file location from: %v\n", locationFilePath)
+	configFilePath, err := os.ReadFile(locationFilePath)
+	if err != nil {
+		return nil, fmt.Errorf("error reading config location file: %v", err)
+	}
+
+	// ini.Load treats a string argument as a file name, a []byte would be parsed as file content.
+	file, err := ini.Load(strings.TrimSpace(string(configFilePath)))
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config file: %v", err)
+	}
+
+	// Unmarshal the INI file into the struct
+	var config utils.Config
+	if err := file.MapTo(&config); err != nil {
+		return nil, fmt.Errorf("failed to unmarshal config file: %v", err)
+	}
+	return &config, nil
 }
@@ -0,0 +1,169 @@
+package main
+
+import (
+	"fmt"
+	"log"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+
+	"github.com/RomanBednar/install-tools/utils"
+)
+
+// Server settings are read from environment variables sharing the prefix used by the CLI (INST_).
+const (
+	envListenAddr   = utils.EnvPrefix + "_API_LISTEN_ADDR"
+	envAuthMode     = utils.EnvPrefix + "_API_AUTH"
+	envToken        = utils.EnvPrefix + "_API_TOKEN"
+	envTokenFile    = utils.EnvPrefix + "_API_TOKEN_FILE"
+	envHtpasswdFile = utils.EnvPrefix + "_API_HTPASSWD_FILE"
+	envTLSCertFile  = utils.EnvPrefix + "_API_TLS_CERT_FILE"
+	envTLSKeyFile   = utils.EnvPrefix + "_API_TLS_KEY_FILE"
+	envCORSOrigins  = utils.EnvPrefix + "_API_CORS_ORIGINS"
+
+	defaultListenAddr = ":8080"
+)
+
+type serverOptions struct {
+	listenAddr  string
+	auth        authenticator
+	tlsCertFile string
+	tlsKeyFile  string
+	corsOrigins []string
+}
+
+// loadServerOptions builds server options from the environment. Authentication is mandatory unless
+// INST_API_AUTH=none is set explicitly, the server has access to cloud credentials of the host.
+func loadServerOptions() (*serverOptions, error) {
+	opts := &serverOptions{
+		listenAddr:  os.Getenv(envListenAddr),
+		tlsCertFile: os.Getenv(envTLSCertFile),
+		tlsKeyFile:  os.Getenv(envTLSKeyFile),
+	}
+	if opts.listenAddr == "" {
+		opts.listenAddr = defaultListenAddr
+	}
+	if (opts.tlsCertFile == "") != (opts.tlsKeyFile == "") {
+		return nil, fmt.Errorf("both %s and %s must be set to enable TLS", envTLSCertFile, envTLSKeyFile)
+	}
+	for _, origin := range strings.Split(os.Getenv(envCORSOrigins), ",") {
+		if origin = strings.TrimSpace(origin); origin != "" {
+			opts.corsOrigins = append(opts.corsOrigins, strings.TrimSuffix(origin, "/"))
+		}
+	}
+
+	token := os.Getenv(envToken)
+	if tokenFile := os.Getenv(envTokenFile); tokenFile != "" {
+		content, err := os.ReadFile(tokenFile)
+		if err != nil {
+			return nil, fmt.Errorf("could not read token file: %v", err)
+		}
+		token = strings.TrimSpace(string(content))
+	}
+	htpasswdFile := os.Getenv(envHtpasswdFile)
+
+	mode := os.Getenv(envAuthMode)
+	if mode == "" {
+		switch {
+		case token != "":
+			mode = "token"
+		case htpasswdFile != "":
+			mode = "htpasswd"
+		}
+	}
+
+	switch mode {
+	case "token":
+		if token == "" {
+			return nil, fmt.Errorf("token authentication requires %s or %s", envToken, envTokenFile)
+		}
+		opts.auth = &bearerAuth{token: token}
+	case "htpasswd":
+		if htpasswdFile == "" {
+			return nil, fmt.Errorf("htpasswd authentication requires %s", envHtpasswdFile)
+		}
+		auth, err := loadHtpasswd(htpasswdFile)
+		if err != nil {
+			return nil, err
+		}
+		opts.auth = auth
+	case "none":
+		log.Printf("WARNING: authentication is disabled (%s=none), anyone who can reach the server can run installations", envAuthMode)
+	case "":
+		return nil, fmt.Errorf("no authentication configured: set %s, %s or %s (or %s=none to disable)", envToken, envTokenFile, envHtpasswdFile, envAuthMode)
+	default:
+		return nil, fmt.Errorf("unknown %s value %q, valid values are: token, htpasswd, none", envAuthMode, mode)
+	}
+
+	return opts, nil
+}
+
+// newRouter registers all endpoints with the methods they accept. Requests with other methods are
+// answered with 405 by the mux itself.
+func newRouter(opts *serverOptions) http.Handler {
+	mux := http.NewServeMux()
+	protected := func(h http.HandlerFunc) http.Handler { return requireAuth(opts.auth, h) }
+
+	mux.Handle("GET /hello", http.HandlerFunc(helloHandler))
+	mux.Handle("POST /save", protected(saveInstallerConfig))
+	mux.Handle("GET /config", protected(getInstallerConfig))
+	mux.Handle("POST /action", protected(runAction))
+	mux.Handle("GET /log", protected(logFileHandler))
+	mux.Handle("GET /jobs", protected(listJobsHandler))
+	mux.Handle("GET /jobs/{id}", protected(getJobHandler))
+	mux.Handle("DELETE /jobs/{id}", protected(cancelJobHandler))
+	mux.Handle("GET /cluster", protected(clusterInfoHandler))
+	mux.Handle("GET /cluster/kubeconfig", protected(kubeconfigHandler))
+	mux.Handle("POST /cluster/kubeadmin-password", protected(kubeadminPasswordHandler))
+
+	return withCORS(opts.corsOrigins, withRequestLog(mux))
+}
+
+// withRequestLog logs every request without its headers, those may contain credentials.
+func withRequestLog(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		log.Printf("Received request: remote=%s method=%s path=%s", r.RemoteAddr, r.Method, r.URL.Path)
+		next.ServeHTTP(w, r)
+	})
+}
+
+// withCORS answers preflight requests and sets CORS headers for origins on the allowlist. Browser
+// requests from other origins are rejected whatever their method, a simple cross-origin POST would
+// otherwise reach the handler with credentials the browser remembered for the API.
+func withCORS(allowed []string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		if origin == "" {
+			next.ServeHTTP(w, r)
+			return
+		}
+		w.Header().Add("Vary", "Origin")
+		if !slices.Contains(allowed, origin) {
+			log.Printf("Rejected %s request from origin: %s", r.Method, origin)
+			http.Error(w, "Origin not allowed", http.StatusForbidden)
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Credentials", "true")
+		if r.Method == http.MethodOptions && r.Header.Get("Access-Control-Request-Method") != "" {
+			w.Header().Set("Access-Control-Allow-Methods", "GET, POST, DELETE, OPTIONS")
+			w.Header().Set("Access-Control-Allow-Headers", "Authorization, Content-Type")
+			w.Header().Set("Access-Control-Max-Age", "600")
+			w.WriteHeader(http.StatusNoContent)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func listenAndServe(opts *serverOptions, handler http.Handler) error {
+	server := &http.Server{Addr: opts.listenAddr, Handler: handler}
+	if opts.tlsCertFile != "" {
+		log.Printf("Starting server with TLS on %s", opts.listenAddr)
+		return server.ListenAndServeTLS(opts.tlsCertFile, opts.tlsKeyFile)
+	}
+	log.Printf("Starting server on %s", opts.listenAddr)
+	return server.ListenAndServe()
+}
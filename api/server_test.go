@@ -0,0 +1,77 @@
+package main
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestWithCORS(t *testing.T) {
+	var served bool
+	handler := withCORS([]string{"https://console.example.com"}, http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		served = true
+	}))
+
+	tests := []struct {
+		name        string
+		method      string
+		origin      string
+		preflight   bool
+		code        int
+		served      bool
+		allowOrigin string
+	}{
+		{name: "same origin", method: http.MethodPost, code: http.StatusOK, served: true},
+		{
+			name:        "allowlisted origin",
+			method:      http.MethodGet,
+			origin:      "https://console.example.com",
+			code:        http.StatusOK,
+			served:      true,
+			allowOrigin: "https://console.example.com",
+		},
+		{name: "rejected origin", method: http.MethodGet, origin: "https://evil.example.com", code: http.StatusForbidden},
+		{name: "rejected simple POST", method: http.MethodPost, origin: "https://evil.example.com", code: http.StatusForbidden},
+		{
+			name:        "preflight",
+			method:      http.MethodOptions,
+			origin:      "https://console.example.com",
+			preflight:   true,
+			code:        http.StatusNoContent,
+			allowOrigin: "https://console.example.com",
+		},
+		{name: "rejected preflight", method: http.MethodOptions, origin: "https://evil.example.com", preflight: true, code: http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			served = false
+			r := httptest.NewRequest(tt.method, "/api/v1/jobs", nil)
+			if tt.origin != "" {
+				r.Header.Set("Origin", tt.origin)
+			}
+			if tt.preflight {
+				r.Header.Set("Access-Control-Request-Method", http.MethodDelete)
+			}
+			w := httptest.NewRecorder()
+			handler.ServeHTTP(w, r)
+
+			if w.Code != tt.code {
+				t.Errorf("expected status %v, got %v", tt.code, w.Code)
+			}
+			if served != tt.served {
+				t.Errorf("expected handler served %v, got %v", tt.served, served)
+			}
+			if got := w.Header().Get("Access-Control-Allow-Origin"); got != tt.allowOrigin {
+				t.Errorf("expected Access-Control-Allow-Origin %q, got %q", tt.allowOrigin, got)
+			}
+			if tt.preflight && tt.code == http.StatusNoContent {
+				if got := w.Header().Get("Access-Control-Allow-Methods"); got != "GET, POST, DELETE, OPTIONS" {
+					t.Errorf("unexpected Access-Control-Allow-Methods %q", got)
+				}
+				if got := w.Header().Get("Access-Control-Allow-Headers"); got != "Authorization, Content-Type" {
+					t.Errorf("unexpected Access-Control-Allow-Headers %q", got)
+				}
+			}
+		})
+	}
+}
@@ -0,0 +1,103 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"os/signal"
+	"path/filepath"
+	"syscall"
+	"text/tabwriter"
+	"time"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var batchCmd = &cobra.Command{
+	Use:   "batch",
+	Short: "Install many clusters at once",
+}
+
+var batchCreateCmd = &cobra.Command{
+	Use:   "create -f <matrix.yaml>",
+	Short: "Install every combination of cloud variants, releases and regions of a matrix",
+	Long: `Expand the matrix file (see matrix.yaml.template) into runs: every variant is installed with every release in
+every region. A run gets its own output dir <outputDir>/<variant>-<release>-<region> and a generated cluster name, the
+tool runs for it as a separate process with output in batch.log of the run. At most --parallelism runs are active at
+a time. A JSON report of all runs is written to the output dir and the command fails if any run failed.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		path, _ := cmd.Flags().GetString("config-path")
+		m, err := utils.LoadBatchMatrix(path)
+		if err != nil {
+			return err
+		}
+		if m.OutputDir == "" {
+			m.OutputDir = viper.GetString("outputdir")
+		}
+		if cmd.Flags().Changed("parallelism") {
+			m.Parallelism, _ = cmd.Flags().GetInt("parallelism")
+		}
+		m.DryRun = m.DryRun || viper.GetBool("dryrun")
+		for _, v := range m.Variants {
+			for name := range v.Flags {
+				if rootCmd.PersistentFlags().Lookup(name) == nil {
+					return fmt.Errorf("variant %q sets unknown flag --%s", v.Name, name)
+				}
+			}
+		}
+		tool, err := os.Executable()
+		if err != nil {
+			return err
+		}
+
+		runs := m.Runs()
+		for _, run := range runs {
+			if _, err := os.Stat(run.OutputDir); err == nil {
+				return fmt.Errorf("output dir %v of run %s already exists, remove it or use another outputDir", run.OutputDir, run.Name)
+			}
+		}
+		fmt.Printf("Running %d installations, %d at a time, in %s\n", len(runs), max(m.Parallelism, 1), m.OutputDir)
+		// Ctrl-C interrupts every active run, each of them stops its installer.
+		ctx, stop := signal.NotifyContext(cmd.Context(), os.Interrupt, syscall.SIGTERM)
+		results := utils.RunBatch(ctx, tool, runs, m.Parallelism)
+		stop()
+
+		report := filepath.Join(m.OutputDir, "batch-report-"+time.Now().Format("20060102-150405")+".json")
+		if err := utils.WriteBatchReport(report, results); err != nil {
+			fmt.Fprintf(os.Stderr, "Warning: could not write report: %v\n", err)
+		}
+
+		failed := 0
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "RUN\tCLUSTER\tRESULT\tDURATION\tLOG")
+		for _, r := range results {
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", r.Name, r.ClusterName, r.Result, r.Duration, r.Log)
+			if r.Result != utils.ResultSucceeded {
+				failed++
+			}
+		}
+		w.Flush()
+		for _, r := range results {
+			if r.Error != "" {
+				fmt.Printf("%s: %s\n", r.Name, r.Error)
+			}
+		}
+		fmt.Printf("Report: %s\n", report)
+		if failed > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("%d of %d runs did not succeed", failed, len(results))
+		}
+		return nil
+	},
+}
+
+func init() {
+	// Shadows the persistent --config-path, a batch is configured by its matrix and the config files it names.
+	batchCreateCmd.Flags().StringP("config-path", "f", "", "Matrix file of the batch, see matrix.yaml.template.")
+	batchCreateCmd.MarkFlagRequired("config-path")
+	batchCreateCmd.Flags().Int("parallelism", 1, "How many runs may be active at a time, overrides parallelism of the matrix.")
+	batchCmd.AddCommand(batchCreateCmd)
+	rootCmd.AddCommand(batchCmd)
+}
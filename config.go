@@ -0,0 +1,306 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/pflag"
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// flagKeys maps viper keys to names of the flags bound to them.
+var flagKeys = map[string]string{}
+
+func bindFlag(key, flagName string) {
+	flagKeys[key] = flagName
+	viper.BindPFlag(key, rootCmd.PersistentFlags().Lookup(flagName))
+}
+
+// configKey describes one field of utils.Config.
+type configKey struct {
+	field string // Go field name
+	key   string // viper key, lowercase field name
+	name  string // name used in conf.env
+}
+
+func configKeys() []configKey {
+	var keys []configKey
+	t := reflect.TypeOf(utils.Config{})
+	for i := 0; i < t.NumField(); i++ {
+		f := t.Field(i)
+		keys = append(keys, configKey{field: f.Name, key: strings.ToLower(f.Name), name: f.Tag.Get("ini")})
+	}
+	return keys
+}
+
+func findConfigKey(name string) (configKey, bool) {
+	for _, k := range configKeys() {
+		if strings.EqualFold(k.name, name) || strings.EqualFold(k.field, name) || k.key == strings.ToLower(name) {
+			return k, true
+		}
+	}
+	return configKey{}, false
+}
+
+// configLayer is one source of configuration values. Layers are ordered from the lowest to the highest priority.
+type configLayer struct {
+	source string
+	// applied is false for layers viper does not read, e.g. config files shadowed by another one.
+	applied bool
+	values  map[string]string
+}
+
+// configLayers reconstructs where configuration values come from, following the precedence viper uses:
+// flag defaults < config file or profiles < INST_* environment variables < flags set on the command line.
+func configLayers(flags *pflag.FlagSet) []configLayer {
+	defaults := configLayer{source: "default", applied: true, values: map[string]string{}}
+	for key, name := range flagKeys {
+		defaults.values[key] = flags.Lookup(name).DefValue
+	}
+	layers := append([]configLayer{defaults}, fileLayers()...)
+
+	env := configLayer{source: "env", applied: true, values: map[string]string{}}
+	for _, k := range configKeys() {
+		if value, ok := os.LookupEnv(envName(k.key)); ok {
+			env.values[k.key] = value
+		}
+	}
+	layers = append(layers, env)
+
+	cli := configLayer{source: "flag", applied: true, values: map[string]string{}}
+	for key, name := range flagKeys {
+		if f := flags.Lookup(name); f != nil && f.Changed {
+			cli.values[key] = f.Value.String()
+		}
+	}
+	return append(layers, cli)
+}
+
+// fileLayers returns config file layers, lowest priority first.
+func fileLayers() []configLayer {
+	// Profiles replace conf.env, every file in the extends chain is loaded.
+	if len(profileFiles) > 0 {
+		var files []configLayer
+		for _, path := range profileFiles {
+			values, err := readConfigFile(path)
+			if err != nil {
+				continue
+			}
+			files = append(files, configLayer{source: "profile " + path, applied: true, values: values})
+		}
+		return files
+	}
+
+	if file := customConfigFile(); file != "" {
+		path, _ := filepath.Abs(file)
+		values, err := readConfigFile(path)
+		if err != nil {
+			return nil
+		}
+		return []configLayer{{source: "file " + path, applied: true, values: values}}
+	}
+
+	// Viper reads only the first config file it finds, others are listed so it's visible they are ignored.
+	used, _ := filepath.Abs(viper.ConfigFileUsed())
+	var files []configLayer
+	for _, dir := range configSearchPaths() {
+		path, _ := filepath.Abs(filepath.Join(os.ExpandEnv(dir), utils.DefaultConfigFilename+".env"))
+		values, err := readConfigFile(path)
+		if err != nil {
+			continue
+		}
+		layer := configLayer{source: "file " + path, applied: path == used, values: values}
+		if !layer.applied {
+			layer.source += " (not loaded, shadowed by a higher priority file)"
+		}
+		files = append([]configLayer{layer}, files...)
+	}
+	return files
+}
+
+// customConfigFile returns --config-path if it names a file rather than a directory to search for conf.env.
+func customConfigFile() string {
+	custom := os.ExpandEnv(viper.GetString("configpath"))
+	if info, err := os.Stat(custom); custom == "" || err != nil || info.IsDir() {
+		return ""
+	}
+	return custom
+}
+
+// configSearchPaths returns directories searched for conf.env, highest priority first.
+func configSearchPaths() []string {
+	if custom := viper.GetString("configpath"); custom != "" {
+		return append([]string{custom}, utils.ConfigPaths...)
+	}
+	return utils.ConfigPaths
+}
+
+func readConfigFile(path string) (map[string]string, error) {
+	v := viper.New()
+	v.SetConfigFile(path)
+	v.SetConfigType("env")
+	if err := v.ReadInConfig(); err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, key := range v.AllKeys() {
+		values[key] = v.GetString(key)
+	}
+	return values, nil
+}
+
+func envName(key string) string {
+	return strings.ToUpper(utils.EnvPrefix + "_" + key)
+}
+
+// describeSource names the layer in a way that tells the user where to change the value.
+func describeSource(layer configLayer, key string) string {
+	switch layer.source {
+	case "env":
+		return "env " + envName(key)
+	case "flag":
+		return "flag --" + flagKeys[key]
+	case "default":
+		return "default"
+	}
+	return layer.source
+}
+
+type configEntry struct {
+	Key    string `json:"key" yaml:"key"`
+	Value  string `json:"value" yaml:"value"`
+	Source string `json:"source" yaml:"source"`
+}
+
+type layerEntry struct {
+	Source  string `json:"source" yaml:"source"`
+	Value   string `json:"value" yaml:"value"`
+	Applied bool   `json:"applied" yaml:"applied"`
+}
+
+type explanation struct {
+	Key    string       `json:"key" yaml:"key"`
+	Value  string       `json:"value" yaml:"value"`
+	Source string       `json:"source" yaml:"source"`
+	Layers []layerEntry `json:"layers" yaml:"layers"`
+}
+
+func displayValue(k configKey, value string) string {
+	if value != "" && utils.IsSecretField(k.field) {
+		return utils.RedactedValue
+	}
+	return value
+}
+
+// effectiveSource returns the highest priority applied layer that sets the key.
+func effectiveSource(layers []configLayer, key string) string {
+	for i := len(layers) - 1; i >= 0; i-- {
+		if _, ok := layers[i].values[key]; ok && layers[i].applied {
+			return describeSource(layers[i], key)
+		}
+	}
+	return "unset"
+}
+
+func configEntries(c *utils.Config, flags *pflag.FlagSet) []configEntry {
+	layers := configLayers(flags)
+	v := reflect.ValueOf(c.Redacted())
+	var entries []configEntry
+	for _, k := range configKeys() {
+		entries = append(entries, configEntry{
+			Key:    k.name,
+			Value:  fmt.Sprint(v.FieldByName(k.field).Interface()),
+			Source: effectiveSource(layers, k.key),
+		})
+	}
+	return entries
+}
+
+func explainKey(c *utils.Config, k configKey, flags *pflag.FlagSet) explanation {
+	layers := configLayers(flags)
+	e := explanation{
+		Key:    k.name,
+		Value:  fmt.Sprint(reflect.ValueOf(c.Redacted()).FieldByName(k.field).Interface()),
+		Source: effectiveSource(layers, k.key),
+		Layers: []layerEntry{},
+	}
+	for _, layer := range layers {
+		if value, ok := layer.values[k.key]; ok {
+			e.Layers = append(e.Layers, layerEntry{Source: describeSource(layer, k.key), Value: displayValue(k, value), Applied: layer.applied})
+		}
+	}
+	return e
+}
+
+func encode(w io.Writer, v any, format string) error {
+	switch format {
+	case "yaml":
+		enc := yaml.NewEncoder(w)
+		enc.SetIndent(2)
+		return enc.Encode(v)
+	case "json":
+		enc := json.NewEncoder(w)
+		enc.SetIndent("", "  ")
+		return enc.Encode(v)
+	}
+	return fmt.Errorf("unsupported output format %q, valid values are: yaml, json", format)
+}
+
+// printConfig prints the configuration with sources, flags are the flags of the command being run.
+func printConfig(w io.Writer, c *utils.Config, flags *pflag.FlagSet, format string) error {
+	return encode(w, configEntries(c, flags), format)
+}
+
+func loadConfig() (*utils.Config, error) {
+	var c utils.Config
+	if err := viper.Unmarshal(&c); err != nil {
+		return nil, fmt.Errorf("error unmarshalling config: %v", err)
+	}
+	return &c, nil
+}
+
+var configCmd = &cobra.Command{
+	Use:   "config",
+	Short: "Inspect the configuration",
+}
+
+var configShowCmd = &cobra.Command{
+	Use:   "show",
+	Short: "Show the effective configuration and where each value comes from",
+	Long: `Show the effective configuration with the source of every value: default, config file,
+INST_* environment variable or command line flag. Secrets are masked.
+
+Use --explain <key> to list every layer that sets the key, including config files viper does not load.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		format, _ := cmd.Flags().GetString("format")
+		explain, _ := cmd.Flags().GetString("explain")
+		if explain == "" {
+			return printConfig(cmd.OutOrStdout(), c, cmd.Flags(), format)
+		}
+		k, ok := findConfigKey(explain)
+		if !ok {
+			return fmt.Errorf("unknown configuration key %q", explain)
+		}
+		return encode(cmd.OutOrStdout(), explainKey(c, k, cmd.Flags()), format)
+	},
+}
+
+func init() {
+	configShowCmd.Flags().String("format", "yaml", "Output format. Valid values are: yaml, json.")
+	configShowCmd.Flags().String("explain", "", "Show every configuration layer that sets the given key.")
+	configCmd.AddCommand(configShowCmd)
+	rootCmd.AddCommand(configCmd)
+}
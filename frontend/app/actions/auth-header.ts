@@ -0,0 +1,6 @@
+// Builds the Authorization header for backend requests. The token is only read on the server,
+// so it is never shipped to the browser.
+export function authHeader(): Record<string, string> {
+    const token = process.env.INST_API_TOKEN;
+    return token ? { 'Authorization': `Bearer ${token}` } : {};
+}
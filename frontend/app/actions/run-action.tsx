@@ -1,5 +1,7 @@
 'use server'
 
+import { authHeader } from '@/app/actions/auth-header';
+
 export async function runAction(action: string) {
 
     let result : Response | any;
@@ -10,7 +12,7 @@ export async function runAction(action: string) {
         console.log("Connecting to:", apiUrl)
         const response = await fetch(`${apiUrl}/action`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+            headers: { 'Content-Type': 'application/x-www-form-urlencoded', ...authHeader() },
             body: requestBody,
         });
         result = response;
@@ -1,5 +1,7 @@
 'use server'
 
+import { authHeader } from '@/app/actions/auth-header';
+
 export async function saveConfig(previousState: any, formData: { get: (arg0: string) => any; }) {
     console.log('formData:', formData);
     const username = formData.get('username');
@@ -30,7 +32,7 @@ export async function saveConfig(previousState: any, formData: { get: (arg0: str
         console.log("Connecting to:", apiUrl)
         const response = await fetch(`${apiUrl}/save`, {
             method: 'POST',
-            headers: { 'Content-Type': 'application/x-www-form-urlencoded' },
+            headers: { 'Content-Type': 'application/x-www-form-urlencoded', ...authHeader() },
             body: requestBody,
         });
         result = {message: "OK", success: true};
@@ -1,9 +1,11 @@
+import { authHeader } from '@/app/actions/auth-header';
+
 export const dynamic = 'force-dynamic';
 
 async function getData() {
     const apiUrl = process.env.NEXT_PUBLIC_API_URL;
     console.log("Connecting to:", apiUrl)
-    const res = await fetch(`${apiUrl}/log`)
+    const res = await fetch(`${apiUrl}/log`, { headers: authHeader() })
     if (!res.ok) {
         throw new Error('Failed to fetch data')
     }
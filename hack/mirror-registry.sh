@@ -0,0 +1,77 @@
+#!/bin/bash
+
+# Starts a local registry to test installations with mirrorRegistry. It creates a self-signed certificate and
+# credentials in $DIR and prints the configuration values to use. Cluster nodes pull from the registry, so $HOST
+# has to be reachable from the cluster network, for a --dry-run any host works.
+
+usage() {
+    echo "Usage: $0 [start|stop]"
+    echo "Environment: ENGINE (podman), HOST ($(hostname -f)), PORT (5000), DIR (\$HOME/.install-tools/mirror-registry)"
+    exit 1
+}
+
+set -euo pipefail
+
+ENGINE=${ENGINE:-podman}
+HOST=${HOST:-$(hostname -f)}
+PORT=${PORT:-5000}
+DIR=${DIR:-$HOME/.install-tools/mirror-registry}
+NAME=install-tools-mirror-registry
+REGISTRY_USER=install-tools
+
+start() {
+    mkdir -p "$DIR/certs" "$DIR/auth" "$DIR/data"
+    chmod 700 "$DIR"
+
+    if [ ! -f "$DIR/certs/ca.crt" ]; then
+        echo "Creating self-signed certificate for $HOST"
+        openssl req -x509 -newkey rsa:4096 -nodes -days 30 -subj "/CN=$HOST" \
+            -addext "subjectAltName=DNS:$HOST,DNS:localhost,IP:127.0.0.1" \
+            -keyout "$DIR/certs/registry.key" -out "$DIR/certs/ca.crt"
+    fi
+
+    if [ ! -f "$DIR/auth/htpasswd" ]; then
+        echo "Creating credentials for user $REGISTRY_USER"
+        openssl rand -hex 16 > "$DIR/password"
+        chmod 600 "$DIR/password"
+        "$ENGINE" run --rm --entrypoint htpasswd docker.io/httpd:2 -Bbn "$REGISTRY_USER" "$(cat "$DIR/password")" > "$DIR/auth/htpasswd"
+    fi
+
+    AUTH=$(printf '%s:%s' "$REGISTRY_USER" "$(cat "$DIR/password")" | base64 -w0)
+    printf '{"auths":{"%s:%s":{"auth":"%s"}}}\n' "$HOST" "$PORT" "$AUTH" > "$DIR/auth.json"
+    chmod 600 "$DIR/auth.json"
+
+    if "$ENGINE" inspect "$NAME" >/dev/null 2>&1; then
+        echo "Registry $NAME is already running"
+    else
+        "$ENGINE" run -d --name "$NAME" -p "$PORT:5000" \
+            -v "$DIR/data:/var/lib/registry:z" \
+            -v "$DIR/certs:/certs:z" \
+            -v "$DIR/auth:/auth:z" \
+            -e REGISTRY_HTTP_TLS_CERTIFICATE=/certs/ca.crt \
+            -e REGISTRY_HTTP_TLS_KEY=/certs/registry.key \
+            -e REGISTRY_AUTH=htpasswd \
+            -e REGISTRY_AUTH_HTPASSWD_REALM=install-tools \
+            -e REGISTRY_AUTH_HTPASSWD_PATH=/auth/htpasswd \
+            docker.io/library/registry:2
+    fi
+
+    echo
+    echo "Add to conf.env:"
+    echo "mirrorRegistry=$HOST:$PORT/ocp/release"
+    echo "mirrorAuthFile=$DIR/auth.json"
+    echo "additionalTrustBundleFile=$DIR/certs/ca.crt"
+    echo
+    echo "oc has to trust the certificate to push, or set mirrorInsecure=true."
+}
+
+stop() {
+    "$ENGINE" rm -f "$NAME"
+    echo "Registry stopped, mirrored images are kept in $DIR/data"
+}
+
+case "${1:-start}" in
+    start) start ;;
+    stop) stop ;;
+    *) usage ;;
+esac
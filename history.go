@@ -0,0 +1,92 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+var historyCmd = &cobra.Command{
+	Use:   "history",
+	Short: "Inspect previous renders of install-config.yaml and manifests",
+	Long: `Every run keeps install-config.yaml and the manifests passed to create cluster, without secrets,
+in <cluster-output-dir>/.install-tools/history/<timestamp>/. Upgrades keep upgrade.yaml there.`,
+}
+
+var historyListCmd = &cobra.Command{
+	Use:   "list <cluster-output-dir>",
+	Short: "List renders and upgrades kept in an output dir",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := utils.History(args[0])
+		if err != nil {
+			return err
+		}
+		if len(entries) == 0 {
+			fmt.Fprintf(os.Stderr, "No renders found in %s\n", args[0])
+			return nil
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "ENTRY\tKIND\tMANIFESTS\tPATH")
+		for _, e := range entries {
+			kind := "render"
+			if e.Upgrade {
+				kind = "upgrade"
+			}
+			manifests := "no"
+			if e.Manifests {
+				manifests = "yes"
+			}
+			fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", e.Name, kind, manifests, e.Path)
+		}
+		return w.Flush()
+	},
+}
+
+var historyDiffCmd = &cobra.Command{
+	Use:   "diff <cluster-output-dir> [<from> <to>]",
+	Short: "Show differences between two renders, the last two by default",
+	Long: `Show a unified diff between two renders listed by "history list". Renders are given by name or
+counted from the newest: -1 is the newest render, -2 the one before. Upgrades are skipped.`,
+	Args: func(cmd *cobra.Command, args []string) error {
+		if len(args) != 1 && len(args) != 3 {
+			return fmt.Errorf("expected an output dir and optionally two renders, got %d arguments", len(args))
+		}
+		return nil
+	},
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := utils.History(args[0])
+		if err != nil {
+			return err
+		}
+		entries = utils.Renders(entries)
+		names := []string{"-2", "-1"}
+		if len(args) == 3 {
+			names = args[1:]
+		}
+		from, err := utils.FindHistoryEntry(entries, names[0])
+		if err != nil {
+			return err
+		}
+		to, err := utils.FindHistoryEntry(entries, names[1])
+		if err != nil {
+			return err
+		}
+		differ, err := utils.DiffHistory(cmd.Context(), from, to, cmd.OutOrStdout())
+		if err != nil {
+			return err
+		}
+		if !differ {
+			fmt.Fprintf(os.Stderr, "Renders %s and %s are identical.\n", from.Name, to.Name)
+		}
+		return nil
+	},
+}
+
+func init() {
+	historyCmd.AddCommand(historyListCmd, historyDiffCmd)
+	rootCmd.AddCommand(historyCmd)
+}
@@ -0,0 +1,32 @@
+package main
+
+import (
+	"errors"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+	"github.com/spf13/viper"
+)
+
+var runHooksCmd = &cobra.Command{
+	Use:   "run-hooks <cluster-output-dir>",
+	Short: "Run post-install hooks against an installed cluster",
+	Long: `Run the hooks from --hooks-file against the cluster installed in the given output dir, e.g. to retry
+hooks that failed during installation. Output of every hook is written to <cluster-output-dir>/hooks/.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		hooksFile := viper.GetString("hooksfile")
+		if hooksFile == "" {
+			return errors.New("no hooks file configured, use --hooks-file")
+		}
+		hooks, err := utils.LoadHooks(hooksFile)
+		if err != nil {
+			return err
+		}
+		return utils.RunHooks(cmd.Context(), args[0], hooks)
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(runHooksCmd)
+}
@@ -0,0 +1,79 @@
+package main
+
+import (
+	"encoding/json"
+	"fmt"
+	"text/tabwriter"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+// lintReport is the JSON output of the lint command.
+type lintReport struct {
+	Valid         bool                `json:"valid"`
+	Findings      []utils.LintFinding `json:"findings"`
+	InstallConfig string              `json:"installConfig,omitempty"`
+}
+
+var lintCmd = &cobra.Command{
+	Use:   "lint",
+	Short: "Validate the configuration and the install-config.yaml rendered from it",
+	Long: `Render install-config.yaml for the current configuration in memory and check it for mistakes that would
+fail the installation later: SSH key and pull secret files, empty registry auths, networking and proxy settings, region names,
+name lengths, credentialsMode: Manual without a ccoctl cloud variant, vSphere VIPs and required settings of IBM Cloud,
+Nutanix, OpenStack and Power VS. No cloud API is called and no image is pulled, so --print shows what would be
+installed on any cloud without an account. Exits with an error if any check fails, warnings don't.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		c, err := loadConfig()
+		if err != nil {
+			return err
+		}
+		format, _ := cmd.Flags().GetString("format")
+		printConfig, _ := cmd.Flags().GetBool("print")
+		findings, rendered := utils.LintRender(*c)
+		errors := utils.LintErrors(findings)
+		if !printConfig {
+			rendered = nil
+		}
+
+		switch format {
+		case "text":
+			if rendered != nil {
+				fmt.Fprintf(cmd.OutOrStdout(), "%s\n---\n", rendered)
+			}
+			w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+			for _, f := range findings {
+				fmt.Fprintf(w, "%s\t%s\t%s\n", f.Severity, f.Check, f.Message)
+			}
+			fmt.Fprintf(w, "%d errors, %d warnings\n", errors, len(findings)-errors)
+			if err := w.Flush(); err != nil {
+				return err
+			}
+		case "json":
+			if findings == nil {
+				findings = []utils.LintFinding{}
+			}
+			enc := json.NewEncoder(cmd.OutOrStdout())
+			enc.SetIndent("", "  ")
+			if err := enc.Encode(lintReport{Valid: errors == 0, Findings: findings, InstallConfig: string(rendered)}); err != nil {
+				return err
+			}
+		default:
+			return fmt.Errorf("unsupported output format %q, valid values are: text, json", format)
+		}
+
+		if errors > 0 {
+			cmd.SilenceUsage = true
+			return fmt.Errorf("configuration has %d errors", errors)
+		}
+		return nil
+	},
+}
+
+func init() {
+	lintCmd.Flags().String("format", "text", "Output format. Valid values are: text, json.")
+	lintCmd.Flags().Bool("print", false, "Print the rendered install-config.yaml with secrets redacted.")
+	rootCmd.AddCommand(lintCmd)
+}
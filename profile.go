@@ -0,0 +1,109 @@
+package main
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+// profileEntries returns values of the profile with the extends chain applied and the profile file
+// that sets each value. Secrets are masked.
+func profileEntries(name string) ([]configEntry, error) {
+	files, err := utils.ResolveProfile(name)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]configEntry{}
+	for _, file := range files {
+		fileValues, err := utils.ReadConfigFile(file)
+		if err != nil {
+			return nil, err
+		}
+		for key, value := range fileValues {
+			if k, ok := findConfigKey(key); ok {
+				value = displayValue(k, value)
+			}
+			values[key] = configEntry{Key: key, Value: value, Source: "profile " + file}
+		}
+	}
+	var entries []configEntry
+	for _, e := range values {
+		entries = append(entries, e)
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Key < entries[j].Key })
+	return entries, nil
+}
+
+var profileCmd = &cobra.Command{
+	Use:   "profile",
+	Short: "Manage configuration profiles",
+	Long: fmt.Sprintf(`Profiles are conf.env style files in %s selected with --profile <name>.
+A profile can set extends=<name> to inherit values of another profile, values of the extending
+profile win. INST_* environment variables and flags still override profile values.`, utils.ProfilesPath),
+}
+
+var profileListCmd = &cobra.Command{
+	Use:   "list",
+	Short: "List profiles",
+	Args:  cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		names, err := utils.ListProfiles()
+		if err != nil {
+			return err
+		}
+		if len(names) == 0 {
+			fmt.Fprintf(os.Stderr, "No profiles found in %s\n", os.ExpandEnv(utils.ProfilesPath))
+			return nil
+		}
+		w := tabwriter.NewWriter(cmd.OutOrStdout(), 0, 4, 2, ' ', 0)
+		fmt.Fprintln(w, "NAME\tEXTENDS")
+		for _, name := range names {
+			path, _ := utils.ProfilePath(name)
+			values, err := utils.ReadConfigFile(path)
+			if err != nil {
+				fmt.Fprintf(w, "%s\t(invalid: %v)\n", name, err)
+				continue
+			}
+			fmt.Fprintf(w, "%s\t%s\n", name, values[utils.ProfileExtendsKey])
+		}
+		return w.Flush()
+	},
+}
+
+var profileShowCmd = &cobra.Command{
+	Use:   "show <name>",
+	Short: "Show values of a profile including inherited ones",
+	Args:  cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		entries, err := profileEntries(args[0])
+		if err != nil {
+			return err
+		}
+		format, _ := cmd.Flags().GetString("format")
+		return encode(cmd.OutOrStdout(), entries, format)
+	},
+}
+
+var profileCopyCmd = &cobra.Command{
+	Use:   "copy <source> <destination>",
+	Short: "Create a new profile from an existing one",
+	Args:  cobra.ExactArgs(2),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if err := utils.CopyProfile(args[0], args[1]); err != nil {
+			return err
+		}
+		path, _ := utils.ProfilePath(args[1])
+		fmt.Printf("Profile %s created: %s\n", args[1], path)
+		return nil
+	},
+}
+
+func init() {
+	profileShowCmd.Flags().String("format", "yaml", "Output format. Valid values are: yaml, json.")
+	profileCmd.AddCommand(profileListCmd, profileShowCmd, profileCopyCmd)
+	rootCmd.AddCommand(profileCmd)
+}
@@ -0,0 +1,45 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+var upgradeCmd = &cobra.Command{
+	Use:   "upgrade <cluster-output-dir>",
+	Short: "Upgrade an installed cluster",
+	Long: `Upgrade the cluster installed into the output dir with oc adm upgrade, using oc and auth/kubeconfig from there.
+The target is either an explicit release given by --to-image, or a version from the cluster's update channel given
+by --channel and --to, the latest one if --to is omitted. The rollout is followed until it completes and the cluster
+is verified, the upgrade is recorded in the inventory and history of the output dir.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		var opts utils.UpgradeOptions
+		opts.ToImage, _ = cmd.Flags().GetString("to-image")
+		opts.Channel, _ = cmd.Flags().GetString("channel")
+		opts.Version, _ = cmd.Flags().GetString("to")
+		opts.AllowExplicitUpgrade, _ = cmd.Flags().GetBool("allow-explicit-upgrade")
+		opts.Force, _ = cmd.Flags().GetBool("force")
+		opts.Timeout, _ = cmd.Flags().GetDuration("timeout")
+		if err := utils.UpgradeCluster(cmd.Context(), utils.DefaultExecutor, args[0], opts); err != nil {
+			return err
+		}
+		fmt.Println("Cluster upgraded.")
+		return nil
+	},
+}
+
+func init() {
+	upgradeCmd.Flags().String("to-image", "", "Pull spec or version of the release to upgrade to.")
+	upgradeCmd.Flags().String("channel", "", "Update channel to switch the cluster to before upgrading, e.g. stable-4.17.")
+	upgradeCmd.Flags().String("to", "", "Version from the available updates of the channel to upgrade to, the latest one if empty.")
+	upgradeCmd.Flags().Bool("allow-explicit-upgrade", false, "Upgrade to --to-image even if it's not one of the available updates.")
+	upgradeCmd.Flags().Bool("force", false, "Skip verification of the release signature and upgrade preconditions, and upgrade a cluster that is still progressing.")
+	upgradeCmd.Flags().Duration("timeout", utils.DefaultUpgradeTimeout, "How long to wait for the upgrade to complete.")
+	upgradeCmd.MarkFlagsMutuallyExclusive("to-image", "channel")
+	upgradeCmd.MarkFlagsMutuallyExclusive("to-image", "to")
+	upgradeCmd.MarkFlagsOneRequired("to-image", "channel", "to")
+	rootCmd.AddCommand(upgradeCmd)
+}
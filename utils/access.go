@@ -0,0 +1,99 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+)
+
+const (
+	installLogFile        = ".openshift_install.log"
+	kubeconfigFile        = "auth/kubeconfig"
+	kubeadminPasswordFile = "auth/kubeadmin-password"
+
+	// toolDir keeps files of this tool in the output directory, apart from the files of openshift-install.
+	toolDir = ".install-tools"
+	// historyDir keeps a copy of every render of install-config.yaml and of the manifests that were installed,
+	// without secrets, in a directory per render named by its timestamp.
+	historyDir = toolDir + "/history"
+)
+
+var (
+	consoleURLPattern       = regexp.MustCompile(`web-console here: (https?://[^"\s\\]+)`)
+	installerVersionPattern = regexp.MustCompile(`OpenShift Installer ([^"\s\\]+)`)
+	kubeconfigServerPattern = regexp.MustCompile(`(?m)^\s*server:\s*(\S+)`)
+	kubeadminPasswordLog    = regexp.MustCompile(`(and password: \\?")[^"\\]*`)
+)
+
+// ClusterAccess holds the non-secret details needed to reach a cluster installed into an output directory.
+type ClusterAccess struct {
+	ConsoleURL string `json:"consoleURL"`
+	APIURL     string `json:"apiURL"`
+	Version    string `json:"version"`
+}
+
+// InstallLogPath returns path of the log openshift-install keeps in the output directory.
+func InstallLogPath(outputDir string) string {
+	return filepath.Join(outputDir, installLogFile)
+}
+
+// KubeconfigPath returns path of the admin kubeconfig created by openshift-install.
+func KubeconfigPath(outputDir string) string {
+	return filepath.Join(outputDir, kubeconfigFile)
+}
+
+// ReadClusterAccess collects console URL, API URL and version of a cluster from the files openshift-install
+// leaves in outputDir. The version is taken from the inventory, which follows upgrades, the installer log is only
+// used for clusters installed before the inventory was kept. It fails if the installation did not produce a
+// kubeconfig yet.
+func ReadClusterAccess(outputDir string) (*ClusterAccess, error) {
+	kubeconfig, err := os.ReadFile(KubeconfigPath(outputDir))
+	if err != nil {
+		return nil, fmt.Errorf("cluster access artifacts not found, did the installation finish? %w", err)
+	}
+
+	access := &ClusterAccess{}
+	if m := kubeconfigServerPattern.FindSubmatch(kubeconfig); m != nil {
+		access.APIURL = string(m[1])
+	}
+
+	installLog, err := os.ReadFile(InstallLogPath(outputDir))
+	if err != nil {
+		return nil, fmt.Errorf("could not read install log: %w", err)
+	}
+	if m := consoleURLPattern.FindSubmatch(installLog); m != nil {
+		access.ConsoleURL = string(m[1])
+	}
+	if inv, err := ReadInventory(outputDir); err == nil && inv.Version != "" {
+		access.Version = inv.Version
+	} else {
+		access.Version = installerVersion(installLog)
+	}
+
+	return access, nil
+}
+
+// installerVersion returns the version of openshift-install from its log. The installer is extracted from the
+// release payload, so it's the version the cluster was installed with.
+func installerVersion(installLog []byte) string {
+	if m := installerVersionPattern.FindSubmatch(installLog); m != nil {
+		return string(m[1])
+	}
+	return ""
+}
+
+// ReadKubeadminPassword returns the password of the kubeadmin user created by openshift-install.
+func ReadKubeadminPassword(outputDir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(outputDir, kubeadminPasswordFile))
+	if err != nil {
+		return "", fmt.Errorf("kubeadmin password not found, did the installation finish? %w", err)
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// MaskInstallLog hides the kubeadmin password openshift-install prints at the end of a successful installation.
+func MaskInstallLog(content []byte) []byte {
+	return kubeadminPasswordLog.ReplaceAll(content, []byte("${1}<redacted>"))
+}
@@ -0,0 +1,84 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+const accessTestInstallLog = `time="2024-10-01T10:02:11Z" level=info msg="OpenShift Installer 4.17.0"
+time="2024-10-01T10:45:08Z" level=info msg="Access the OpenShift web-console here: https://console-openshift-console.apps.jdoe-c1.example.com"
+`
+
+// accessTestDir returns an output dir with the kubeconfig and install log of an installed cluster.
+func accessTestDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		kubeconfigFile: "clusters:\n- cluster:\n    server: https://api.jdoe-c1.example.com:6443\n",
+		installLogFile: accessTestInstallLog,
+	}
+	for name, content := range files {
+		path := filepath.Join(dir, name)
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReadClusterAccess(t *testing.T) {
+	dir := accessTestDir(t)
+	access, err := ReadClusterAccess(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := ClusterAccess{
+		ConsoleURL: "https://console-openshift-console.apps.jdoe-c1.example.com",
+		APIURL:     "https://api.jdoe-c1.example.com:6443",
+		Version:    "4.17.0",
+	}
+	if *access != want {
+		t.Errorf("unexpected access %#v", access)
+	}
+
+	// The inventory follows upgrades, the installer log doesn't.
+	if err := recordUpgrade(dir, UpgradeRecord{From: "4.17.0", To: "4.17.2", Result: ResultSucceeded}); err != nil {
+		t.Fatal(err)
+	}
+	if access, err := ReadClusterAccess(dir); err != nil || access.Version != "4.17.2" {
+		t.Errorf("expected upgraded version 4.17.2, got %#v, %v", access, err)
+	}
+
+	if _, err := ReadClusterAccess(t.TempDir()); err == nil {
+		t.Errorf("expected an error without kubeconfig")
+	}
+}
+
+func TestRecordInstallationVersion(t *testing.T) {
+	tests := []struct {
+		name     string
+		executor Executor
+		want     string
+	}{
+		{name: "completed release of the cluster", executor: &fakeExecutor{dir: "healthy"}, want: "4.17.0-0.ci-2024-07-25-020703"},
+		{name: "installer version if the cluster can't tell", executor: &fakeExecutor{dir: "missing"}, want: "4.17.0"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := &Config{Cloud: "aws", OutputDir: accessTestDir(t)}
+			recordInstallation(context.Background(), tt.executor, conf)
+			inv, err := ReadInventory(conf.OutputDir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if inv.Version != tt.want {
+				t.Errorf("expected version %v, got %v", tt.want, inv.Version)
+			}
+		})
+	}
+}
@@ -0,0 +1,401 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+	"text/template"
+
+	"github.com/RomanBednar/install-tools/templates"
+)
+
+// Platforms of the agent-based installer, none is the default.
+const (
+	AgentPlatformNone      = "none"
+	AgentPlatformBaremetal = "baremetal"
+)
+
+// AgentPlatforms are valid values of --platform.
+var AgentPlatforms = []string{AgentPlatformNone, AgentPlatformBaremetal}
+
+// Roles of agent hosts.
+const (
+	agentRoleMaster = "master"
+	agentRoleWorker = "worker"
+)
+
+const (
+	// agentCloud is the --cloud value of agent-based installations, it has no cloud of its own.
+	agentCloud = "agent"
+	// agentConfigTemplate renders agent-config.yaml, openshift-install reads it next to install-config.yaml.
+	agentConfigTemplate = "agent_config.tmpl"
+	agentConfigFile     = "agent-config.yaml"
+	// defaultAgentInterface is the NIC of a host with static networking unless it sets interface.
+	defaultAgentInterface = "eth0"
+)
+
+// AgentWaitStages are the stages `openshift-install agent wait-for` accepts.
+var AgentWaitStages = []string{"bootstrap-complete", "install-complete"}
+
+// AgentHost is a host booted from the agent ISO, identified by the MAC address of its interface.
+type AgentHost struct {
+	Name      string
+	Role      string
+	MAC       string
+	Interface string
+	IP        string // <address>/<prefix> for static networking, DHCP if empty
+}
+
+// Address returns the static IP address of the host, empty if IP is not valid.
+func (h AgentHost) Address() string {
+	ip, _, err := net.ParseCIDR(h.IP)
+	if err != nil {
+		return ""
+	}
+	return ip.String()
+}
+
+// PrefixLength returns the prefix length of the static IP address of the host, 0 if IP is not valid.
+func (h AgentHost) PrefixLength() int {
+	_, ipNet, err := net.ParseCIDR(h.IP)
+	if err != nil {
+		return 0
+	}
+	ones, _ := ipNet.Mask.Size()
+	return ones
+}
+
+// Family returns the nmstate key of the IP family of the static address, ipv4 or ipv6.
+func (h AgentHost) Family() string {
+	if isIPv6CIDR(h.IP) {
+		return "ipv6"
+	}
+	return "ipv4"
+}
+
+// DefaultRoute returns the destination of the default route of the host's IP family.
+func (h AgentHost) DefaultRoute() string {
+	if isIPv6CIDR(h.IP) {
+		return "::/0"
+	}
+	return "0.0.0.0/0"
+}
+
+// AgentPlatformName returns the install-config platform of an agent-based installation.
+func (c Config) AgentPlatformName() string {
+	if c.AgentPlatform == "" {
+		return AgentPlatformNone
+	}
+	return c.AgentPlatform
+}
+
+// AgentHostList returns hosts of agentHosts, hosts that can't be parsed are reported by ValidateAgent.
+func (c Config) AgentHostList() []AgentHost {
+	hosts, _ := parseAgentHosts(c.AgentHosts)
+	return hosts
+}
+
+// AgentReplicas returns the number of hosts with the role, the machine pools of install-config have to match.
+func (c Config) AgentReplicas(role string) int {
+	n := 0
+	for _, h := range c.AgentHostList() {
+		if h.Role == role {
+			n++
+		}
+	}
+	return n
+}
+
+// AgentDNSServers returns the DNS servers of hosts with static networking.
+func (c Config) AgentDNSServers() []string {
+	return splitList(c.AgentDNS)
+}
+
+// AgentBaremetalAPIVIPs returns the API VIPs of a baremetal agent cluster.
+func (c Config) AgentBaremetalAPIVIPs() []string {
+	return splitList(c.AgentApiVIP)
+}
+
+// AgentBaremetalIngressVIPs returns the ingress VIPs of a baremetal agent cluster.
+func (c Config) AgentBaremetalIngressVIPs() []string {
+	return splitList(c.AgentIngressVIP)
+}
+
+// parseAgentHosts parses hosts given as "<hostname>:role=master,mac=52:54:00:00:00:01,ip=192.168.111.20/24;...".
+// Hosts accept role, mac, interface and ip, the hostname and mac are required.
+func parseAgentHosts(s string) ([]AgentHost, error) {
+	var hosts []AgentHost
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, settings, _ := strings.Cut(spec, ":")
+		host := AgentHost{Name: strings.TrimSpace(name), Role: agentRoleMaster, Interface: defaultAgentInterface}
+		if err := ValidateDNSLabel(host.Name); err != nil {
+			return nil, fmt.Errorf("invalid agent host name %q: %v", host.Name, err)
+		}
+		for _, setting := range strings.Split(settings, ",") {
+			setting = strings.TrimSpace(setting)
+			if setting == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(setting, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid setting %q of agent host %v, expected key=value", setting, host.Name)
+			}
+			switch key {
+			case "role":
+				host.Role = value
+			case "mac":
+				host.MAC = strings.ToLower(value)
+			case "interface":
+				host.Interface = value
+			case "ip":
+				host.IP = value
+			default:
+				return nil, fmt.Errorf("unknown setting %q of agent host %v", key, host.Name)
+			}
+		}
+		hosts = append(hosts, host)
+	}
+	return hosts, nil
+}
+
+// ValidateAgent checks hosts, the rendezvous IP and static networking of an agent-based installation.
+func ValidateAgent(conf *Config) error {
+	if problems := agentProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid agent configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func agentProblems(conf *Config) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	if conf.Cloud != agentCloud {
+		for _, k := range []struct{ key, value string }{
+			{"agentPlatform", conf.AgentPlatform},
+			{"agentHosts", conf.AgentHosts},
+			{"rendezvousIP", conf.RendezvousIP},
+		} {
+			if k.value != "" {
+				add("%s is only used with cloud %s", k.key, agentCloud)
+			}
+		}
+		return problems
+	}
+
+	platform := conf.AgentPlatformName()
+	if !slices.Contains(AgentPlatforms, platform) {
+		add("unsupported platform %q, use one of: %s", platform, strings.Join(AgentPlatforms, ", "))
+	}
+	if conf.BaseDomain == "" {
+		add("baseDomain is required")
+	}
+	// These are consumed by the cloud installation flow, which the agent installer doesn't use.
+	for _, k := range []struct{ key, value string }{
+		{"extraManifests", conf.ExtraManifests},
+		{"extraOpenshift", conf.ExtraOpenshift},
+		{"hooksFile", conf.HooksFile},
+	} {
+		if k.value != "" {
+			add("%s is not supported with cloud %s", k.key, agentCloud)
+		}
+	}
+
+	hosts, err := parseAgentHosts(conf.AgentHosts)
+	if err != nil {
+		add("%v", err)
+	} else if len(hosts) == 0 {
+		add("agentHosts is required")
+	}
+	// The hosts and the rendezvous IP have to be in the machine network, the default one is of clouds.
+	var machineNets []*net.IPNet
+	for _, cidr := range conf.Networking().MachineNetworks {
+		// Invalid networks are reported by the networking checks.
+		if _, n, err := net.ParseCIDR(cidr); err == nil {
+			machineNets = append(machineNets, n)
+		}
+	}
+	inMachineNetwork := func(ip net.IP) bool {
+		return slices.ContainsFunc(machineNets, func(n *net.IPNet) bool { return n.Contains(ip) })
+	}
+
+	names, macs := map[string]bool{}, map[string]bool{}
+	static := false
+	var masterIPs []string
+	for _, h := range hosts {
+		if names[h.Name] {
+			add("agent host %v is defined more than once", h.Name)
+		}
+		names[h.Name] = true
+		if h.Role != agentRoleMaster && h.Role != agentRoleWorker {
+			add("role %q of agent host %v must be %s or %s", h.Role, h.Name, agentRoleMaster, agentRoleWorker)
+		}
+		if _, err := net.ParseMAC(h.MAC); err != nil || h.MAC == "" {
+			add("agent host %v needs a valid mac address, got %q", h.Name, h.MAC)
+		} else if macs[h.MAC] {
+			add("mac address %v is used by more than one agent host", h.MAC)
+		}
+		macs[h.MAC] = true
+		if h.IP == "" {
+			continue
+		}
+		static = true
+		address, _, err := net.ParseCIDR(h.IP)
+		if err != nil {
+			add("ip %q of agent host %v must be <address>/<prefix>", h.IP, h.Name)
+			continue
+		}
+		if !inMachineNetwork(address) {
+			add("ip %v of agent host %v is not in any machineNetwork", h.IP, h.Name)
+		}
+		if gw := net.ParseIP(conf.AgentGateway); gw != nil && ipFamily(gw) != ipFamily(net.ParseIP(h.Address())) {
+			add("agentGateway %v and ip %v of agent host %v are of different IP families", conf.AgentGateway, h.IP, h.Name)
+		}
+		if h.Role == agentRoleMaster {
+			masterIPs = append(masterIPs, h.Address())
+		}
+	}
+
+	// All hosts boot the same ISO.
+	if conf.ControlPlaneArchitecture() != conf.ComputeArchitecture() {
+		add("hosts of an agent installation have one architecture, got %s and %s", conf.ControlPlaneArchitecture(), conf.ComputeArchitecture())
+	}
+
+	masters, workers := conf.AgentReplicas(agentRoleMaster), conf.AgentReplicas(agentRoleWorker)
+	switch {
+	case err != nil || len(hosts) == 0:
+		// Reported above.
+	case masters != 1 && masters != 3:
+		add("agent installations need 1 or 3 master hosts, got %d", masters)
+	case masters == 1 && workers > 0:
+		add("single node agent installations can't have worker hosts")
+	case masters == 1 && platform != AgentPlatformNone:
+		add("single node agent installations require platform %s", AgentPlatformNone)
+	}
+
+	if conf.RendezvousIP == "" {
+		add("rendezvousIP is required, it's the address of the master host that coordinates the installation")
+	} else if ip := net.ParseIP(conf.RendezvousIP); ip == nil {
+		add("rendezvousIP is not a valid IP address: %q", conf.RendezvousIP)
+	} else if !inMachineNetwork(ip) {
+		add("rendezvousIP %v is not in any machineNetwork", conf.RendezvousIP)
+	} else if static && !slices.Contains(masterIPs, conf.RendezvousIP) {
+		add("rendezvousIP %v must be the ip of a master host", conf.RendezvousIP)
+	}
+
+	if static {
+		if conf.AgentGateway == "" || net.ParseIP(conf.AgentGateway) == nil {
+			add("static networking of agent hosts needs agentGateway, got %q", conf.AgentGateway)
+		}
+		if len(conf.AgentDNSServers()) == 0 {
+			add("static networking of agent hosts needs agentDNS")
+		}
+		for _, dns := range conf.AgentDNSServers() {
+			if net.ParseIP(dns) == nil {
+				add("agentDNS is not a valid IP address: %q", dns)
+			}
+		}
+	}
+
+	// VIPs are checked against the machine network by the networking checks.
+	vips := conf.AgentApiVIP != "" || conf.AgentIngressVIP != ""
+	switch {
+	case platform == AgentPlatformBaremetal && (conf.AgentApiVIP == "" || conf.AgentIngressVIP == ""):
+		add("platform %s requires agentApiVIP and agentIngressVIP", AgentPlatformBaremetal)
+	case platform == AgentPlatformNone && vips:
+		add("agentApiVIP and agentIngressVIP are only used with platform %s, %s needs external load balancing and DNS", AgentPlatformBaremetal, AgentPlatformNone)
+	}
+	for _, v := range []vipList{{"agentApiVIP", conf.AgentBaremetalAPIVIPs()}, {"agentIngressVIP", conf.AgentBaremetalIngressVIPs()}} {
+		for _, vip := range v.value {
+			if net.ParseIP(vip) == nil {
+				add("%s is not a valid IP address: %q", v.key, vip)
+			}
+		}
+	}
+	return problems
+}
+
+// renderAgentConfig renders agent-config.yaml into memory.
+func renderAgentConfig(conf Config) ([]byte, error) {
+	tmp, err := template.New(agentConfigTemplate).Funcs(templateFuncs).ParseFS(templates.F, agentConfigTemplate)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, conf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+// writeAgentConfig writes agent-config.yaml to the output dir and to the latest history entry, it has no secrets.
+func writeAgentConfig(conf Config) {
+	content, err := renderAgentConfig(conf)
+	if err != nil {
+		panic(fmt.Errorf("could not render %v: %v", agentConfigFile, err))
+	}
+	if err := writeFileAtomic(filepath.Join(conf.OutputDir, agentConfigFile), content, 0600); err != nil {
+		panic(err)
+	}
+	if entry := latestHistoryEntry(conf.OutputDir); entry != "" {
+		if err := os.WriteFile(filepath.Join(entry, agentConfigFile), content, 0600); err != nil {
+			panic(err)
+		}
+	}
+}
+
+// CreateAgentImage runs `openshift-install agent create image`, it consumes install-config.yaml and
+// agent-config.yaml and writes the ISO hosts are booted from. It returns the path of the ISO.
+func CreateAgentImage(ctx context.Context, installDir string) string {
+	logger(ctx).Printf("Creating agent ISO.")
+	_, _, _ = runCommand(ctx, toolPath(installDir, "openshift-install"), installDir, "agent", "create", "image", "--log-level", "debug")
+	// auth/ holds the kubeconfig and kubeadmin password, agent create image writes it already.
+	restrictPermissions(filepath.Join(installDir, "auth"))
+	isos, _ := filepath.Glob(filepath.Join(installDir, "agent.*.iso"))
+	if len(isos) == 0 {
+		panic(fmt.Errorf("openshift-install did not create an agent ISO in %v", installDir))
+	}
+	return isos[0]
+}
+
+// AgentWaitFor runs `openshift-install agent wait-for <stage>` for the cluster installed from outputDir. The
+// hosts have to be booted from the ISO, the rendezvous host reports progress.
+func AgentWaitFor(ctx context.Context, outputDir, stage string) (err error) {
+	if !slices.Contains(AgentWaitStages, stage) {
+		return fmt.Errorf("unknown stage %q, use one of: %s", stage, strings.Join(AgentWaitStages, ", "))
+	}
+	if _, err := os.Stat(filepath.Join(outputDir, "openshift-install")); err != nil {
+		return fmt.Errorf("%v has no extracted openshift-install, was it created with cloud %s?", outputDir, agentCloud)
+	}
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	logger(ctx).Printf("Waiting for %v.", stage)
+	_, _, _ = runCommand(ctx, toolPath(outputDir, "openshift-install"), outputDir, "agent", "wait-for", stage, "--log-level", "debug")
+	return nil
+}
+
+// agentNextSteps is printed once the ISO is created, the installation continues when hosts boot it.
+func agentNextSteps(iso, outputDir string) string {
+	return fmt.Sprintf(`Agent ISO created: %v
+Boot every host of agentHosts from it, then follow the installation with:
+  go run main.go agent wait-for bootstrap-complete %v
+  go run main.go agent wait-for install-complete %v
+  go run main.go verify %v`, iso, outputDir, outputDir, outputDir)
+}
@@ -0,0 +1,298 @@
+package utils
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+const (
+	agentTestMasters = "master-0:role=master,mac=52:54:00:00:00:01,ip=192.168.111.20/24;" +
+		"master-1:mac=52:54:00:00:00:02,ip=192.168.111.21/24;" +
+		"master-2:role=master,mac=52:54:00:00:00:03,ip=192.168.111.22/24"
+	agentTestWorker = "worker-0:role=worker,mac=52:54:00:00:00:04,interface=enp1s0,ip=192.168.111.30/24"
+)
+
+// agentTestConfig returns a valid configuration of a compact agent cluster with static networking.
+func agentTestConfig() Config {
+	return Config{
+		Cloud:          agentCloud,
+		UserName:       "jdoe",
+		ClusterName:    "agent",
+		BaseDomain:     "example.com",
+		AgentHosts:     agentTestMasters,
+		RendezvousIP:   "192.168.111.20",
+		AgentGateway:   "192.168.111.1",
+		AgentDNS:       "192.168.111.1",
+		MachineNetwork: "192.168.111.0/24",
+	}
+}
+
+func TestParseAgentHosts(t *testing.T) {
+	tests := []struct {
+		name  string
+		hosts string
+		want  []AgentHost
+		err   string
+	}{
+		{
+			name:  "defaults and settings",
+			hosts: " master-0:mac=52:54:00:AA:00:01 ; worker-0:role=worker,mac=52:54:00:00:00:04,interface=enp1s0,ip=192.168.111.30/24;",
+			want: []AgentHost{
+				{Name: "master-0", Role: agentRoleMaster, MAC: "52:54:00:aa:00:01", Interface: defaultAgentInterface},
+				{Name: "worker-0", Role: agentRoleWorker, MAC: "52:54:00:00:00:04", Interface: "enp1s0", IP: "192.168.111.30/24"},
+			},
+		},
+		{name: "empty", hosts: " ; ", want: nil},
+		{name: "missing hostname", hosts: ":mac=52:54:00:00:00:01", err: `invalid agent host name ""`},
+		{name: "invalid hostname", hosts: "Master_0:mac=52:54:00:00:00:01", err: `invalid agent host name "Master_0"`},
+		{name: "setting without value", hosts: "master-0:mac", err: `invalid setting "mac" of agent host master-0`},
+		{name: "unknown setting", hosts: "master-0:mac=52:54:00:00:00:01,gw=10.0.0.1", err: `unknown setting "gw" of agent host master-0`},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			hosts, err := parseAgentHosts(tt.hosts)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if !reflect.DeepEqual(hosts, tt.want) {
+				t.Errorf("unexpected hosts %#v", hosts)
+			}
+		})
+	}
+}
+
+func TestAgentHostAddress(t *testing.T) {
+	h := AgentHost{IP: "fd00::20/64"}
+	if h.Address() != "fd00::20" || h.PrefixLength() != 64 || h.Family() != "ipv6" || h.DefaultRoute() != "::/0" {
+		t.Errorf("unexpected static networking of %v: %v/%v %v %v", h.IP, h.Address(), h.PrefixLength(), h.Family(), h.DefaultRoute())
+	}
+	for _, ip := range []string{"", "bogus", "192.168.111.20"} {
+		h := AgentHost{IP: ip}
+		if h.Address() != "" || h.PrefixLength() != 0 {
+			t.Errorf("expected no address of ip %q, got %v/%v", ip, h.Address(), h.PrefixLength())
+		}
+	}
+}
+
+func TestAgentProblems(t *testing.T) {
+	tests := []struct {
+		name   string
+		modify func(c *Config)
+		want   []string
+	}{
+		{name: "compact cluster", modify: func(c *Config) {}},
+		{
+			name: "three masters and a worker on baremetal",
+			modify: func(c *Config) {
+				c.AgentHosts += ";" + agentTestWorker
+				c.AgentPlatform = AgentPlatformBaremetal
+				c.AgentApiVIP = "192.168.111.5"
+				c.AgentIngressVIP = "192.168.111.4"
+			},
+		},
+		{
+			name: "single node with DHCP",
+			modify: func(c *Config) {
+				c.AgentHosts = "sno:mac=52:54:00:00:00:01"
+				c.AgentGateway, c.AgentDNS = "", ""
+			},
+		},
+		{
+			name:   "duplicate name and mac",
+			modify: func(c *Config) { c.AgentHosts += ";master-2:role=worker,mac=52:54:00:00:00:01" },
+			want: []string{
+				"agent host master-2 is defined more than once",
+				"mac address 52:54:00:00:00:01 is used by more than one agent host",
+			},
+		},
+		{
+			name:   "two masters",
+			modify: func(c *Config) { c.AgentHosts = agentTestMasters[:strings.LastIndex(agentTestMasters, ";")] },
+			want:   []string{"agent installations need 1 or 3 master hosts, got 2"},
+		},
+		{
+			name:   "no masters",
+			modify: func(c *Config) { c.AgentHosts, c.RendezvousIP = agentTestWorker, "192.168.111.30" },
+			want: []string{
+				"agent installations need 1 or 3 master hosts, got 0",
+				"rendezvousIP 192.168.111.30 must be the ip of a master host",
+			},
+		},
+		{
+			name: "single node with workers",
+			modify: func(c *Config) {
+				c.AgentHosts = "sno:mac=52:54:00:00:00:01,ip=192.168.111.20/24;" + agentTestWorker
+			},
+			want: []string{"single node agent installations can't have worker hosts"},
+		},
+		{
+			name: "single node on baremetal",
+			modify: func(c *Config) {
+				c.AgentHosts = "sno:mac=52:54:00:00:00:01,ip=192.168.111.20/24"
+				c.AgentPlatform = AgentPlatformBaremetal
+				c.AgentApiVIP = "192.168.111.5"
+				c.AgentIngressVIP = "192.168.111.4"
+			},
+			want: []string{"single node agent installations require platform none"},
+		},
+		{
+			name:   "baremetal without VIPs",
+			modify: func(c *Config) { c.AgentPlatform = AgentPlatformBaremetal },
+			want:   []string{"platform baremetal requires agentApiVIP and agentIngressVIP"},
+		},
+		{
+			name:   "VIPs without baremetal",
+			modify: func(c *Config) { c.AgentApiVIP, c.AgentIngressVIP = "192.168.111.5", "192.168.111.4" },
+			want:   []string{"agentApiVIP and agentIngressVIP are only used with platform baremetal, none needs external load balancing and DNS"},
+		},
+		{
+			name:   "hosts outside of the default machine network",
+			modify: func(c *Config) { c.MachineNetwork = "" },
+			want: []string{
+				"ip 192.168.111.20/24 of agent host master-0 is not in any machineNetwork",
+				"ip 192.168.111.21/24 of agent host master-1 is not in any machineNetwork",
+				"ip 192.168.111.22/24 of agent host master-2 is not in any machineNetwork",
+				"rendezvousIP 192.168.111.20 is not in any machineNetwork",
+			},
+		},
+		{
+			name: "rendezvous IP outside of the machine network",
+			modify: func(c *Config) {
+				c.AgentHosts = "sno:mac=52:54:00:00:00:01"
+				c.RendezvousIP = "192.168.112.20"
+				c.AgentGateway, c.AgentDNS = "", ""
+			},
+			want: []string{"rendezvousIP 192.168.112.20 is not in any machineNetwork"},
+		},
+		{
+			name: "invalid static networking",
+			modify: func(c *Config) {
+				c.AgentHosts = "sno:mac=52:54:00:00:00:01,ip=bogus"
+				c.AgentGateway, c.AgentDNS = "", "dns.example.com"
+			},
+			want: []string{
+				`ip "bogus" of agent host sno must be <address>/<prefix>`,
+				"rendezvousIP 192.168.111.20 must be the ip of a master host",
+				`static networking of agent hosts needs agentGateway, got ""`,
+				`agentDNS is not a valid IP address: "dns.example.com"`,
+			},
+		},
+		{
+			name:   "invalid host",
+			modify: func(c *Config) { c.AgentHosts = "sno:mac=52:54:00:00:00:01,role=infra" },
+			want: []string{
+				`role "infra" of agent host sno must be master or worker`,
+				"agent installations need 1 or 3 master hosts, got 0",
+			},
+		},
+		{
+			name:   "missing hosts and rendezvous IP",
+			modify: func(c *Config) { c.AgentHosts, c.RendezvousIP = "", "" },
+			want: []string{
+				"agentHosts is required",
+				"rendezvousIP is required, it's the address of the master host that coordinates the installation",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := agentTestConfig()
+			tt.modify(&conf)
+			if got := agentProblems(&conf); !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected problems:\n%s\nwant:\n%s", strings.Join(got, "\n"), strings.Join(tt.want, "\n"))
+			}
+		})
+	}
+}
+
+func TestAgentProblemsOfOtherClouds(t *testing.T) {
+	conf := Config{Cloud: "aws", AgentHosts: agentTestMasters, RendezvousIP: "192.168.111.20"}
+	want := []string{"agentHosts is only used with cloud agent", "rendezvousIP is only used with cloud agent"}
+	if got := agentProblems(&conf); !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected problems %v", got)
+	}
+}
+
+func TestRenderAgentConfig(t *testing.T) {
+	conf := agentTestConfig()
+	conf.AgentHosts = "sno:mac=52:54:00:00:00:01,ip=192.168.111.20/24;worker-0:role=worker,mac=52:54:00:00:00:04"
+	conf.AgentDNS = "192.168.111.1,192.168.111.2"
+	content, err := renderAgentConfig(conf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := `apiVersion: v1beta1
+kind: AgentConfig
+metadata:
+  name: jdoe-agent
+rendezvousIP: 192.168.111.20
+hosts:
+- hostname: sno
+  role: master
+  interfaces:
+  - name: eth0
+    macAddress: 52:54:00:00:00:01
+  networkConfig:
+    interfaces:
+    - name: eth0
+      type: ethernet
+      state: up
+      mac-address: 52:54:00:00:00:01
+      ipv4:
+        enabled: true
+        address:
+        - ip: 192.168.111.20
+          prefix-length: 24
+        dhcp: false
+    dns-resolver:
+      config:
+        server:
+        - 192.168.111.1
+        - 192.168.111.2
+    routes:
+      config:
+      - destination: 0.0.0.0/0
+        next-hop-address: 192.168.111.1
+        next-hop-interface: eth0
+        table-id: 254
+- hostname: worker-0
+  role: worker
+  interfaces:
+  - name: eth0
+    macAddress: 52:54:00:00:00:04
+`
+	if string(content) != want {
+		t.Errorf("unexpected agent-config.yaml:\n%s", content)
+	}
+}
+
+func TestLintRenderAgent(t *testing.T) {
+	conf := agentTestConfig()
+	conf.AgentHosts = "master-0:mac=52:54:00:00:00:01,ip=bogus"
+	findings, rendered := LintRender(conf)
+	for _, f := range findings {
+		if f.Check == "render" {
+			t.Errorf("agent-config.yaml of invalid hosts should not be rendered: %v", f.Message)
+		}
+	}
+	if strings.Contains(string(rendered), "kind: AgentConfig") {
+		t.Errorf("agent-config.yaml of invalid hosts was rendered:\n%s", rendered)
+	}
+
+	findings, rendered = LintRender(agentTestConfig())
+	for _, f := range findings {
+		if f.Check == "agent" || f.Check == "render" {
+			t.Errorf("unexpected finding %v: %v", f.Check, f.Message)
+		}
+	}
+	if !strings.Contains(string(rendered), "kind: AgentConfig") {
+		t.Errorf("agent-config.yaml was not rendered:\n%s", rendered)
+	}
+}
@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"runtime"
+	"slices"
+	"strings"
+)
+
+// Architectures of cluster pools and release payloads. A multi payload contains images of every architecture,
+// it's required for clusters with pools of different architectures.
+const (
+	ArchAMD64   = "amd64"
+	ArchARM64   = "arm64"
+	ArchPPC64LE = "ppc64le"
+	ArchMulti   = "multi"
+)
+
+// releaseArchitectureKey is set to multi in metadata of multi-arch release payloads.
+const releaseArchitectureKey = "release.openshift.io/architecture"
+
+// SupportedArchitectures are valid values of --arch.
+var SupportedArchitectures = []string{ArchAMD64, ArchARM64, ArchPPC64LE, ArchMulti}
+
+// nodeArchitectures are valid architectures of a single pool.
+var nodeArchitectures = []string{ArchAMD64, ArchARM64, ArchPPC64LE}
+
+// platformArchitectures are the node architectures of a platform, the first one is the default. Platforms not
+// listed have amd64 nodes only. See defaultInstanceTypes for the arm64 instance types used.
+var platformArchitectures = map[string][]string{
+	"aws":     {ArchAMD64, ArchARM64},
+	"azure":   {ArchAMD64, ArchARM64},
+	"gcp":     {ArchAMD64, ArchARM64},
+	"powervs": {ArchPPC64LE},
+	// Agent-based installations boot the ISO of the architecture on the hosts.
+	AgentPlatformNone:      {ArchAMD64, ArchARM64},
+	AgentPlatformBaremetal: {ArchAMD64, ArchARM64},
+}
+
+func architecturesOf(platform string) []string {
+	if archs, ok := platformArchitectures[platform]; ok {
+		return archs
+	}
+	return []string{ArchAMD64}
+}
+
+// ControlPlaneArchitecture returns the architecture of control plane nodes: controlPlaneArch if set, the default
+// of the platform for multi, otherwise arch.
+func (c Config) ControlPlaneArchitecture() string {
+	switch {
+	case c.ControlPlaneArch != "":
+		return c.ControlPlaneArch
+	case c.Arch == "" || c.Arch == ArchMulti:
+		return architecturesOf(c.Platform())[0]
+	default:
+		return c.Arch
+	}
+}
+
+// ComputeArchitecture returns the architecture of worker nodes, the control plane architecture unless computeArch is set.
+func (c Config) ComputeArchitecture() string {
+	if c.ComputeArch != "" {
+		return c.ComputeArch
+	}
+	return c.ControlPlaneArchitecture()
+}
+
+// ReleaseArchitecture returns the architecture the release image has to be built for.
+func (c Config) ReleaseArchitecture() string {
+	if c.Arch == ArchMulti || c.ControlPlaneArchitecture() != c.ComputeArchitecture() {
+		return ArchMulti
+	}
+	return c.ControlPlaneArchitecture()
+}
+
+// ValidateArchitecture checks architecture settings without looking at the release image.
+func ValidateArchitecture(conf *Config) error {
+	if conf.Arch != "" && !slices.Contains(SupportedArchitectures, conf.Arch) {
+		return fmt.Errorf("invalid arch %q, valid values are: %s", conf.Arch, strings.Join(SupportedArchitectures, ", "))
+	}
+	pools := []struct{ key, value string }{
+		{"controlPlaneArch", conf.ControlPlaneArch},
+		{"computeArch", conf.ComputeArch},
+	}
+	for _, p := range pools {
+		if p.value != "" && !slices.Contains(nodeArchitectures, p.value) {
+			return fmt.Errorf("invalid %s %q, valid values are: %s", p.key, p.value, strings.Join(nodeArchitectures, ", "))
+		}
+	}
+	if conf.Arch != "" && conf.Arch != ArchMulti && conf.ControlPlaneArchitecture() != conf.ComputeArchitecture() {
+		return fmt.Errorf("control plane (%s) and compute (%s) architectures differ, this requires arch %s",
+			conf.ControlPlaneArchitecture(), conf.ComputeArchitecture(), ArchMulti)
+	}
+	for _, arch := range []string{conf.ControlPlaneArchitecture(), conf.ComputeArchitecture()} {
+		if !slices.Contains(architecturesOf(conf.Platform()), arch) {
+			return fmt.Errorf("cloud %v does not support %s nodes", conf.Cloud, arch)
+		}
+	}
+	return nil
+}
+
+// hostCommandOS is the --command-os of oc adm release extract for tools runnable on this machine.
+func hostCommandOS() string {
+	if runtime.GOOS == "darwin" {
+		if runtime.GOARCH == ArchAMD64 {
+			return "mac"
+		}
+		return "mac/" + runtime.GOARCH
+	}
+	return runtime.GOOS + "/" + runtime.GOARCH
+}
+
+// hostFilterArgs selects images of this machine's architecture from a multi payload, so ccoctl and the other
+// binaries extracted from images run here. Single architecture payloads are not filtered.
+func hostFilterArgs(releaseArch string) []string {
+	if releaseArch != ArchMulti {
+		return nil
+	}
+	return []string{"--filter-by-os", "linux/" + runtime.GOARCH}
+}
+
+// releaseInfo is the part of `oc adm release info -o json` describing the payload version and architecture.
+type releaseInfo struct {
+	Config struct {
+		Architecture string `json:"architecture"`
+	} `json:"config"`
+	Metadata struct {
+		Version  string            `json:"version"`
+		Metadata map[string]string `json:"metadata"`
+	} `json:"metadata"`
+}
+
+func (r releaseInfo) architecture() string {
+	if r.Metadata.Metadata[releaseArchitectureKey] == ArchMulti {
+		return ArchMulti
+	}
+	return r.Config.Architecture
+}
+
+// mustReleaseInfo reads metadata of the release image. It uses oc of the system, the tools of the release are
+// not extracted yet.
+func mustReleaseInfo(ctx context.Context, conf *Config) releaseInfo {
+	secret, err := filepath.Abs(os.ExpandEnv(conf.PullSecretFile))
+	if err != nil {
+		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
+	}
+	out, _, _ := runCommand(ctx, "oc", conf.OutputDir, "adm", "-a", secret, "release", "info", "-o", "json", conf.Image)
+	var info releaseInfo
+	if err := json.Unmarshal([]byte(out), &info); err != nil {
+		panic(fmt.Errorf("could not parse release info of %v: %v", conf.Image, err))
+	}
+	return info
+}
+
+// mustReleaseArchitecture returns the architecture of the release image and panics if it can't install the
+// configured pools.
+func mustReleaseArchitecture(ctx context.Context, conf *Config) string {
+	logger(ctx).Printf("Checking architecture of release image: %v", conf.Image)
+	info := mustReleaseInfo(ctx, conf)
+	got, want := info.architecture(), conf.ReleaseArchitecture()
+	logger(ctx).Printf("Release image architecture: %v, required: %v", got, want)
+	if got != want && got != ArchMulti {
+		panic(fmt.Errorf("release image %v is %s, arch %s requires a %s or %s payload", conf.Image, got, want, want, ArchMulti))
+	}
+	if got != ArchMulti && got != runtime.GOARCH && slices.Contains(ccoctlClouds, conf.Cloud) {
+		panic(fmt.Errorf("ccoctl of a %s release image can't run on this %s machine, use a %s payload", got, runtime.GOARCH, ArchMulti))
+	}
+	return got
+}
@@ -0,0 +1,403 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/RomanBednar/install-tools/templates"
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// batchLogFile keeps output of the tool for one run of a batch in its output dir.
+	batchLogFile = "batch.log"
+	// batchStopDelay is how long a cancelled run gets to stop, it interrupts openshift-install in turn.
+	batchStopDelay = installerGracePeriod + time.Minute
+)
+
+// batchRunNamePattern matches characters replaced in names of runs, which are names of their output dirs.
+var batchRunNamePattern = regexp.MustCompile(`[^a-zA-Z0-9._-]+`)
+
+// batchReservedFlags are set for every run from the matrix, variants can't override them.
+var batchReservedFlags = []string{"action", "cloud", "image", "output-dir", "cluster-name", "cloud-region", "config-path", "profile", "dry-run"}
+
+// BatchMatrix is the format of the file given to batch create, see matrix.yaml.template. Every variant is
+// installed with every release in every region.
+type BatchMatrix struct {
+	// OutputDir is where every run gets its own output dir.
+	OutputDir   string `yaml:"outputDir,omitempty"`
+	Parallelism int    `yaml:"parallelism,omitempty"`
+	// Config is the config file of variants that don't set their own, or a directory with conf.env.
+	Config   string         `yaml:"config,omitempty"`
+	DryRun   bool           `yaml:"dryRun,omitempty"`
+	Releases []string       `yaml:"releases"`
+	Regions  []string       `yaml:"regions,omitempty"`
+	Variants []BatchVariant `yaml:"variants"`
+}
+
+// BatchVariant is one way of installing a cluster: a cloud with its configuration.
+type BatchVariant struct {
+	Name    string `yaml:"name,omitempty"`
+	Cloud   string `yaml:"cloud"`
+	Config  string `yaml:"config,omitempty"`
+	Profile string `yaml:"profile,omitempty"`
+	// Regions replace regions of the matrix for this variant.
+	Regions []string `yaml:"regions,omitempty"`
+	// Flags are passed to the tool as --<name>=<value>.
+	Flags map[string]string `yaml:"flags,omitempty"`
+}
+
+// BatchRun is one installation of a batch.
+type BatchRun struct {
+	Name        string `json:"name"`
+	Variant     string `json:"variant"`
+	Cloud       string `json:"cloud"`
+	Release     string `json:"release"`
+	Region      string `json:"region,omitempty"`
+	ClusterName string `json:"clusterName"`
+	OutputDir   string `json:"outputDir"`
+	// Args are the arguments of the tool performing the run.
+	Args []string `json:"-"`
+}
+
+// BatchResult is the outcome of a run, Result is one of the Result* values.
+type BatchResult struct {
+	BatchRun
+	Result   string `json:"result"`
+	Error    string `json:"error,omitempty"`
+	Log      string `json:"log"`
+	Duration string `json:"duration,omitempty"`
+}
+
+func (v BatchVariant) validate() error {
+	if v.Cloud == "" {
+		return fmt.Errorf("variant %q requires cloud", v.Name)
+	}
+	if !slices.Contains(GetCloudKeys(), v.Cloud) {
+		return fmt.Errorf("variant %q has unknown cloud %q, valid values are: %s", v.Name, v.Cloud, strings.Join(GetCloudKeys(), ", "))
+	}
+	if err := ValidateDNSLabel(v.Name); err != nil {
+		return fmt.Errorf("invalid variant name: %v", err)
+	}
+	if v.Config != "" && v.Profile != "" {
+		return fmt.Errorf("variant %q sets both config and profile", v.Name)
+	}
+	for name := range v.Flags {
+		if slices.Contains(batchReservedFlags, name) {
+			return fmt.Errorf("variant %q can't set flag --%s, it's set for every run from the matrix", v.Name, name)
+		}
+	}
+	return nil
+}
+
+// LoadBatchMatrix reads and validates a matrix file. Relative paths of config files and the output dir are
+// relative to the file.
+func LoadBatchMatrix(path string) (*BatchMatrix, error) {
+	path = os.ExpandEnv(path)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var m BatchMatrix
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&m); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %v: %v", path, err)
+	}
+
+	base := filepath.Dir(path)
+	resolve := func(p *string) {
+		*p = os.ExpandEnv(*p)
+		if *p != "" && !filepath.IsAbs(*p) {
+			*p = filepath.Join(base, *p)
+		}
+	}
+	resolve(&m.OutputDir)
+	resolve(&m.Config)
+	for i := range m.Variants {
+		v := &m.Variants[i]
+		resolve(&v.Config)
+		if v.Name == "" {
+			v.Name = v.Cloud
+		}
+	}
+	if err := m.validate(); err != nil {
+		return nil, fmt.Errorf("%v: %v", path, err)
+	}
+	return &m, nil
+}
+
+func (m *BatchMatrix) validate() error {
+	if len(m.Variants) == 0 {
+		return fmt.Errorf("no variants")
+	}
+	if len(m.Releases) == 0 {
+		return fmt.Errorf("no releases")
+	}
+	for _, release := range m.Releases {
+		if !isPullSpec(release) {
+			return fmt.Errorf("release %q is not a pull spec", release)
+		}
+	}
+	if m.Parallelism < 0 {
+		return fmt.Errorf("parallelism can't be negative")
+	}
+	names := map[string]bool{}
+	runNames := map[string]bool{}
+	gcpRuns := 0
+	for _, v := range m.Variants {
+		if err := v.validate(); err != nil {
+			return err
+		}
+		if names[v.Name] {
+			return fmt.Errorf("duplicate variant name %q, name variants of the same cloud", v.Name)
+		}
+		names[v.Name] = true
+		if regions := m.regions(v); regions[0] != "" && !templateUsesRegion(v.Cloud) {
+			return fmt.Errorf("variant %q can't take regions, the %s template sets its own region and every run would install there", v.Name, v.Cloud)
+		}
+		// vSphere clusters are named after the user, runs of a variant would install the same cluster.
+		if v.Cloud == "vsphere" && len(m.Releases)*len(m.regions(v)) > 1 {
+			return fmt.Errorf("variant %q would install several vSphere clusters with the same name", v.Name)
+		}
+		if platformOf(v.Cloud) == "gcp" {
+			gcpRuns += len(m.Releases) * len(m.regions(v))
+		}
+		for _, path := range []string{v.Config, m.Config} {
+			if _, err := os.Stat(path); path != "" && err != nil {
+				return fmt.Errorf("variant %q: %v", v.Name, err)
+			}
+		}
+		for _, release := range m.Releases {
+			for _, region := range m.regions(v) {
+				name := batchRunName(v, release, region)
+				if runNames[name] {
+					return fmt.Errorf("several runs would be named %q, give the releases different tags", name)
+				}
+				runNames[name] = true
+			}
+		}
+	}
+	// Every GCP installation creates the service account <userName>-development and aborts if it exists.
+	if gcpRuns > 1 {
+		return fmt.Errorf("the matrix has %d GCP runs, each would create the same service account, only one is possible", gcpRuns)
+	}
+	return nil
+}
+
+// regions returns regions of the runs of a variant. Without any region the runs take it from the config of
+// the variant, it's an empty string then.
+func (m *BatchMatrix) regions(v BatchVariant) []string {
+	switch {
+	case len(v.Regions) > 0:
+		return v.Regions
+	case len(m.Regions) > 0:
+		return m.Regions
+	default:
+		return []string{""}
+	}
+}
+
+// templateUsesRegion tells whether the template of cloud renders --cloud-region, templates of most clouds set
+// their region themselves.
+func templateUsesRegion(cloud string) bool {
+	content, err := templates.F.ReadFile(cloudTemplatesMap[cloud])
+	return err == nil && strings.Contains(string(content), ".CloudRegion")
+}
+
+// releaseLabel shortens a pull spec for run names: its tag, or the start of its digest.
+func releaseLabel(release string) string {
+	if i := strings.LastIndex(release, "@"); i >= 0 {
+		digest := strings.TrimPrefix(release[i+1:], "sha256:")
+		return digest[:min(len(digest), 12)]
+	}
+	if i := strings.LastIndex(release, ":"); i > strings.LastIndex(release, "/") {
+		return release[i+1:]
+	}
+	return filepath.Base(release)
+}
+
+// batchRunName names a run and its output dir after the variant, the release and the region.
+func batchRunName(v BatchVariant, release, region string) string {
+	parts := []string{v.Name, releaseLabel(release)}
+	if region != "" {
+		parts = append(parts, region)
+	}
+	return strings.Trim(batchRunNamePattern.ReplaceAllString(strings.Join(parts, "-"), "-"), "-.")
+}
+
+// Runs expands the matrix into runs, each with its own output dir and generated cluster name.
+func (m *BatchMatrix) Runs() []BatchRun {
+	var runs []BatchRun
+	for _, v := range m.Variants {
+		for _, release := range m.Releases {
+			for _, region := range m.regions(v) {
+				name := batchRunName(v, release, region)
+				run := BatchRun{
+					Name:        name,
+					Variant:     v.Name,
+					Cloud:       v.Cloud,
+					Release:     release,
+					Region:      region,
+					ClusterName: GenerateClusterName(v.Cloud),
+					OutputDir:   filepath.Join(m.OutputDir, name),
+				}
+				run.Args = m.args(v, run)
+				runs = append(runs, run)
+			}
+		}
+	}
+	return runs
+}
+
+// args returns arguments of the tool installing run.
+func (m *BatchMatrix) args(v BatchVariant, run BatchRun) []string {
+	args := []string{"--action", "create", "--cloud", v.Cloud, "--image", run.Release, "--output-dir", run.OutputDir, "--cluster-name", run.ClusterName}
+	if run.Region != "" {
+		args = append(args, "--cloud-region", run.Region)
+	}
+	switch {
+	case v.Profile != "":
+		args = append(args, "--profile", v.Profile)
+	case v.Config != "":
+		args = append(args, "--config-path", v.Config)
+	case m.Config != "":
+		args = append(args, "--config-path", m.Config)
+	}
+	if m.DryRun {
+		args = append(args, "--dry-run")
+	}
+	var flags []string
+	for name, value := range v.Flags {
+		flags = append(flags, fmt.Sprintf("--%s=%s", name, value))
+	}
+	slices.Sort(flags)
+	return append(args, flags...)
+}
+
+// RunBatch runs tool with the arguments of every run, at most parallelism at a time, and returns results in
+// the order of runs. Every run is a separate process with its output in <outputDir>/batch.log, so it keeps its
+// own step log and a failing run doesn't stop the others. Cancelling ctx interrupts running runs, those not
+// started yet are reported as cancelled.
+func RunBatch(ctx context.Context, tool string, runs []BatchRun, parallelism int) []BatchResult {
+	if parallelism < 1 {
+		parallelism = 1
+	}
+	results := make([]BatchResult, len(runs))
+	slots := make(chan struct{}, parallelism)
+	var wg sync.WaitGroup
+	for i, run := range runs {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			select {
+			case slots <- struct{}{}:
+				defer func() { <-slots }()
+			case <-ctx.Done():
+			}
+			results[i] = runBatchRun(ctx, tool, run)
+		}()
+	}
+	wg.Wait()
+	return results
+}
+
+func runBatchRun(ctx context.Context, tool string, run BatchRun) BatchResult {
+	result := BatchResult{BatchRun: run, Log: filepath.Join(run.OutputDir, batchLogFile)}
+	if ctx.Err() != nil {
+		result.Result = ResultCancelled
+		result.Error = "batch cancelled before the run started"
+		return result
+	}
+	fail := func(err error) BatchResult {
+		result.Result = ResultFailed
+		result.Error = err.Error()
+		logger(ctx).Printf("Run %s failed: %v", run.Name, err)
+		return result
+	}
+	if _, err := os.Stat(run.OutputDir); err == nil {
+		return fail(fmt.Errorf("output dir %v already exists", run.OutputDir))
+	}
+	if err := os.MkdirAll(run.OutputDir, 0755); err != nil {
+		return fail(err)
+	}
+	logFile, err := os.OpenFile(result.Log, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return fail(err)
+	}
+	defer logFile.Close()
+
+	// The run gets the interrupt Ctrl-C would send it, it has its own process group like every command.
+	cmd := exec.CommandContext(ctx, tool, run.Args...)
+	cmd.Stdout = logFile
+	cmd.Stderr = logFile
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return cmd.Process.Signal(os.Interrupt)
+	}
+	cmd.WaitDelay = batchStopDelay
+
+	logger(ctx).Printf("Run %s started, cluster %s, log %s", run.Name, run.ClusterName, result.Log)
+	start := time.Now()
+	err = cmd.Run()
+	result.Duration = time.Since(start).Round(time.Second).String()
+	switch {
+	case err == nil:
+		result.Result = ResultSucceeded
+		logger(ctx).Printf("Run %s succeeded in %v", run.Name, result.Duration)
+		return result
+	case ctx.Err() != nil:
+		result.Result = ResultCancelled
+		result.Error = fmt.Sprintf("%v: %v", ErrCancelled, ctx.Err())
+		logger(ctx).Printf("Run %s cancelled", run.Name)
+		return result
+	}
+	if reported := lastReportedError(result.Log); reported != "" {
+		err = errors.New(reported)
+	}
+	return fail(err)
+}
+
+// lastReportedError returns the error the tool printed last in a run log.
+func lastReportedError(path string) string {
+	f, err := os.Open(path)
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	var last string
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(make([]byte, 0, 64*1024), 1024*1024)
+	for scanner.Scan() {
+		if line, ok := strings.CutPrefix(scanner.Text(), "Error: "); ok {
+			last = line
+		}
+	}
+	return last
+}
+
+// WriteBatchReport writes results as JSON to path.
+func WriteBatchReport(path string, results []BatchResult) error {
+	content, err := json.MarshalIndent(results, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, append(content, '\n'), 0644)
+}
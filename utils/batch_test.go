@@ -0,0 +1,90 @@
+package utils
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestBatchMatrixValidate(t *testing.T) {
+	const (
+		release = "quay.io/openshift-release-dev/ocp-release:4.17.3-x86_64"
+		nightly = "registry.ci.openshift.org/ocp/release:4.18.0-0.nightly-2024-11-05-143311"
+	)
+	tests := []struct {
+		name     string
+		releases []string
+		variants []BatchVariant
+		err      string
+	}{
+		{
+			name:     "variants with releases and regions",
+			releases: []string{release, nightly},
+			variants: []BatchVariant{
+				{Name: "aws", Cloud: "aws"},
+				{Name: "ibmcloud", Cloud: "ibmcloud", Regions: []string{"us-south", "eu-de"}},
+			},
+		},
+		{
+			name:     "GCP variant with several releases",
+			releases: []string{release, nightly},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws"}, {Name: "gcp", Cloud: "gcp"}},
+			err:      "the matrix has 2 GCP runs",
+		},
+		{
+			name:     "one GCP run",
+			releases: []string{release},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws"}, {Name: "gcp", Cloud: "gcp"}},
+		},
+		{
+			name:     "GCP runs of different variants",
+			releases: []string{release},
+			variants: []BatchVariant{{Name: "gcp", Cloud: "gcp"}, {Name: "gcp-wif", Cloud: "gcp-wif"}},
+			err:      "the matrix has 2 GCP runs",
+		},
+		{
+			name:     "vSphere with several releases",
+			releases: []string{release, nightly},
+			variants: []BatchVariant{{Name: "vsphere", Cloud: "vsphere"}},
+			err:      `variant "vsphere" would install several vSphere clusters with the same name`,
+		},
+		{
+			name:     "regions of a cloud that sets its own",
+			releases: []string{release},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws", Regions: []string{"eu-west-1"}}},
+			err:      `variant "aws" can't take regions`,
+		},
+		{
+			name:     "duplicate variant",
+			releases: []string{release},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws"}, {Name: "aws", Cloud: "aws", Flags: map[string]string{"arch": "arm64"}}},
+			err:      `duplicate variant name "aws"`,
+		},
+		{
+			name:     "reserved flag",
+			releases: []string{release},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws", Flags: map[string]string{"cloud-region": "eu-west-1"}}},
+			err:      `variant "aws" can't set flag --cloud-region`,
+		},
+		{
+			name:     "release that is not a pull spec",
+			releases: []string{"4.17.3"},
+			variants: []BatchVariant{{Name: "aws", Cloud: "aws"}},
+			err:      `release "4.17.3" is not a pull spec`,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := BatchMatrix{Releases: tt.releases, Variants: tt.variants}
+			err := m.validate()
+			if tt.err == "" {
+				if err != nil {
+					t.Errorf("unexpected error: %v", err)
+				}
+				return
+			}
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("expected error %q, got %v", tt.err, err)
+			}
+		})
+	}
+}
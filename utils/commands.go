@@ -4,13 +4,13 @@ import (
 	"bytes"
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"github.com/codeclysm/extract"
-	"log"
-	"net/http"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"slices"
 	"strings"
 	"syscall"
 	"time"
@@ -23,13 +23,69 @@ const (
 
 	// Name of a resource group we have preconfigured in Azure, used by ccoctl to find the right DNS zone
 	defaultAzureResourceGroup = "os4-common"
+
+	// gcpCredentialsFile is the service account key CreateGCPServiceAccount writes to the output dir.
+	gcpCredentialsFile = "gcp-service-account.json"
 )
 
-func runCommand(name string, workDir string, args ...string) (stdout string, stderr string, exitCode int) {
-	log.Println("run command:", name, strings.Join(args, " "))
-	var outbuf, errbuf bytes.Buffer
-	cmd := exec.Command(name, args...)
+// ErrCancelled is returned by Run when the context passed to it is cancelled before all steps finish.
+var ErrCancelled = errors.New("installation cancelled")
+
+// installerGracePeriod is how long openshift-install gets to stop after receiving SIGINT before it is killed.
+// Killing it right away may leave a half created cluster without metadata needed for destroy.
+const installerGracePeriod = 2 * time.Minute
+
+type commandEnvKey struct{}
+
+// withCommandEnv returns a context whose commands get env, in the form "KEY=value", on top of the environment
+// of the tool. Settings of one installation are passed this way instead of os.Setenv, so installations running
+// side by side don't see each other's.
+func withCommandEnv(ctx context.Context, env ...string) context.Context {
+	return context.WithValue(ctx, commandEnvKey{}, append(slices.Clone(commandEnv(ctx)), env...))
+}
+
+func commandEnv(ctx context.Context) []string {
+	env, _ := ctx.Value(commandEnvKey{}).([]string)
+	return env
+}
+
+// toolPath returns the absolute path of a tool extracted to outputDir, commands don't depend on the working
+// directory of the process.
+func toolPath(outputDir, tool string) string {
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		panic(fmt.Errorf("could not resolve output dir %v: %v", outputDir, err))
+	}
+	return filepath.Join(dir, tool)
+}
+
+// commandContext prepares a command that is stopped when ctx is cancelled. Commands run in their own process
+// group, so that Ctrl-C in a terminal reaches only this tool and cancellation stops their children too.
+func commandContext(ctx context.Context, name string, workDir string, args ...string) *exec.Cmd {
+	cmd := exec.CommandContext(ctx, name, args...)
 	cmd.Dir = workDir
+	if env := commandEnv(ctx); len(env) > 0 {
+		cmd.Env = append(os.Environ(), env...)
+	}
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	cmd.Cancel = func() error {
+		return syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+	}
+	cmd.WaitDelay = 10 * time.Second
+	if filepath.Base(name) == "openshift-install" {
+		cmd.Cancel = func() error {
+			logger(ctx).Printf("Sending interrupt to %v, waiting up to %v for it to stop.", name, installerGracePeriod)
+			return syscall.Kill(-cmd.Process.Pid, syscall.SIGINT)
+		}
+		cmd.WaitDelay = installerGracePeriod
+	}
+	return cmd
+}
+
+func runCommand(ctx context.Context, name string, workDir string, args ...string) (stdout string, stderr string, exitCode int) {
+	logger(ctx).Println("run command:", name, strings.Join(args, " "))
+	var outbuf, errbuf bytes.Buffer
+	cmd := commandContext(ctx, name, workDir, args...)
 	cmd.Stdout = &outbuf
 	cmd.Stderr = &errbuf
 
@@ -37,6 +93,11 @@ func runCommand(name string, workDir string, args ...string) (stdout string, std
 	stdout = strings.TrimSpace(outbuf.String())
 	stderr = strings.TrimSpace(errbuf.String())
 
+	if ctx.Err() != nil {
+		logger(ctx).Printf("command %v was cancelled, stdout: %v, stderr: %v", name, stdout, stderr)
+		panic(fmt.Errorf("%w: %v interrupted", ErrCancelled, name))
+	}
+
 	if err != nil {
 		// try to get the exit code
 		if exitError, ok := err.(*exec.ExitError); ok {
@@ -47,7 +108,7 @@ func runCommand(name string, workDir string, args ...string) (stdout string, std
 			// in this situation, exit code could not be get, and stderr will be
 			// empty string very likely, so we use the default fail code, and format err
 			// to string and set to stderr
-			log.Printf("Could not get exit code for failed program: %v, %v", name, args)
+			logger(ctx).Printf("Could not get exit code for failed program: %v, %v", name, args)
 			exitCode = defaultFailedCode
 			if stderr == "" {
 				stderr = err.Error()
@@ -58,7 +119,7 @@ func runCommand(name string, workDir string, args ...string) (stdout string, std
 		ws := cmd.ProcessState.Sys().(syscall.WaitStatus)
 		exitCode = ws.ExitStatus()
 	}
-	log.Printf("command result, stdout: %v, stderr: %v, exitCode: %v", stdout, stderr, exitCode)
+	logger(ctx).Printf("command result, stdout: %v, stderr: %v, exitCode: %v", stdout, stderr, exitCode)
 
 	if exitCode != 0 {
 		//TODO: maybe we should not panic here, but return error instead
@@ -68,9 +129,17 @@ func runCommand(name string, workDir string, args ...string) (stdout string, std
 	return
 }
 
-func mustBeSupportedCloud(cloud string) {
+// sleepContext pauses for the given duration, it panics with ErrCancelled if ctx is cancelled meanwhile.
+func sleepContext(ctx context.Context, d time.Duration) {
+	select {
+	case <-ctx.Done():
+		panic(fmt.Errorf("%w: %v", ErrCancelled, ctx.Err()))
+	case <-time.After(d):
+	}
+}
+
+func mustBeSupportedCloud(cloud string, supportedClouds ...string) {
 	// check if cloud provided is one of supported values
-	supportedClouds := []string{"gcp", "aws", "azure"}
 	var supported bool
 	for _, c := range supportedClouds {
 		if c == cloud {
@@ -83,52 +152,54 @@ func mustBeSupportedCloud(cloud string) {
 	}
 }
 
-func getCcoImageDigest(pullSecretFile, outputDir, imageUrl string) string {
+func getCcoImageDigest(ctx context.Context, pullSecretFile, outputDir, imageUrl, releaseArch string) string {
 	// get absolute path of pullSecretFile
 	file, err := filepath.Abs(pullSecretFile)
 	if err != nil {
 		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
 	}
 
-	baseCmd := "./oc"
+	baseCmd := toolPath(outputDir, "oc")
 	args := []string{"adm", "-a", file, "release", "info", "--image-for", "cloud-credential-operator", imageUrl}
-	log.Printf("Obtaining Cloud Credentials Operator image digest from image: %v\n", imageUrl)
-	out, _, _ := runCommand(baseCmd, outputDir, args...)
+	args = append(args, hostFilterArgs(releaseArch)...)
+	logger(ctx).Printf("Obtaining Cloud Credentials Operator image digest from image: %v\n", imageUrl)
+	out, _, _ := runCommand(ctx, baseCmd, outputDir, args...)
 
 	return strings.TrimSuffix(out, "\n")
 }
 
 // Deprecated: findTarballs function is deprecated and will be removed in the future.
-func findTarballs(outputDir string) []string {
+func findTarballs(ctx context.Context, outputDir string) []string {
 	baseCmd := "find"
 	args := []string{outputDir, "-name", "*.tar.*"}
-	log.Printf("Looking up tarballs in : %v", outputDir)
-	out, _, _ := runCommand(baseCmd, "", args...) //Must not switch dir.
+	logger(ctx).Printf("Looking up tarballs in : %v", outputDir)
+	out, _, _ := runCommand(ctx, baseCmd, "", args...) //Must not switch dir.
 
 	return strings.Split(strings.TrimSuffix(out, "\n"), "\n")
 }
 
 // Deprecated: Unarchive function is deprecated and will be removed in the future.
-func Unarchive(outputDir, targetDir string) {
-	log.Printf("Unarchiving tarballs from: %v to: %v", outputDir, targetDir)
-	tarballs := findTarballs(outputDir)
+func Unarchive(ctx context.Context, outputDir, targetDir string) {
+	logger(ctx).Printf("Unarchiving tarballs from: %v to: %v", outputDir, targetDir)
+	tarballs := findTarballs(ctx, outputDir)
 	for _, tarball := range tarballs {
-		log.Printf("Extracting: %v", tarball)
+		logger(ctx).Printf("Extracting: %v", tarball)
 		data, err := os.ReadFile(tarball)
 		if err != nil {
-			log.Fatalf("Could not read tarball %s: %v", tarball, err)
+			logger(ctx).Fatalf("Could not read tarball %s: %v", tarball, err)
 		}
 		buffer := bytes.NewBuffer(data)
-		err = extract.Gz(context.TODO(), buffer, targetDir, nil)
+		err = extract.Gz(ctx, buffer, targetDir, nil)
 		if err != nil {
-			log.Fatalf("Could not extract tarball %s: %v", tarball, err)
+			logger(ctx).Fatalf("Could not extract tarball %s: %v", tarball, err)
 		}
 	}
 }
 
 // ExtractTools function extracts openshift-install and oc binaries from the image - this uses locally available oc binary
 // which means it has to be run first and any consecutive commands should use the extracted oc binary.
-func ExtractTools(pullSecretFile, outputDir, imageUrl string) {
+// The binaries are built for this machine, which may differ from the architecture of the cluster.
+func ExtractTools(ctx context.Context, pullSecretFile, outputDir, imageUrl, releaseArch string) {
 	secret, err := filepath.Abs(os.ExpandEnv(pullSecretFile))
 	if err != nil {
 		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
@@ -137,36 +208,38 @@ func ExtractTools(pullSecretFile, outputDir, imageUrl string) {
 
 	//args := []string{"adm", "-a", secret, "release", "extract", "--tools", imageUrl}
 
-	args := []string{"adm", "-a", secret, "release", "extract", "--command=openshift-install", imageUrl}
-	log.Printf("Extracting openshift-install binary from image: %v", imageUrl)
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	hostArgs := append([]string{"--command-os", hostCommandOS()}, hostFilterArgs(releaseArch)...)
+	args := append([]string{"adm", "-a", secret, "release", "extract", "--command=openshift-install", imageUrl}, hostArgs...)
+	logger(ctx).Printf("Extracting openshift-install binary from image: %v", imageUrl)
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
-	args = []string{"adm", "-a", secret, "release", "extract", "--command=oc", imageUrl}
-	log.Printf("Extracting oc binary from image: %v", imageUrl)
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	args = append([]string{"adm", "-a", secret, "release", "extract", "--command=oc", imageUrl}, hostArgs...)
+	logger(ctx).Printf("Extracting oc binary from image: %v", imageUrl)
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 }
 
-func ExtractCcoctl(pullSecretFile, outputDir, imageUrl string) {
-	log.Printf("Extracting CCO image from release image: %v", imageUrl)
+func ExtractCcoctl(ctx context.Context, pullSecretFile, outputDir, imageUrl, releaseArch string) {
+	logger(ctx).Printf("Extracting CCO image from release image: %v", imageUrl)
 	// get absolute path of pullSecretFile
 	file, err := filepath.Abs(pullSecretFile)
 	if err != nil {
 		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
 	}
 
-	ccoImage := getCcoImageDigest(file, outputDir, imageUrl)
-	baseCmd := "./oc"
+	ccoImage := getCcoImageDigest(ctx, file, outputDir, imageUrl, releaseArch)
+	baseCmd := toolPath(outputDir, "oc")
 	args := []string{"image", "-a", file, "extract", "--file", "/usr/bin/ccoctl", "--confirm", ccoImage}
-	log.Printf("Extracting ccoctl binary from CCO image digest: %v", ccoImage)
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	args = append(args, hostFilterArgs(releaseArch)...)
+	logger(ctx).Printf("Extracting ccoctl binary from CCO image digest: %v", ccoImage)
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 	baseCmd = "chmod"
-	args = []string{"+x", "./ccoctl"}
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	args = []string{"+x", toolPath(outputDir, "ccoctl")}
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 }
 
-func CreateInstallManifests(pullSecretFile, outputDir, imageUrl, cloud string) {
-	mustBeSupportedCloud(cloud)
+func CreateInstallManifests(ctx context.Context, pullSecretFile, outputDir, imageUrl, cloud string) {
+	mustBeSupportedCloud(cloud, "gcp", "aws", "azure", "ibmcloud", "powervs", "nutanix")
 
 	// get absolute path of pullSecretFile
 	file, err := filepath.Abs(pullSecretFile)
@@ -174,34 +247,34 @@ func CreateInstallManifests(pullSecretFile, outputDir, imageUrl, cloud string) {
 		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
 	}
 
-	log.Printf("Extracting manifests from image: %v", imageUrl)
-	baseCmd := "./openshift-install"
+	logger(ctx).Printf("Extracting manifests from image: %v", imageUrl)
+	baseCmd := toolPath(outputDir, "openshift-install")
 	args := []string{"create", "manifests", "--log-level", "debug"}
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 	baseCmd = "mkdir"
 	args = []string{defaultCredRequestDir}
-	log.Println("Creating creds directory.")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Println("Creating creds directory.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
-	baseCmd = "./oc"
+	baseCmd = toolPath(outputDir, "oc")
 	args = []string{"adm", "-a", file, "release", "extract", "--credentials-requests", "--cloud", cloud, "--to", defaultCredRequestDir, imageUrl}
-	log.Println("Extracting credential request")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Println("Extracting credential request")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 	//baseCmd = "cp"
 	//// This assumes that `openshift-install create manifests` command defaults output dir to ./manifests.
 	//args = []string{"-a", "./manifests/tls", "."}
 	//log.Println("Copying bound service account signing key to manifests dir.")
-	//_, _, _ = runCommand(baseCmd, outputDir, args...)
+	//_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 }
 
 // ExecuteCcoctl must run after CreateInstallManifests and ExtractCcoctl
-func ExecuteCcoctl(outputDir, cloud, region, rgName string, dryRun bool) {
-	mustBeSupportedCloud(cloud)
+func ExecuteCcoctl(ctx context.Context, outputDir, cloud, region, rgName, userTags string, dryRun bool) {
+	mustBeSupportedCloud(cloud, "gcp", "aws", "azure")
 
-	baseCmd := "./ccoctl"
+	baseCmd := toolPath(outputDir, "ccoctl")
 	// Omitting --output-dir flag to let ccoctl save manifests to ./manifests (default) - from there we don't have to move it.
 	args := []string{cloud, "create-all", "--name", rgName, "--region", region, "--credentials-requests-dir", defaultCredRequestDir}
 	switch cloud {
@@ -210,51 +283,104 @@ func ExecuteCcoctl(outputDir, cloud, region, rgName string, dryRun bool) {
 	case "aws":
 		args = append(args, "--create-private-s3-bucket")
 	case "azure":
-		azureAccount := getAzureCredentials()
+		azureAccount := getAzureCredentials(ctx)
 		args = append(args, "--subscription-id", azureAccount.ID, "--dnszone-resource-group-name", defaultAzureResourceGroup, "--tenant-id", azureAccount.TenantID)
+		// Only the Azure ccoctl can tag the resources it creates.
+		if userTags != "" {
+			args = append(args, "--user-tags", userTags)
+		}
+	}
+
+	if dryRun {
+		logger(ctx).Println("Dry run requested, skipping ccoctl command.")
+		logger(ctx).Printf("To execute ccoctl command manually run: %v %v", baseCmd, strings.Join(args, " "))
+		return
+	}
+
+	logger(ctx).Printf("Creating cloud credential manifests.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
+
+}
+
+// ExecuteCcoctlIBMCloud creates a service ID with an API key for every CredentialsRequest, on IBM Cloud and
+// Power VS. The secrets are written to manifests/ of outputDir.
+func ExecuteCcoctlIBMCloud(ctx context.Context, outputDir, name, resourceGroup string, dryRun bool) {
+	baseCmd := toolPath(outputDir, "ccoctl")
+	args := []string{"ibmcloud", "create-service-id", "--credentials-requests-dir", defaultCredRequestDir, "--name", name, "--output-dir", "."}
+	if resourceGroup != "" {
+		args = append(args, "--resource-group-name", resourceGroup)
 	}
 
 	if dryRun {
-		log.Println("Dry run requested, skipping ccoctl command.")
-		log.Printf("To execute ccoctl command manually run: %v %v", baseCmd, strings.Join(args, " "))
+		logger(ctx).Println("Dry run requested, skipping ccoctl command.")
+		logger(ctx).Printf("To execute ccoctl command manually run: %v %v", baseCmd, strings.Join(args, " "))
 		return
 	}
 
-	log.Printf("Creating cloud credential manifests.")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	mustIBMCloudAPIKey()
+	logger(ctx).Printf("Creating IBM Cloud service IDs.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
+}
 
+// DeleteCcoctlIBMCloud deletes the service IDs ExecuteCcoctlIBMCloud created with the same name.
+func DeleteCcoctlIBMCloud(ctx context.Context, outputDir, name string) {
+	mustIBMCloudAPIKey()
+	baseCmd := toolPath(outputDir, "ccoctl")
+	args := []string{"ibmcloud", "delete-service-id", "--credentials-requests-dir", defaultCredRequestDir, "--name", name}
+	logger(ctx).Printf("Deleting IBM Cloud service IDs.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 }
 
-func CreateGCPServiceAccount(userName, outputDir string) {
-	mustGcloudAuth()
+// ExecuteCcoctlNutanix creates the secrets of every CredentialsRequest from the Prism Central credentials in
+// credentialsFile. The secrets are written to manifests/ of outputDir.
+func ExecuteCcoctlNutanix(ctx context.Context, outputDir, credentialsFile string, dryRun bool) {
+	file, err := filepath.Abs(credentialsFile)
+	if err != nil {
+		panic(fmt.Sprintf("Could not resolve relative path to Nutanix credentials: %v", err))
+	}
+	baseCmd := toolPath(outputDir, "ccoctl")
+	args := []string{"nutanix", "create-shared-secrets", "--credentials-requests-dir", defaultCredRequestDir, "--output-dir", ".", "--credentials-source-filepath", file}
+
+	if dryRun {
+		logger(ctx).Println("Dry run requested, skipping ccoctl command.")
+		logger(ctx).Printf("To execute ccoctl command manually run: %v %v", baseCmd, strings.Join(args, " "))
+		return
+	}
+
+	logger(ctx).Printf("Creating Nutanix credential secrets.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
+}
+
+func CreateGCPServiceAccount(ctx context.Context, userName, outputDir string) {
+	mustGcloudAuth(ctx)
 	serviceAccountName := fmt.Sprintf("%s-development", userName)
-	outputCredentialsFile := filepath.Join(outputDir, "gcp-service-account.json")
+	outputCredentialsFile := filepath.Join(outputDir, gcpCredentialsFile)
 	baseCmd := "gcloud"
 	var serviceAccountEmail string
 
 	// First check if the account already exists
 	args := []string{"iam", "service-accounts", "list", "--filter", fmt.Sprintf("displayName:%s", serviceAccountName), "--format", "value(displayName)"}
-	output, _, _ := runCommand(baseCmd, "", args...)
-	log.Printf("service account found: %#v needed: %#v", output, serviceAccountName)
+	output, _, _ := runCommand(ctx, baseCmd, "", args...)
+	logger(ctx).Printf("service account found: %#v needed: %#v", output, serviceAccountName)
 	if output != serviceAccountName {
 		// Create the service account
-		log.Printf("Creating service account %s", serviceAccountName)
+		logger(ctx).Printf("Creating service account %s", serviceAccountName)
 		args = []string{"iam", "service-accounts", "create", serviceAccountName, "--display-name", serviceAccountName}
-		runCommand(baseCmd, "", args...)
+		runCommand(ctx, baseCmd, "", args...)
 
 		// Get service account email
 		args = []string{"iam", "service-accounts", "list", "--filter", fmt.Sprintf("displayName:%s", serviceAccountName), "--format", "value(email)"}
-		serviceAccountEmail, _, _ = runCommand(baseCmd, "", args...)
+		serviceAccountEmail, _, _ = runCommand(ctx, baseCmd, "", args...)
 		if serviceAccountEmail == "" {
-			log.Fatalf("Could not get service account email for %s", serviceAccountName)
+			logger(ctx).Fatalf("Could not get service account email for %s", serviceAccountName)
 			return
 		}
 
 		// Get service account project ID
 		args = []string{"iam", "service-accounts", "list", "--filter", fmt.Sprintf("displayName:%s", serviceAccountName), "--format", "value(projectId)"}
-		projectID, _, _ := runCommand(baseCmd, "", args...)
+		projectID, _, _ := runCommand(ctx, baseCmd, "", args...)
 		if projectID == "" {
-			log.Fatalf("Could not get project ID for %s", serviceAccountName)
+			logger(ctx).Fatalf("Could not get project ID for %s", serviceAccountName)
 			return
 		}
 
@@ -276,33 +402,48 @@ func CreateGCPServiceAccount(userName, outputDir string) {
 		//TODO: IAM commands should have a retry and backoff - this is a known issue with gcloud and is caused by some request limit per second which role creation easily exceeds.
 		for _, role := range roles {
 			args = []string{"projects", "add-iam-policy-binding", projectID, "--member", "serviceAccount:" + serviceAccountEmail, "--role", role, "--condition", "None"}
-			runCommand(baseCmd, "", args...)
-			time.Sleep(3 * time.Second) //TODO: fix this after exponential backoff is implemented
+			runCommand(ctx, baseCmd, "", args...)
+			sleepContext(ctx, 3*time.Second) //TODO: fix this after exponential backoff is implemented
 		}
 
 		// Create service account key
 		args = []string{"iam", "service-accounts", "keys", "create", outputCredentialsFile, "--iam-account", serviceAccountEmail}
-		runCommand(baseCmd, "", args...)
+		runCommand(ctx, baseCmd, "", args...)
+		restrictPermissions(outputCredentialsFile)
 
 	} else {
 		// If storage account exists we would have to inspect the keys, save them as a file, make sure they're valid and what not - too complicated, it's easier to just recreate.
 		args = []string{"iam", "service-accounts", "list", "--filter", fmt.Sprintf("displayName:%s", serviceAccountName), "--format", "value(email)"}
-		serviceAccountEmail, _, _ = runCommand(baseCmd, "", args...)
-		log.Printf("Service account %v already exists, please remove it and start again.", serviceAccountName)
-		log.Printf("HINT: To remove service account run: gcloud iam service-accounts delete %s", serviceAccountEmail)
+		serviceAccountEmail, _, _ = runCommand(ctx, baseCmd, "", args...)
+		logger(ctx).Printf("Service account %v already exists, please remove it and start again.", serviceAccountName)
+		logger(ctx).Printf("HINT: To remove service account run: gcloud iam service-accounts delete %s", serviceAccountEmail)
 		panic("Installation aborted.")
 	}
 
-	if err := os.Setenv("GOOGLE_APPLICATION_CREDENTIALS", outputCredentialsFile); err != nil {
-		panic(fmt.Sprintf("could not set GOOGLE_APPLICATION_CREDENTIALS env var: %v", err))
+	logger(ctx).Printf("Service account key saved to %s", outputCredentialsFile)
+}
+
+// gcpCredentialsEnv points GOOGLE_APPLICATION_CREDENTIALS of the commands run for a GCP cluster to the service
+// account key in its output dir. Create always writes the key before the installer runs, destroy uses it if
+// the cluster was created with it.
+func gcpCredentialsEnv(ctx context.Context, conf *Config) context.Context {
+	if conf.Platform() != "gcp" {
+		return ctx
 	}
-	log.Printf("GOOGLE_APPLICATION_CREDENTIALS environment variable has been set to %s", outputCredentialsFile)
+	path, err := filepath.Abs(filepath.Join(conf.OutputDir, gcpCredentialsFile))
+	if err != nil {
+		panic(fmt.Errorf("could not resolve output dir %v: %v", conf.OutputDir, err))
+	}
+	if _, err := os.Stat(path); err != nil && conf.Action != "create" {
+		return ctx
+	}
+	return withCommandEnv(ctx, "GOOGLE_APPLICATION_CREDENTIALS="+path)
 }
 
-func mustGcloudAuth() {
+func mustGcloudAuth(ctx context.Context) {
 	baseCmd := "gcloud"
 	args := []string{"auth", "list", "--format", "json"}
-	stdout, _, _ := runCommand(baseCmd, "", args...)
+	stdout, _, _ := runCommand(ctx, baseCmd, "", args...)
 
 	var authList []map[string]string
 	err := json.Unmarshal([]byte(stdout), &authList)
@@ -319,10 +460,10 @@ func mustGcloudAuth() {
 	panic("Not logged in to gcloud. Please run 'gcloud auth login' first.")
 }
 
-func getAzureCredentials() azureAccountType {
+func getAzureCredentials(ctx context.Context) azureAccountType {
 	baseCmd := "az"
 	args := []string{"account", "show", "-o", "json"}
-	stdout, stderr, code := runCommand(baseCmd, "", args...)
+	stdout, stderr, code := runCommand(ctx, baseCmd, "", args...)
 	if code != 0 {
 		panic(fmt.Sprintf("Error running \"az account show\", make sure to first log in with \"az login\": %s", stderr))
 	}
@@ -338,7 +479,7 @@ func getAzureCredentials() azureAccountType {
 //	baseCmd := "awk"
 //	args := []string{"/infrastructureName:/{print $2}", "manifests/cluster-infrastructure-02-config.yml"}
 //	log.Println("Getting Infrastructure name")
-//	out, _, _ := runCommand(baseCmd, dir, args...)
+//	out, _, _ := runCommand(ctx, baseCmd, dir, args...)
 //	infrastructureName := strings.TrimSuffix(out, "\n")
 //	if sanitize {
 //		// When passing a --name to ccoctl for Azure, the tool uses it for storage account name and has to be sanitized.
@@ -349,41 +490,39 @@ func getAzureCredentials() azureAccountType {
 //	return infrastructureName
 //}
 
-func checkVCenterReachable() {
-	url := "https://vcenter-2.devqe.ibmc.devcluster.openshift.com/"
-	client := &http.Client{
-		Timeout: 10 * time.Second,
-	}
-
-	resp, err := client.Get(url)
-	if err != nil {
-		fmt.Printf("Warning: URL %s is not reachable. Error: %v\n", url, err)
-		panic("VCenter is not reachable. Please check your VPN connection and try again.")
-	}
-	defer resp.Body.Close()
-
-	fmt.Printf("URL %s is reachable\n", url)
-}
-
-func InstallCluster(installDir string, verbose bool) {
-	baseCmd := "./openshift-install"
+// InstallCluster runs openshift-install create cluster and returns its failure as an error, so that the caller
+// can gather logs of the half-built cluster.
+func InstallCluster(ctx context.Context, installDir string, verbose bool) (err error) {
+	baseCmd := toolPath(installDir, "openshift-install")
 	args := []string{"create", "cluster"}
 	if verbose {
 		args = append(args, "--log-level", "debug")
 	}
-	log.Printf("Starting cluster installation.")
-	_, _, _ = runCommand(baseCmd, installDir, args...)
+	logger(ctx).Printf("Starting cluster installation.")
+	// auth/ holds the kubeconfig and kubeadmin password, it is created even if the installation fails.
+	defer restrictPermissions(filepath.Join(installDir, "auth"))
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+		}
+	}()
+	_, _, _ = runCommand(ctx, baseCmd, installDir, args...)
 	//TODO: this hides output from the progress - fix it
+	return nil
 }
 
-func DestroyCluster(installDir string, verbose bool) {
-	baseCmd := "./openshift-install"
+func DestroyCluster(ctx context.Context, installDir string, verbose bool) {
+	baseCmd := toolPath(installDir, "openshift-install")
 	args := []string{"destroy", "cluster"}
 	if verbose {
 		args = append(args, "--log-level", "debug")
 	}
-	log.Printf("Destroying cluster.")
-	_, _, _ = runCommand(baseCmd, installDir, args...)
+	logger(ctx).Printf("Destroying cluster.")
+	_, _, _ = runCommand(ctx, baseCmd, installDir, args...)
 }
 
 type azureAccountType struct {
@@ -403,84 +542,50 @@ type azureAccountType struct {
 	} `json:"user"`
 }
 
-// sanitizeResourceGroupName ensures the string meets Azure storage account requirements:
-// - Between 3 and 24 characters
-// - Only lowercase letters and numbers
-// - Trims newline characters
-func SanitizeResourceGroupName(name string) string {
-	// First trim the newline character
-	name = strings.TrimSuffix(name, "\n")
-
-	// Convert to lowercase
-	name = strings.ToLower(name)
-
-	// Keep only lowercase letters and numbers
-	var result strings.Builder
-	for _, char := range name {
-		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
-			result.WriteRune(char)
-		}
-	}
-
-	// Truncate to 24 characters if longer
-	sanitized := result.String()
-	if len(sanitized) > 24 {
-		sanitized = sanitized[:24]
-	}
-
-	// Ensure at least 3 characters
-	// If not enough valid characters, append placeholder digits
-	for len(sanitized) < 3 {
-		sanitized += "0"
-	}
-
-	return sanitized
-}
-
 /////////////
 
 // Deprecated
-func alibabaCreateCredRequestManifests(pullSecretFile, outputDir, imageUrl, region, cloud string) {
+func alibabaCreateCredRequestManifests(ctx context.Context, pullSecretFile, outputDir, imageUrl, region, cloud string) {
 	// get absolute path of pullSecretFile
 	file, err := filepath.Abs(pullSecretFile)
 	if err != nil {
 		panic(fmt.Sprintf("Could not resolve relative path to pull secret: %v", err))
 	}
 
-	log.Printf("Extracting manifests from image: %v", imageUrl)
-	baseCmd := "./openshift-install"
+	logger(ctx).Printf("Extracting manifests from image: %v", imageUrl)
+	baseCmd := toolPath(outputDir, "openshift-install")
 	args := []string{"create", "manifests", "--log-level", "debug"}
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 	baseCmd = "awk"
 	args = []string{"/infrastructureName:/{print $2}", "manifests/cluster-infrastructure-02-config.yml"}
-	log.Println("Getting Infrastructure name")
-	out, _, _ := runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Println("Getting Infrastructure name")
+	out, _, _ := runCommand(ctx, baseCmd, outputDir, args...)
 	infrastructureName := strings.TrimSuffix(out, "\n")
-	log.Printf("Infrastructure name found: %v", infrastructureName)
+	logger(ctx).Printf("Infrastructure name found: %v", infrastructureName)
 
 	baseCmd = "mkdir"
 	args = []string{"creds", "cco-manifests"}
-	log.Println("Creating creds directory.")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Println("Creating creds directory.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
-	baseCmd = "./oc"
+	baseCmd = toolPath(outputDir, "oc")
 	args = []string{"adm", "-a", file, "release", "extract", "--credentials-requests", "--cloud", cloud, "--to", "./creds", imageUrl}
-	log.Println("Extracting credential request")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Println("Extracting credential request")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
-	baseCmd = "./ccoctl"
+	baseCmd = toolPath(outputDir, "ccoctl")
 	args = []string{cloud, "create-ram-users", "--region", region, "--name", infrastructureName, "--credentials-requests-dir", "./creds", "--output-dir", "./cco-manifests"}
-	log.Printf("Creating cloud credential manifests.")
-	_, _, _ = runCommand(baseCmd, outputDir, args...)
+	logger(ctx).Printf("Creating cloud credential manifests.")
+	_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 
 	// Copy files to final manifests dir.
 	path := filepath.Join(outputDir, "cco-manifests/manifests/*")
 	files, _ := filepath.Glob(path)
 	baseCmd = "cp"
-	log.Printf("Copying cloud credential manifests to manifests dir.")
+	logger(ctx).Printf("Copying cloud credential manifests to manifests dir.")
 	for _, f := range files { //TODO: change this to one command
 		args := []string{"-v", "-r", f, "./manifests"}
-		_, _, _ = runCommand(baseCmd, outputDir, args...)
+		_, _, _ = runCommand(ctx, baseCmd, outputDir, args...)
 	}
 }
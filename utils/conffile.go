@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/ini.v1"
+)
+
+type confFileKey struct {
+	name    string
+	comment string
+}
+
+// confFileSections defines layout of conf.env files written by WriteConfigFile, it follows conf.env.template.
+var confFileSections = []struct {
+	title string
+	keys  []confFileKey
+}{
+	{"General settings", []confFileKey{
+		{"cloud", "cloud or cloud variant, one of the --cloud values"},
+		{"userName", "username should match kerberos ID (required for vSphere installations)"},
+		{"clusterName", ""},
+		{"outputDir", ""},
+		{"cloudRegion", ""},
+		{"image", "release image, e.g. from https://amd64.ocp.releases.ci.openshift.org/"},
+		{"resourceGroup", ""},
+		{"hooksFile", "post-install hooks, see hooks.yaml.template"},
+		{"extraManifests", "files copied to manifests/ before installation"},
+		{"extraOpenshift", "files copied to openshift/ before installation"},
+		{"overwriteManifests", ""},
+	}},
+	{"Topology and machine pools", []confFileKey{
+		{"topology", "ha, compact or sno, sets default replicas"},
+		{"controlPlaneReplicas", ""},
+		{"controlPlaneType", "instance type, on vSphere <cpus>x<memory GiB>"},
+		{"controlPlaneVolumeSize", "root volume size in GiB"},
+		{"controlPlaneVolumeType", ""},
+		{"computeReplicas", ""},
+		{"computeType", ""},
+		{"computeVolumeSize", ""},
+		{"computeVolumeType", ""},
+		{"machinePools", "extra pools: <name>:replicas=3,type=m6i.2xlarge;<name>:..."},
+	}},
+	{"Architecture", []confFileKey{
+		{"arch", "amd64, arm64 or multi, the release image has to match"},
+		{"controlPlaneArch", "per pool architecture for clusters with arch=multi"},
+		{"computeArch", ""},
+	}},
+	{"Networking", []confFileKey{
+		{"networkType", "OVNKubernetes, or OpenShiftSDN for releases older than 4.15"},
+		{"clusterNetwork", "pod networks, one of each IP family for dual-stack (vSphere only)"},
+		{"hostPrefix", "node subnet size of IPv4 cluster networks"},
+		{"machineNetwork", ""},
+		{"serviceNetwork", ""},
+		{"publish", "External or Internal (private cluster on AWS, GCP and Azure)"},
+		{"httpProxy", "cluster-wide proxy, a password in the URL is masked in logs"},
+		{"httpsProxy", ""},
+		{"noProxy", "comma separated domains, IP addresses and CIDRs"},
+	}},
+	{"Existing networks", []confFileKey{
+		{"awsSubnets", "install to existing subnets and networks instead of creating them, keys of the cloud's platform only"},
+		{"awsHostedZone", ""},
+		{"gcpNetwork", ""},
+		{"gcpControlPlaneSubnet", ""},
+		{"gcpComputeSubnet", ""},
+		{"gcpNetworkProject", "host project of a shared VPC"},
+		{"azureNetworkResourceGroup", ""},
+		{"azureVirtualNetwork", ""},
+		{"azureControlPlaneSubnet", ""},
+		{"azureComputeSubnet", ""},
+	}},
+	{"Mirror registry", []confFileKey{
+		{"mirrorRegistry", "mirror the release here before installing, see hack/mirror-registry.sh"},
+		{"mirrorAuthFile", "credentials of the mirror, merged into the pull secret"},
+		{"mirrorInsecure", ""},
+		{"imageSourcesFile", "mapping of a release mirrored before, used without mirrorRegistry"},
+		{"additionalTrustBundleFile", "CA certificates trusted by the cluster, e.g. of the mirror or a proxy"},
+	}},
+	{"Tags of cloud resources", []confFileKey{
+		{"owner", "owner defaults to userName"},
+		{"team", ""},
+		{"expiresIn", "expiresIn sets the expirationDate tag, \"0\" omits it"},
+		{"expirationDate", ""},
+		{"tags", "additional tags: key=value,key=value"},
+	}},
+	{"Secrets settings", []confFileKey{
+		{"sshPublicKeyFile", ""},
+		{"pullSecretFile", ""},
+	}},
+	{"vSphere specific values", []confFileKey{
+		{"vSphereBaseDomain", ""},
+		{"vSphereVCenterSubdomain", ""},
+		{"vSphereApiVIP", "dual-stack clusters need one VIP of each IP family: <IPv4>,<IPv6>"},
+		{"vSphereIngressVIP", ""},
+		{"vSpherePasswordFile", "file with the vCenter password, keep it readable only by you"},
+		{"vSpherePassword", ""},
+	}},
+	{"IBM Cloud and Power VS", []confFileKey{
+		{"baseDomain", "DNS domain of IBM Cloud, Power VS, Nutanix, OpenStack and agent clusters, cloudRegion is the region"},
+		{"ibmCloudResourceGroup", "existing resource group, the installer creates one if unset"},
+		{"powervsUserID", ""},
+		{"powervsZone", ""},
+		{"powervsResourceGroup", ""},
+		{"powervsServiceInstanceGUID", "existing workspace, the installer creates one if unset"},
+	}},
+	{"Nutanix", []confFileKey{
+		{"nutanixPrismCentral", ""},
+		{"nutanixPort", "defaults to 9440"},
+		{"nutanixUsername", ""},
+		{"nutanixPasswordFile", "file with the Prism Central password, keep it readable only by you"},
+		{"nutanixPassword", ""},
+		{"nutanixPrismElementAddress", ""},
+		{"nutanixPrismElementUUID", ""},
+		{"nutanixSubnetUUID", ""},
+		{"nutanixApiVIP", ""},
+		{"nutanixIngressVIP", ""},
+	}},
+	{"OpenStack", []confFileKey{
+		{"openstackCloud", "entry of clouds.yaml"},
+		{"openstackCloudsFile", "found where OpenStack clients look if unset"},
+		{"openstackExternalNetwork", ""},
+		{"openstackFlavor", ""},
+		{"openstackApiFloatingIP", "existing floating IPs, require openstackExternalNetwork"},
+		{"openstackIngressFloatingIP", ""},
+	}},
+	{"Agent-based installation", []confFileKey{
+		{"agentPlatform", "none or baremetal, with cloud=agent"},
+		{"agentHosts", "<hostname>:role=master,mac=<MAC>,ip=<CIDR>;<hostname>:..., ip is optional"},
+		{"rendezvousIP", "address of a master host"},
+		{"agentGateway", "static networking of hosts with an ip"},
+		{"agentDNS", ""},
+		{"agentApiVIP", "baremetal only"},
+		{"agentIngressVIP", ""},
+	}},
+	{"Values below used by makefile, change only if you know what you are doing", []confFileKey{
+		{"imageRepo", ""},
+		{"imageName", ""},
+		{"imageTag", ""},
+		{"homeDir", ""},
+		{"engine", ""},
+	}},
+}
+
+// ReadConfigFile returns the raw key/value pairs of a conf.env file. Values are not expanded,
+// so "${HOME}" stays as written.
+func ReadConfigFile(path string) (map[string]string, error) {
+	file, err := ini.Load(path)
+	if err != nil {
+		return nil, err
+	}
+	values := map[string]string{}
+	for _, key := range file.Section("").Keys() {
+		values[key.Name()] = key.Value()
+	}
+	return values, nil
+}
+
+// WriteConfigFile writes values as a commented conf.env file readable only by the current user.
+// Known keys are grouped like in conf.env.template, unknown keys are kept in a separate section.
+func WriteConfigFile(path string, values map[string]string) error {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "## This config file is sharing values between scripts and makefile\n")
+	fmt.Fprintf(&buf, "## Written by install-tool init on %s\n", time.Now().Format(time.RFC3339))
+
+	written := map[string]bool{}
+	for _, section := range confFileSections {
+		var lines []string
+		for _, key := range section.keys {
+			value, ok := values[key.name]
+			written[key.name] = true
+			if !ok || value == "" {
+				continue
+			}
+			if key.comment != "" {
+				lines = append(lines, "# "+key.comment)
+			}
+			lines = append(lines, key.name+"="+value)
+		}
+		if len(lines) > 0 {
+			fmt.Fprintf(&buf, "\n## %s\n%s\n", section.title, strings.Join(lines, "\n"))
+		}
+	}
+
+	var other []string
+	for key, value := range values {
+		if !written[key] && value != "" {
+			other = append(other, key+"="+value)
+		}
+	}
+	if len(other) > 0 {
+		sort.Strings(other)
+		fmt.Fprintf(&buf, "\n## Other settings\n%s\n", strings.Join(other, "\n"))
+	}
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("could not create config directory: %v", err)
+	}
+	if err := os.WriteFile(path, buf.Bytes(), 0600); err != nil {
+		return fmt.Errorf("could not write config file: %v", err)
+	}
+	// WriteFile does not change permissions of an existing file.
+	return os.Chmod(path, 0600)
+}
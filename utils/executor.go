@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"strings"
+)
+
+// Command is a command run through an Executor.
+type Command struct {
+	Name string
+	Args []string
+	Dir  string
+}
+
+func (c Command) String() string {
+	return strings.Join(append([]string{c.Name}, c.Args...), " ")
+}
+
+// Executor runs commands and returns their standard output. Steps that only read state of a cluster
+// use it instead of runCommand, so they can be tested with recorded output.
+type Executor interface {
+	Output(ctx context.Context, cmd Command) ([]byte, error)
+}
+
+type execExecutor struct{}
+
+// DefaultExecutor runs commands on the host.
+var DefaultExecutor Executor = execExecutor{}
+
+func (execExecutor) Output(ctx context.Context, c Command) ([]byte, error) {
+	var stdout, stderr bytes.Buffer
+	cmd := commandContext(ctx, c.Name, c.Dir, c.Args...)
+	cmd.Stdout = &stdout
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return nil, fmt.Errorf("%w: %v interrupted", ErrCancelled, c.Name)
+		}
+		return nil, fmt.Errorf("%v failed: %v: %v", c, err, strings.TrimSpace(stderr.String()))
+	}
+	return stdout.Bytes(), nil
+}
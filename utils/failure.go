@@ -0,0 +1,245 @@
+package utils
+
+import (
+	"archive/tar"
+	"compress/gzip"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"sort"
+	"strings"
+	"time"
+)
+
+const (
+	gatherTimeout     = 30 * time.Minute
+	apiCheckTimeout   = time.Minute
+	failureTimeFormat = "20060102-150405"
+)
+
+// bootstrapCompletePattern matches lines openshift-install logs once the bootstrap node is no longer needed.
+var bootstrapCompletePattern = regexp.MustCompile(`Bootstrap status: complete|Destroying the bootstrap resources`)
+
+type loggerKey struct{}
+
+// withLogger returns a context whose steps log to l. Every run gets its own logger this way instead of
+// log.SetOutput, so runs side by side don't write to each other's step log.
+func withLogger(ctx context.Context, l *log.Logger) context.Context {
+	return context.WithValue(ctx, loggerKey{}, l)
+}
+
+// logger returns the logger of the run ctx belongs to, the standard logger outside of a run.
+func logger(ctx context.Context) *log.Logger {
+	if l, ok := ctx.Value(loggerKey{}).(*log.Logger); ok {
+		return l
+	}
+	return log.Default()
+}
+
+// startStepLog returns a context whose logger copies log output of the tool to a new file in the output
+// directory until stop is called, so every run has its own record of steps next to the logs of openshift-install.
+func startStepLog(ctx context.Context, outputDir string) (_ context.Context, path string, stop func()) {
+	path = filepath.Join(outputDir, toolDir, "steps-"+time.Now().Format(failureTimeFormat)+".log")
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		logger(ctx).Printf("Warning: could not create step log: %v", err)
+		return ctx, "", func() {}
+	}
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0600)
+	if err != nil {
+		logger(ctx).Printf("Warning: could not create step log: %v", err)
+		return ctx, "", func() {}
+	}
+	// The output of the standard logger is the redacting stderr writer set up by main.
+	parent := logger(ctx)
+	l := log.New(io.MultiWriter(parent.Writer(), NewRedactingWriter(f)), parent.Prefix(), parent.Flags())
+	return withLogger(ctx, l), path, func() {
+		f.Close()
+	}
+}
+
+// failInstallation panics with err, unless the installation was cancelled logs are gathered first
+// and the path of the archive is added to the error.
+func failInstallation(ctx context.Context, conf *Config, stepLog string, err error) {
+	if errors.Is(err, ErrCancelled) {
+		panic(err)
+	}
+	logger(ctx).Printf("Installation failed: %v", err)
+	archive, gatherErr := GatherFailureLogs(ctx, conf.OutputDir, stepLog)
+	if gatherErr != nil {
+		panic(fmt.Errorf("%w (gathering logs failed: %v)", err, gatherErr))
+	}
+	logger(ctx).Printf("Logs of the failed installation: %v", archive)
+	panic(fmt.Errorf("%w\nLogs of the failed installation: %v", err, archive))
+}
+
+func bootstrapCompleted(outputDir string) bool {
+	content, err := os.ReadFile(InstallLogPath(outputDir))
+	return err == nil && bootstrapCompletePattern.Match(content)
+}
+
+func apiReachable(ctx context.Context, outputDir string) bool {
+	if _, err := os.Stat(KubeconfigPath(outputDir)); err != nil {
+		return false
+	}
+	ctx, cancel := context.WithTimeout(ctx, apiCheckTimeout)
+	defer cancel()
+	var cv clusterVersion
+	return ocGet(ctx, DefaultExecutor, outputDir, "clusterversion/version", &cv) == nil
+}
+
+// gatherCommand runs a gathering command, its output and errors are kept in the gather dir instead of failing.
+func gatherCommand(ctx context.Context, gatherDir, logName, name, workDir string, args ...string) {
+	ctx, cancel := context.WithTimeout(ctx, gatherTimeout)
+	defer cancel()
+	logFile, err := os.OpenFile(filepath.Join(gatherDir, logName), os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		logger(ctx).Printf("Warning: %v", err)
+		return
+	}
+	defer logFile.Close()
+	out := NewRedactingWriter(logFile)
+	defer out.Flush()
+
+	logger(ctx).Printf("Gathering logs: %v %v", name, strings.Join(args, " "))
+	fmt.Fprintf(out, "$ %v %v\n", name, strings.Join(args, " "))
+	cmd := commandContext(ctx, name, workDir, args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	if err := cmd.Run(); err != nil {
+		fmt.Fprintf(out, "gathering failed: %v\n", err)
+		logger(ctx).Printf("Warning: gathering logs failed: %v", err)
+	}
+}
+
+// GatherFailureLogs collects logs of a failed installation and bundles them with the installer log, redacted
+// install-config and the step log into <outputDir>/install-failure-<timestamp>.tar.gz, returning its path.
+// Bootstrap logs are gathered when bootstrap didn't complete, must-gather when the API is up.
+func GatherFailureLogs(ctx context.Context, outputDir, stepLog string) (string, error) {
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return "", err
+	}
+	name := "install-failure-" + time.Now().Format(failureTimeFormat)
+	gatherDir := filepath.Join(dir, toolDir, name)
+	if err := os.MkdirAll(gatherDir, 0700); err != nil {
+		return "", err
+	}
+
+	if !bootstrapCompleted(dir) {
+		before, _ := filepath.Glob(filepath.Join(dir, "log-bundle-*.tar.gz"))
+		gatherCommand(ctx, gatherDir, "gather-bootstrap.log", filepath.Join(dir, "openshift-install"), dir, "gather", "bootstrap", "--dir", dir)
+		// openshift-install writes the bundle next to its logs, move the new one to the gathered files.
+		after, _ := filepath.Glob(filepath.Join(dir, "log-bundle-*.tar.gz"))
+		for _, bundle := range after {
+			if !slices.Contains(before, bundle) {
+				if err := os.Rename(bundle, filepath.Join(gatherDir, filepath.Base(bundle))); err != nil {
+					logger(ctx).Printf("Warning: %v", err)
+				}
+			}
+		}
+	}
+	if apiReachable(ctx, dir) {
+		gatherCommand(ctx, gatherDir, "must-gather.log", filepath.Join(dir, "oc"), dir,
+			"--kubeconfig", KubeconfigPath(dir), "adm", "must-gather", "--dest-dir", filepath.Join(gatherDir, "must-gather"))
+	} else {
+		logger(ctx).Printf("Cluster API is not reachable, skipping must-gather.")
+	}
+
+	if content, err := os.ReadFile(InstallLogPath(dir)); err == nil {
+		if err := os.WriteFile(filepath.Join(gatherDir, installLogFile), MaskInstallLog(content), 0600); err != nil {
+			logger(ctx).Printf("Warning: %v", err)
+		}
+	}
+	redactedInstallConfig := ""
+	if entry := latestHistoryEntry(dir); entry != "" {
+		redactedInstallConfig = filepath.Join(entry, "install-config.yaml")
+	}
+	for _, file := range []string{redactedInstallConfig, stepLog} {
+		if file == "" {
+			continue
+		}
+		if err := copyFile(file, filepath.Join(gatherDir, filepath.Base(file))); err != nil {
+			logger(ctx).Printf("Warning: could not add %v to failure logs: %v", file, err)
+		}
+	}
+
+	archive := filepath.Join(dir, name+".tar.gz")
+	if err := writeTarGz(archive, gatherDir, name); err != nil {
+		return "", err
+	}
+	if err := os.RemoveAll(gatherDir); err != nil {
+		logger(ctx).Printf("Warning: %v", err)
+	}
+	return archive, nil
+}
+
+// writeTarGz archives the content of srcDir into path, entries are prefixed with prefix.
+func writeTarGz(path, srcDir, prefix string) (err error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if closeErr := f.Close(); err == nil {
+			err = closeErr
+		}
+	}()
+	gz := gzip.NewWriter(f)
+	tw := tar.NewWriter(gz)
+
+	var files []string
+	err = filepath.Walk(srcDir, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		files = append(files, p)
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	sort.Strings(files)
+	for _, p := range files {
+		info, err := os.Lstat(p)
+		if err != nil {
+			return err
+		}
+		if !info.IsDir() && !info.Mode().IsRegular() {
+			continue
+		}
+		rel, _ := filepath.Rel(srcDir, p)
+		header, err := tar.FileInfoHeader(info, "")
+		if err != nil {
+			return err
+		}
+		header.Name = filepath.ToSlash(filepath.Join(prefix, rel))
+		if info.IsDir() {
+			header.Name += "/"
+		}
+		if err := tw.WriteHeader(header); err != nil {
+			return err
+		}
+		if info.IsDir() {
+			continue
+		}
+		src, err := os.Open(p)
+		if err != nil {
+			return err
+		}
+		_, err = io.Copy(tw, src)
+		src.Close()
+		if err != nil {
+			return err
+		}
+	}
+	if err := tw.Close(); err != nil {
+		return err
+	}
+	return gz.Close()
+}
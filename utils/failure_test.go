@@ -0,0 +1,53 @@
+package utils
+
+import (
+	"context"
+	"log"
+	"os"
+	"strings"
+	"testing"
+)
+
+func TestStartStepLog(t *testing.T) {
+	RegisterSecret("step-log-test-secret")
+	std := log.Writer()
+
+	// Runs side by side, each has to keep only its own steps.
+	ctxA, pathA, stopA := startStepLog(context.Background(), t.TempDir())
+	ctxB, pathB, stopB := startStepLog(context.Background(), t.TempDir())
+	if log.Writer() != std {
+		t.Errorf("output of the standard logger was changed")
+	}
+	logger(ctxA).Printf("step of run A")
+	logger(ctxB).Printf("step of run B with step-log-test-secret")
+	log.Printf("outside of a run")
+	stopA()
+	stopB()
+
+	tests := []struct {
+		path    string
+		want    string
+		notWant []string
+	}{
+		{path: pathA, want: "step of run A", notWant: []string{"run B", "outside of a run"}},
+		{path: pathB, want: "step of run B with <redacted>", notWant: []string{"run A", "outside of a run", "step-log-test-secret"}},
+	}
+	for _, tt := range tests {
+		content, err := os.ReadFile(tt.path)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !strings.Contains(string(content), tt.want) {
+			t.Errorf("%v: expected %q, got %q", tt.path, tt.want, content)
+		}
+		for _, s := range tt.notWant {
+			if strings.Contains(string(content), s) {
+				t.Errorf("%v: unexpected %q in %q", tt.path, s, content)
+			}
+		}
+	}
+
+	if logger(context.Background()) != log.Default() {
+		t.Errorf("expected the standard logger outside of a run")
+	}
+}
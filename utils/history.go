@@ -0,0 +1,236 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"io/fs"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// HistoryEntry is one render or upgrade kept in the history of an output directory.
+type HistoryEntry struct {
+	Name string // timestamp of the render or upgrade
+	Path string
+	// Manifests is false if the run stopped before the manifests snapshot was taken.
+	Manifests bool
+	// Upgrade is true for entries of the upgrade command, they hold upgrade.yaml instead of a render.
+	Upgrade bool
+}
+
+// newHistoryEntry creates the history directory of a new render.
+func newHistoryEntry(outputDir string) string {
+	base := filepath.Join(outputDir, historyDir, time.Now().Format(failureTimeFormat))
+	path := base
+	for i := 2; ; i++ {
+		err := os.MkdirAll(filepath.Dir(path), 0700)
+		if err == nil {
+			err = os.Mkdir(path, 0700)
+		}
+		if err == nil {
+			return path
+		}
+		if !errors.Is(err, fs.ErrExist) {
+			panic(fmt.Errorf("could not create history entry: %v", err))
+		}
+		path = fmt.Sprintf("%s-%d", base, i)
+	}
+}
+
+// History returns renders and upgrades kept in outputDir, oldest first.
+func History(outputDir string) ([]HistoryEntry, error) {
+	dirs, err := os.ReadDir(filepath.Join(outputDir, historyDir))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, d := range dirs {
+		if !d.IsDir() {
+			continue
+		}
+		path := filepath.Join(outputDir, historyDir, d.Name())
+		_, err := os.Stat(filepath.Join(path, "manifests"))
+		_, upgradeErr := os.Stat(filepath.Join(path, upgradeFile))
+		entries = append(entries, HistoryEntry{Name: d.Name(), Path: path, Manifests: err == nil, Upgrade: upgradeErr == nil})
+	}
+	sort.Slice(entries, func(i, j int) bool { return entries[i].Name < entries[j].Name })
+	return entries, nil
+}
+
+// Renders returns entries that are renders, leaving out upgrades.
+func Renders(entries []HistoryEntry) []HistoryEntry {
+	var renders []HistoryEntry
+	for _, e := range entries {
+		if !e.Upgrade {
+			renders = append(renders, e)
+		}
+	}
+	return renders
+}
+
+// latestHistoryEntry returns path of the newest render, or an empty string if there is none.
+func latestHistoryEntry(outputDir string) string {
+	entries, err := History(outputDir)
+	entries = Renders(entries)
+	if err != nil || len(entries) == 0 {
+		return ""
+	}
+	return entries[len(entries)-1].Path
+}
+
+// snapshotManifests copies manifests/ and openshift/ into the newest history entry, secrets redacted.
+// It's taken right before create cluster, when the installer consumes the manifests.
+func snapshotManifests(ctx context.Context, outputDir string) {
+	entry := latestHistoryEntry(outputDir)
+	if entry == "" {
+		return
+	}
+	for _, dir := range []string{"manifests", "openshift"} {
+		src := filepath.Join(outputDir, dir)
+		if _, err := os.Stat(src); err != nil {
+			continue
+		}
+		err := filepath.WalkDir(src, func(path string, d fs.DirEntry, err error) error {
+			if err != nil || d.IsDir() {
+				return err
+			}
+			rel, _ := filepath.Rel(outputDir, path)
+			dst := filepath.Join(entry, rel)
+			switch strings.ToLower(filepath.Ext(path)) {
+			case ".yaml", ".yml", ".json":
+			default:
+				// Keys and certificates of the installer are not useful to compare and must not be copied.
+				return nil
+			}
+			content, err := os.ReadFile(path)
+			if err != nil {
+				return err
+			}
+			if err := os.MkdirAll(filepath.Dir(dst), 0700); err != nil {
+				return err
+			}
+			return os.WriteFile(dst, redactManifest(content), 0600)
+		})
+		if err != nil {
+			logger(ctx).Printf("Warning: could not snapshot %v: %v", src, err)
+		}
+	}
+	logger(ctx).Printf("Manifests snapshot saved to %v", entry)
+}
+
+// redactManifest masks data of Secrets and registered secrets in a manifest. Files with a Secret are re-encoded,
+// so their formatting may differ from the original.
+func redactManifest(content []byte) []byte {
+	var docs []*yaml.Node
+	hasSecret := false
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var doc yaml.Node
+		if err := decoder.Decode(&doc); err != nil {
+			if !errors.Is(err, io.EOF) {
+				return []byte(Redact(string(content)))
+			}
+			break
+		}
+		if redactSecretNode(&doc) {
+			hasSecret = true
+		}
+		docs = append(docs, &doc)
+	}
+	if !hasSecret {
+		return []byte(Redact(string(content)))
+	}
+
+	var buf bytes.Buffer
+	encoder := yaml.NewEncoder(&buf)
+	encoder.SetIndent(2)
+	for _, doc := range docs {
+		if err := encoder.Encode(doc); err != nil {
+			return []byte(RedactedValue + "\n")
+		}
+	}
+	encoder.Close()
+	return []byte(Redact(buf.String()))
+}
+
+// redactSecretNode masks values of data and stringData if the document is a Secret.
+func redactSecretNode(doc *yaml.Node) bool {
+	if doc.Kind != yaml.DocumentNode || len(doc.Content) == 0 || doc.Content[0].Kind != yaml.MappingNode {
+		return false
+	}
+	root := doc.Content[0]
+	if mappingValue(root, "kind") == nil || mappingValue(root, "kind").Value != "Secret" {
+		return false
+	}
+	for _, key := range []string{"data", "stringData"} {
+		data := mappingValue(root, key)
+		if data == nil || data.Kind != yaml.MappingNode {
+			continue
+		}
+		for i := 1; i < len(data.Content); i += 2 {
+			data.Content[i] = &yaml.Node{Kind: yaml.ScalarNode, Value: RedactedValue}
+		}
+	}
+	return true
+}
+
+func mappingValue(node *yaml.Node, key string) *yaml.Node {
+	for i := 0; i+1 < len(node.Content); i += 2 {
+		if node.Content[i].Value == key {
+			return node.Content[i+1]
+		}
+	}
+	return nil
+}
+
+// FindHistoryEntry returns the entry with the given name, or the entry counted from the newest for "-1", "-2" etc.
+func FindHistoryEntry(entries []HistoryEntry, name string) (HistoryEntry, error) {
+	var offset int
+	if _, err := fmt.Sscanf(name, "-%d", &offset); err == nil && offset > 0 {
+		if offset > len(entries) {
+			return HistoryEntry{}, fmt.Errorf("only %d renders in history", len(entries))
+		}
+		return entries[len(entries)-offset], nil
+	}
+	for _, e := range entries {
+		if e.Name == name {
+			return e, nil
+		}
+	}
+	return HistoryEntry{}, fmt.Errorf("render %q not found in history", name)
+}
+
+// DiffHistory writes a unified diff of two renders to out using diff(1). It returns whether they differ.
+// Manifests are compared only if both renders have their snapshot.
+func DiffHistory(ctx context.Context, from, to HistoryEntry, out io.Writer) (bool, error) {
+	args := []string{"-ruN", from.Name, to.Name}
+	if !from.Manifests || !to.Manifests {
+		logger(ctx).Printf("Comparing only install-config.yaml, manifests were not kept for both renders.")
+		args = []string{"-uN", filepath.Join(from.Name, "install-config.yaml"), filepath.Join(to.Name, "install-config.yaml")}
+	}
+	cmd := commandContext(ctx, "diff", filepath.Dir(from.Path), args...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	err := cmd.Run()
+	var exitErr *exec.ExitError
+	switch {
+	case err == nil:
+		return false, nil
+	case errors.As(err, &exitErr) && exitErr.ExitCode() == 1:
+		return true, nil
+	default:
+		return false, fmt.Errorf("diff failed: %v", err)
+	}
+}
@@ -0,0 +1,309 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"text/template"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultHookTimeout      = 10 * time.Minute
+	defaultOperatorSource   = "redhat-operators"
+	operatorPollInterval    = 10 * time.Second
+	hooksLogDir             = "hooks"
+	operatorSourceNamespace = "openshift-marketplace"
+)
+
+var hookNamePattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9_.]*[a-z0-9])?$`)
+
+// HooksFile is the format of the file given by hooksFile, see hooks.yaml.template.
+type HooksFile struct {
+	Hooks []Hook `yaml:"hooks"`
+}
+
+// Hook is a post-install step run against the new cluster. Exactly one of Manifests, Script and Operator is set.
+type Hook struct {
+	Name string `yaml:"name"`
+	// Manifests is a YAML file or a directory of YAML files applied with oc.
+	Manifests string `yaml:"manifests,omitempty"`
+	// Script is an executable run with KUBECONFIG set and oc of the installation in PATH.
+	Script   string        `yaml:"script,omitempty"`
+	Args     []string      `yaml:"args,omitempty"`
+	Operator *OperatorHook `yaml:"operator,omitempty"`
+	Timeout  string        `yaml:"timeout,omitempty"`
+	Retries  int           `yaml:"retries,omitempty"`
+}
+
+// OperatorHook installs an operator from an OLM catalog and waits for its CSV to succeed.
+type OperatorHook struct {
+	Package   string `yaml:"package"`
+	Channel   string `yaml:"channel"`
+	Namespace string `yaml:"namespace"`
+	Source    string `yaml:"source,omitempty"`
+	// AllNamespaces makes the operator watch all namespaces instead of just its own.
+	AllNamespaces bool `yaml:"allNamespaces,omitempty"`
+}
+
+func (h Hook) timeout() time.Duration {
+	if h.Timeout == "" {
+		return defaultHookTimeout
+	}
+	d, _ := time.ParseDuration(h.Timeout)
+	return d
+}
+
+func (h Hook) validate() error {
+	if !hookNamePattern.MatchString(h.Name) {
+		return fmt.Errorf("hook name %q must consist of lowercase letters, digits, '-', '_' and '.'", h.Name)
+	}
+	kinds := 0
+	for _, set := range []bool{h.Manifests != "", h.Script != "", h.Operator != nil} {
+		if set {
+			kinds++
+		}
+	}
+	if kinds != 1 {
+		return fmt.Errorf("hook %q must set exactly one of manifests, script and operator", h.Name)
+	}
+	if h.Timeout != "" {
+		if d, err := time.ParseDuration(h.Timeout); err != nil || d <= 0 {
+			return fmt.Errorf("hook %q has invalid timeout %q", h.Name, h.Timeout)
+		}
+	}
+	if h.Retries < 0 {
+		return fmt.Errorf("hook %q has negative retries", h.Name)
+	}
+	if h.Operator != nil && (h.Operator.Package == "" || h.Operator.Channel == "" || h.Operator.Namespace == "") {
+		return fmt.Errorf("operator hook %q requires package, channel and namespace", h.Name)
+	}
+	for _, path := range []string{h.Manifests, h.Script} {
+		if path == "" {
+			continue
+		}
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("hook %q: %v", h.Name, err)
+		}
+	}
+	return nil
+}
+
+// LoadHooks reads and validates a hooks file. Relative paths of manifests and scripts are relative to the file.
+func LoadHooks(path string) ([]Hook, error) {
+	path = os.ExpandEnv(path)
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var file HooksFile
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	decoder.KnownFields(true)
+	if err := decoder.Decode(&file); err != nil && !errors.Is(err, io.EOF) {
+		return nil, fmt.Errorf("could not parse %v: %v", path, err)
+	}
+
+	base := filepath.Dir(path)
+	names := map[string]bool{}
+	for i := range file.Hooks {
+		h := &file.Hooks[i]
+		for _, p := range []*string{&h.Manifests, &h.Script} {
+			*p = os.ExpandEnv(*p)
+			if *p != "" && !filepath.IsAbs(*p) {
+				*p = filepath.Join(base, *p)
+			}
+		}
+		if err := h.validate(); err != nil {
+			return nil, fmt.Errorf("%v: %v", path, err)
+		}
+		if names[h.Name] {
+			return nil, fmt.Errorf("%v: duplicate hook name %q", path, h.Name)
+		}
+		names[h.Name] = true
+	}
+	return file.Hooks, nil
+}
+
+// mustLoadHooks loads hooks of the configuration, so mistakes in the hooks file are found before installation.
+func mustLoadHooks(conf *Config) []Hook {
+	if conf.HooksFile == "" {
+		return nil
+	}
+	hooks, err := LoadHooks(conf.HooksFile)
+	if err != nil {
+		panic(fmt.Errorf("invalid hooks file: %v", err))
+	}
+	return hooks
+}
+
+// RunHooks runs hooks in order against the cluster installed in outputDir and stops at the first hook that
+// fails all its attempts. Output of every hook is written to <outputDir>/hooks/<n>-<name>.log.
+func RunHooks(ctx context.Context, outputDir string, hooks []Hook) error {
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Join(dir, hooksLogDir), 0700); err != nil {
+		return fmt.Errorf("could not create hooks log dir: %v", err)
+	}
+	for i, h := range hooks {
+		logPath := filepath.Join(dir, hooksLogDir, fmt.Sprintf("%02d-%s.log", i+1, h.Name))
+		logger(ctx).Printf("Running post-install hook %v, log: %v", h.Name, logPath)
+		if err := runHook(ctx, dir, h, logPath); err != nil {
+			return fmt.Errorf("post-install hook %v failed, see %v: %w", h.Name, logPath, err)
+		}
+		logger(ctx).Printf("Post-install hook %v finished.", h.Name)
+	}
+	return nil
+}
+
+func runHook(ctx context.Context, dir string, h Hook, logPath string) error {
+	// Hook output may contain secrets of the cluster.
+	logFile, err := os.OpenFile(logPath, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return err
+	}
+	defer logFile.Close()
+	out := NewRedactingWriter(logFile)
+	defer out.Flush()
+
+	for attempt := 1; attempt <= h.Retries+1; attempt++ {
+		fmt.Fprintf(out, "### attempt %d/%d started %s, timeout %v\n", attempt, h.Retries+1, time.Now().Format(time.RFC3339), h.timeout())
+		attemptCtx, cancel := context.WithTimeout(ctx, h.timeout())
+		err = runHookAttempt(attemptCtx, dir, h, out)
+		cancel()
+		if err == nil {
+			fmt.Fprintf(out, "### attempt %d succeeded\n", attempt)
+			return nil
+		}
+		if ctx.Err() != nil {
+			return fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+		}
+		if errors.Is(err, context.DeadlineExceeded) {
+			err = fmt.Errorf("timed out after %v", h.timeout())
+		}
+		fmt.Fprintf(out, "### attempt %d failed: %v\n", attempt, err)
+		logger(ctx).Printf("Post-install hook %v attempt %d/%d failed: %v", h.Name, attempt, h.Retries+1, err)
+	}
+	return err
+}
+
+func runHookAttempt(ctx context.Context, dir string, h Hook, out io.Writer) error {
+	switch {
+	case h.Manifests != "":
+		return hookCommand(ctx, dir, out, filepath.Join(dir, "oc"), "apply", "--recursive", "-f", h.Manifests)
+	case h.Script != "":
+		return hookCommand(ctx, dir, out, h.Script, h.Args...)
+	default:
+		return installOperator(ctx, dir, *h.Operator, out)
+	}
+}
+
+// newHookCommand prepares a command with KUBECONFIG of the new cluster and the extracted oc first in PATH,
+// its output goes to the hook log.
+func newHookCommand(ctx context.Context, dir string, out io.Writer, name string, args ...string) *exec.Cmd {
+	fmt.Fprintf(out, "$ %v %v\n", name, strings.Join(args, " "))
+	cmd := commandContext(ctx, name, dir, args...)
+	cmd.Env = append(cmd.Environ(), "KUBECONFIG="+KubeconfigPath(dir), "PATH="+dir+string(os.PathListSeparator)+os.Getenv("PATH"))
+	cmd.Stdout = out
+	cmd.Stderr = out
+	return cmd
+}
+
+func runHookCommand(ctx context.Context, cmd *exec.Cmd) error {
+	if err := cmd.Run(); err != nil {
+		if ctx.Err() != nil {
+			return ctx.Err()
+		}
+		return err
+	}
+	return nil
+}
+
+// hookCommand runs a command and logs its output.
+func hookCommand(ctx context.Context, dir string, out io.Writer, name string, args ...string) error {
+	return runHookCommand(ctx, newHookCommand(ctx, dir, out, name, args...))
+}
+
+// hookOutput runs a command, logs its output and returns its standard output.
+func hookOutput(ctx context.Context, dir string, out io.Writer, name string, args ...string) (string, error) {
+	var stdout bytes.Buffer
+	cmd := newHookCommand(ctx, dir, out, name, args...)
+	cmd.Stdout = io.MultiWriter(out, &stdout)
+	err := runHookCommand(ctx, cmd)
+	fmt.Fprintln(out)
+	return strings.TrimSpace(stdout.String()), err
+}
+
+var operatorManifests = template.Must(template.New("operator").Parse(`apiVersion: v1
+kind: Namespace
+metadata:
+  name: {{ .Namespace }}
+---
+apiVersion: operators.coreos.com/v1
+kind: OperatorGroup
+metadata:
+  name: {{ .Namespace }}
+  namespace: {{ .Namespace }}
+spec:
+{{- if .AllNamespaces }} {}
+{{- else }}
+  targetNamespaces:
+  - {{ .Namespace }}
+{{- end }}
+---
+apiVersion: operators.coreos.com/v1alpha1
+kind: Subscription
+metadata:
+  name: {{ .Package }}
+  namespace: {{ .Namespace }}
+spec:
+  name: {{ .Package }}
+  channel: {{ .Channel }}
+  source: {{ .Source }}
+  sourceNamespace: ` + operatorSourceNamespace + `
+  installPlanApproval: Automatic
+`))
+
+// installOperator subscribes to the operator and waits until its ClusterServiceVersion succeeds.
+func installOperator(ctx context.Context, dir string, op OperatorHook, out io.Writer) error {
+	if op.Source == "" {
+		op.Source = defaultOperatorSource
+	}
+	var manifests bytes.Buffer
+	if err := operatorManifests.Execute(&manifests, op); err != nil {
+		return err
+	}
+	fmt.Fprintf(out, "%s", manifests.String())
+	oc := filepath.Join(dir, "oc")
+	apply := newHookCommand(ctx, dir, out, oc, "apply", "-f", "-")
+	apply.Stdin = &manifests
+	if err := runHookCommand(ctx, apply); err != nil {
+		return err
+	}
+
+	for {
+		var phase string
+		csv, err := hookOutput(ctx, dir, out, oc, "get", "subscription", op.Package, "-n", op.Namespace, "-o", "jsonpath={.status.installedCSV}")
+		if err == nil && csv != "" {
+			phase, err = hookOutput(ctx, dir, out, oc, "get", "csv", csv, "-n", op.Namespace, "-o", "jsonpath={.status.phase}")
+			if err == nil && phase == "Succeeded" {
+				return nil
+			}
+		}
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("operator %v did not become ready (csv %q, phase %q): %w", op.Package, csv, phase, ctx.Err())
+		case <-time.After(operatorPollInterval):
+		}
+	}
+}
@@ -1,118 +1,192 @@
 package utils
 
 import (
+	"context"
 	"fmt"
-	"log"
 	"os"
+	"path/filepath"
+	"slices"
 )
 
 type InstallDriver struct {
 	conf *Config
+	// releaseArch is the architecture of the release image, known once Run starts.
+	releaseArch string
 }
 
 func NewInstallDriver(conf *Config) *InstallDriver {
-	installDriver := InstallDriver{conf}
+	installDriver := InstallDriver{conf: conf}
 	return &installDriver
 }
 
-func (d *InstallDriver) Run() {
+func (d *InstallDriver) Run(ctx context.Context) {
+	d.releaseArch = mustReleaseArchitecture(ctx, d.conf)
 	switch d.conf.Cloud {
 	case "aws":
 		fmt.Println("Driver is preparing AWS installation.")
-		d.awsPreparation()
+		d.awsPreparation(ctx)
 	case "aws-sts":
 		fmt.Println("Driver is preparing AWS STS installation.")
-		d.awsSTSPreparation()
+		d.awsSTSPreparation(ctx)
 	case "aws-odf": //TODO: this should be a parameter instead
 		fmt.Println("Driver is preparing AWS ODF installation.")
-		d.awsPreparation()
+		d.awsPreparation(ctx)
 	case "gcp-wif":
 		fmt.Println("Driver is preparing GCP WIF installation.")
-		d.gcpWIFPreparation()
+		d.gcpWIFPreparation(ctx)
 	case "gcp":
 		fmt.Println("Driver is preparing GCP installation.")
-		d.gcpPreparation()
+		d.gcpPreparation(ctx)
 	case "vsphere":
 		fmt.Println("Driver is preparing vSphere installation.")
-		d.vspherePreparation()
+		d.vspherePreparation(ctx)
 	case "alibaba":
 		fmt.Println("Driver is preparing Alibaba installation.")
-		d.alibabaPreparation()
+		d.alibabaPreparation(ctx)
 	case "azure":
 		fmt.Println("Driver is preparing Azure installation.")
-		d.azurePreparation()
+		d.azurePreparation(ctx)
 	case "azure-wi":
 		fmt.Println("Driver is preparing Azure Workload Identity installation.")
-		d.azureWIPreparation()
+		d.azureWIPreparation(ctx)
+	case "ibmcloud":
+		fmt.Println("Driver is preparing IBM Cloud installation.")
+		d.ibmCloudPreparation(ctx)
+	case "powervs":
+		fmt.Println("Driver is preparing Power VS installation.")
+		d.ibmCloudPreparation(ctx)
+	case "nutanix":
+		fmt.Println("Driver is preparing Nutanix installation.")
+		d.nutanixPreparation(ctx)
+	case "openstack":
+		fmt.Println("Driver is preparing OpenStack installation.")
+		d.openstackPreparation(ctx)
+	case "agent":
+		fmt.Println("Driver is preparing agent-based installation.")
+		d.agentPreparation(ctx)
 	default:
 		panic(fmt.Errorf("Unsupported cloud selected: %v\n", d.conf.Cloud))
 	}
 
 }
 
-func (d *InstallDriver) awsPreparation() {
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+func (d *InstallDriver) awsPreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 }
 
 // For installing EFS Operator via Operator Hub refer to documentation provided there.
 // Users have to create CredentialsRequest manually and let ccoctl create iam role - although similar this CredentialsRequest has nothing to do with the one created by the operator later.
 // For --identity-provider-arn in ccoctl use existing identity provider that was used to create other roles by the installer.
-func (d *InstallDriver) awsSTSPreparation() {
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	CreateInstallManifests(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "aws")
-	ExtractCcoctl(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	ExecuteCcoctl(d.conf.OutputDir, "aws", "us-east-1", d.conf.ResourceGroup, d.conf.DryRun)
+func (d *InstallDriver) awsSTSPreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	CreateInstallManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "aws")
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	ExecuteCcoctl(ctx, d.conf.OutputDir, "aws", "us-east-1", d.conf.ResourceGroup, ccoctlUserTags(d.conf), d.conf.DryRun)
 }
 
 // Installing cluster on GCP requires a service account which is pruned every ~3 days.
-func (d *InstallDriver) gcpWIFPreparation() {
-	CreateGCPServiceAccount(d.conf.UserName, d.conf.OutputDir)
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	CreateInstallManifests(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "gcp")
-	ExtractCcoctl(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+func (d *InstallDriver) gcpWIFPreparation(ctx context.Context) {
+	CreateGCPServiceAccount(ctx, d.conf.UserName, d.conf.OutputDir)
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	CreateInstallManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "gcp")
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 
 	//NOTE: for some reason the region for ccoctl binary does not match region in install-config.yaml
-	ExecuteCcoctl(d.conf.OutputDir, "gcp", "us", d.conf.ResourceGroup, d.conf.DryRun)
+	ExecuteCcoctl(ctx, d.conf.OutputDir, "gcp", "us", d.conf.ResourceGroup, ccoctlUserTags(d.conf), d.conf.DryRun)
 }
 
 // Installing cluster on GCP requires a service account which is pruned every ~3 days.
-func (d *InstallDriver) gcpPreparation() {
-	CreateGCPServiceAccount(d.conf.UserName, d.conf.OutputDir)
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+func (d *InstallDriver) gcpPreparation(ctx context.Context) {
+	CreateGCPServiceAccount(ctx, d.conf.UserName, d.conf.OutputDir)
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 }
 
-func (d *InstallDriver) vspherePreparation() {
-	checkVCenterReachable()
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+func (d *InstallDriver) vspherePreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 }
 
 // Deprecated
-func (d *InstallDriver) alibabaPreparation() {
+func (d *InstallDriver) alibabaPreparation(ctx context.Context) {
 	// Extract and unarchive tools from image
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 	// Unarchive(d.conf.OutputDir, d.conf.OutputDir)
 
 	// Extract ccoctl tool
-	ExtractCcoctl(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	alibabaCreateCredRequestManifests(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.conf.CloudRegion, "alibabacloud")
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	alibabaCreateCredRequestManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.conf.CloudRegion, "alibabacloud")
 }
 
-func (d *InstallDriver) azurePreparation() {
+func (d *InstallDriver) azurePreparation(ctx context.Context) {
 	// Extract and unarchive tools from image
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
 	// Unarchive(d.conf.OutputDir, d.conf.OutputDir)
 }
 
-func (d *InstallDriver) azureWIPreparation() {
-	ExtractTools(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	CreateInstallManifests(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "azure")
-	ExtractCcoctl(d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image)
-	ExecuteCcoctl(d.conf.OutputDir, "azure", "centralus", d.conf.ResourceGroup, d.conf.DryRun)
+func (d *InstallDriver) azureWIPreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	CreateInstallManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "azure")
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	ExecuteCcoctl(ctx, d.conf.OutputDir, "azure", "centralus", d.conf.ResourceGroup, ccoctlUserTags(d.conf), d.conf.DryRun)
 }
 
-func Run(conf *Config) {
+// IBM Cloud and Power VS only support manual credentials, openshift-install needs the API key in IC_API_KEY for
+// creating manifests already.
+func (d *InstallDriver) ibmCloudPreparation(ctx context.Context) {
+	if !d.conf.DryRun {
+		mustIBMCloudAPIKey()
+	}
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	CreateInstallManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.conf.Cloud)
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	ExecuteCcoctlIBMCloud(ctx, d.conf.OutputDir, d.conf.ResourceGroup, d.resourceGroupName(), d.conf.DryRun)
+}
+
+// resourceGroupName returns the existing resource group of an IBM Cloud or Power VS cluster, service IDs are
+// scoped to it.
+func (d *InstallDriver) resourceGroupName() string {
+	if d.conf.Cloud == "powervs" {
+		return d.conf.PowerVSResourceGroup
+	}
+	return d.conf.IBMCloudResourceGroup
+}
+
+func (d *InstallDriver) nutanixPreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	CreateInstallManifests(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, "nutanix")
+	ExtractCcoctl(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+	ExecuteCcoctlNutanix(ctx, d.conf.OutputDir, writeNutanixCredentials(d.conf), d.conf.DryRun)
+}
+
+// openshift-install reads clouds.yaml from its working directory first, the cloud is selected in install-config.
+func (d *InstallDriver) openstackPreparation(ctx context.Context) {
+	copyOpenstackClouds(ctx, d.conf)
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+}
+
+// The ISO is created by the extracted installer for the architecture of the release, hosts are booted from it.
+func (d *InstallDriver) agentPreparation(ctx context.Context) {
+	ExtractTools(ctx, d.conf.PullSecretFile, d.conf.OutputDir, d.conf.Image, d.releaseArch)
+}
 
-	MustContainerEngineLogin(conf.PullSecretFile, conf.Image, conf.Engine)
+// ccoctlClouds create cloud credentials with ccoctl, their templates set credentialsMode: Manual.
+var ccoctlClouds = []string{"aws-sts", "gcp-wif", "azure-wi", "ibmcloud", "powervs", "nutanix"}
+
+// Run performs the configured action. Steps report failures by panicking, Run recovers those and returns
+// them as an error. If ctx is cancelled the running command is interrupted and the error wraps ErrCancelled.
+func Run(ctx context.Context, conf *Config) (err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			logger(ctx).Printf("Action %v failed: %v", conf.Action, err)
+		}
+	}()
+
+	MustContainerEngineLogin(ctx, conf.PullSecretFile, conf.Image, conf.Engine)
+	ctx = gcpCredentialsEnv(ctx, conf)
 
 	// This will start cluster installation/uninstallation.
 	switch conf.Action {
@@ -123,32 +197,114 @@ func Run(conf *Config) {
 			panic(fmt.Errorf("could not create output dir: %v Error: %v", conf.OutputDir, err))
 		}
 
-		// If installing workload identity cluster on Azure we need to pass sanitized resource group name on two places:
-		// 1. ccoctl --name argument
-		// 2. resourceGroupName in install-config
-		// These have to match and not contain any special characters!!!
-		if conf.Cloud == "azure-wi" {
-			conf.ResourceGroup = SanitizeResourceGroupName(conf.ResourceGroup)
+		ctx, stepLog, stopStepLog := startStepLog(ctx, conf.OutputDir)
+		defer stopStepLog()
+
+		resolveClusterName(ctx, conf)
+		resolveTags(ctx, conf)
+		if err := ValidateArchitecture(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidateTopology(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidateNetworking(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidateMirror(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidateVPC(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidatePlatformConfig(conf); err != nil {
+			panic(err)
+		}
+		if err := ValidateAgent(conf); err != nil {
+			panic(err)
+		}
+		if conf.Networking().Type == NetworkTypeSDN {
+			logger(ctx).Printf("Warning: networkType %s was removed in OpenShift 4.15, the installation fails with newer releases.", NetworkTypeSDN)
+		}
+		hooks := mustLoadHooks(conf)
+
+		// Clouds using ccoctl get its --name from ResourceGroup. On Azure with workload identity the same value
+		// is also the resourceGroupName in install-config, these have to match and not contain any special characters!!!
+		if slices.Contains(ccoctlClouds, conf.Cloud) {
+			conf.ResourceGroup = CcoctlName(conf)
+			logger(ctx).Printf("Using ccoctl name: %v", conf.ResourceGroup)
+			recordCcoctlName(conf.OutputDir, conf.ResourceGroup)
+		}
+
+		if conf.Cloud == "vsphere" {
+			vspherePreflight(ctx, conf)
+		}
+
+		// The mirror mapping is rendered into install-config.yaml, mirror before rendering.
+		if conf.MirrorRegistry != "" {
+			mirrorRelease(ctx, conf)
 		}
 
 		// This will create the install-config.yaml file and save to outputDir.
-		parser := NewTemplateParser(conf)
+		parser := NewTemplateParser(ctx, conf)
 		parser.ParseTemplate()
 
 		// This will extract the tools from the image, unarchive them and save to outputDir.
-		NewInstallDriver(conf).Run()
+		NewInstallDriver(conf).Run(ctx)
+		InjectExtraManifests(ctx, conf.OutputDir, conf.ExtraManifests, conf.ExtraOpenshift, conf.OverwriteManifests)
+		// create cluster consumes the manifests, keep what was installed next to the render in history.
+		snapshotManifests(ctx, conf.OutputDir)
 
 		// Stop here if dry run is requested.
 		if conf.DryRun {
-			log.Printf("Done.")
-			return
+			logger(ctx).Printf("Done.")
+			return nil
+		}
+
+		// Agent-based installations continue when the hosts boot the ISO, see agent wait-for.
+		if conf.Cloud == agentCloud {
+			iso := CreateAgentImage(ctx, conf.OutputDir)
+			fmt.Println(agentNextSteps(iso, conf.OutputDir))
+			return nil
 		}
 
 		// This will create the cluster.
-		InstallCluster(conf.OutputDir, true)
+		if err := InstallCluster(ctx, conf.OutputDir, true); err != nil {
+			failInstallation(ctx, conf, stepLog, err)
+		}
+		recordInstallation(ctx, DefaultExecutor, conf)
+
+		// openshift-install exits once the API is up, operators may still be rolling out or failing.
+		if err := VerifyCluster(ctx, DefaultExecutor, conf.OutputDir, DefaultVerifyTimeout); err != nil {
+			failInstallation(ctx, conf, stepLog, err)
+		}
+
+		CreateMachinePools(ctx, conf.OutputDir, conf.Platform(), conf.PostInstallPools())
+
+		if err := RunHooks(ctx, conf.OutputDir, hooks); err != nil {
+			panic(err)
+		}
 	case "destroy":
-		DestroyCluster(conf.OutputDir, true)
+		if conf.Cloud == agentCloud {
+			return fmt.Errorf("cloud %s clusters run on your own hosts, openshift-install can't destroy them: reinstall the hosts and remove %v", agentCloud, conf.OutputDir)
+		}
+		// openshift-install removes metadata.json with the cluster, get the ccoctl name first.
+		var serviceIDName string
+		if slices.Contains(ibmServiceIDClouds, conf.Cloud) {
+			serviceIDName = installedCcoctlName(ctx, conf)
+		}
+		DestroyCluster(ctx, conf.OutputDir, true)
+		if serviceIDName != "" {
+			DeleteCcoctlIBMCloud(ctx, conf.OutputDir, serviceIDName)
+		}
 	default:
-		log.Fatalf("Unkown action: %v. Exiting.", conf.Action)
+		return fmt.Errorf("unknown action: %v", conf.Action)
 	}
+	return nil
+}
+
+// CanDestroy reports whether openshift-install got far enough in outputDir to be able to destroy the cluster.
+func CanDestroy(outputDir string) bool {
+	_, err := os.Stat(filepath.Join(outputDir, "metadata.json"))
+	return err == nil
 }
@@ -0,0 +1,145 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// inventoryFile records what is installed in an output directory: the release and every upgrade since.
+const inventoryFile = toolDir + "/inventory.yaml"
+
+// Results of upgrades recorded in the inventory and of batch runs.
+const (
+	ResultRunning   = "running"
+	ResultSucceeded = "succeeded"
+	ResultFailed    = "failed"
+	ResultCancelled = "cancelled"
+)
+
+// Inventory is the record of the cluster installed into an output directory. Image and Version follow
+// successful upgrades.
+type Inventory struct {
+	Cloud       string          `yaml:"cloud,omitempty"`
+	ClusterName string          `yaml:"clusterName,omitempty"`
+	Image       string          `yaml:"image,omitempty"`
+	Version     string          `yaml:"version,omitempty"`
+	InstalledAt time.Time       `yaml:"installedAt,omitempty"`
+	Upgrades    []UpgradeRecord `yaml:"upgrades,omitempty"`
+}
+
+// UpgradeRecord is one attempt to upgrade the cluster, successful or not.
+type UpgradeRecord struct {
+	From       string    `yaml:"from"`
+	To         string    `yaml:"to,omitempty"`
+	Image      string    `yaml:"image,omitempty"`
+	Channel    string    `yaml:"channel,omitempty"`
+	Force      bool      `yaml:"force,omitempty"`
+	StartedAt  time.Time `yaml:"startedAt"`
+	FinishedAt time.Time `yaml:"finishedAt"`
+	Result     string    `yaml:"result"`
+	Error      string    `yaml:"error,omitempty"`
+}
+
+// ReadInventory returns the inventory of outputDir, an empty one for clusters installed before it was kept.
+func ReadInventory(outputDir string) (*Inventory, error) {
+	content, err := os.ReadFile(filepath.Join(outputDir, inventoryFile))
+	if os.IsNotExist(err) {
+		return &Inventory{}, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var inv Inventory
+	if err := yaml.Unmarshal(content, &inv); err != nil {
+		return nil, fmt.Errorf("%v is not valid YAML: %v", inventoryFile, err)
+	}
+	return &inv, nil
+}
+
+func writeInventory(outputDir string, inv *Inventory) error {
+	content, err := yaml.Marshal(inv)
+	if err != nil {
+		return err
+	}
+	path := filepath.Join(outputDir, inventoryFile)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return err
+	}
+	return writeFileAtomic(path, content, 0600)
+}
+
+// installedClusterName returns the name of the cluster installed in outputDir from metadata.json, it includes
+// the generated suffix and the user name prefix.
+func installedClusterName(outputDir string) (string, error) {
+	content, err := os.ReadFile(filepath.Join(outputDir, "metadata.json"))
+	if err != nil {
+		return "", fmt.Errorf("could not read metadata.json of the cluster: %v", err)
+	}
+	var metadata struct {
+		ClusterName string `json:"clusterName"`
+	}
+	if err := json.Unmarshal(content, &metadata); err != nil {
+		return "", fmt.Errorf("metadata.json is not valid JSON: %v", err)
+	}
+	return metadata.ClusterName, nil
+}
+
+// recordInstallation starts the inventory of a cluster that openshift-install created. A missing record
+// doesn't break the cluster, failures are only logged.
+func recordInstallation(ctx context.Context, e Executor, conf *Config) {
+	inv := &Inventory{Cloud: conf.Cloud, Image: conf.Image, InstalledAt: time.Now().UTC()}
+	if name, err := installedClusterName(conf.OutputDir); err == nil {
+		inv.ClusterName = name
+	}
+	inv.Version = installedVersion(ctx, e, conf.OutputDir)
+	if err := writeInventory(conf.OutputDir, inv); err != nil {
+		logger(ctx).Printf("Warning: could not write inventory: %v", err)
+	}
+}
+
+// installedVersion returns the version the cluster in outputDir completed the rollout of, as its ClusterVersion
+// reports it. The version of the installer is used if the cluster can't tell.
+func installedVersion(ctx context.Context, e Executor, outputDir string) string {
+	var cv clusterVersion
+	err := ocGet(ctx, e, outputDir, "clusterversion/version", &cv)
+	if err == nil {
+		if version := completedVersion(cv); version != "" {
+			return version
+		}
+		err = fmt.Errorf("no release completed the rollout")
+	}
+	logger(ctx).Printf("Warning: could not get version of the cluster, using the version of the installer: %v", err)
+	installLog, err := os.ReadFile(InstallLogPath(outputDir))
+	if err != nil {
+		logger(ctx).Printf("Warning: could not read install log: %v", err)
+		return ""
+	}
+	return installerVersion(installLog)
+}
+
+// recordUpgrade appends an upgrade to the inventory of outputDir and updates the installed release if it
+// succeeded.
+func recordUpgrade(outputDir string, record UpgradeRecord) error {
+	inv, err := ReadInventory(outputDir)
+	if err != nil {
+		return err
+	}
+	if inv.ClusterName == "" {
+		inv.ClusterName, _ = installedClusterName(outputDir)
+	}
+	if inv.Version == "" {
+		inv.Version = record.From
+	}
+	if record.Result == ResultSucceeded {
+		inv.Version = record.To
+		inv.Image = record.Image
+	}
+	inv.Upgrades = append(inv.Upgrades, record)
+	return writeInventory(outputDir, inv)
+}
@@ -0,0 +1,343 @@
+package utils
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"regexp"
+	"slices"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severities of lint findings, only errors make the configuration invalid.
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintFinding is a problem found in the configuration or in install-config.yaml rendered from it.
+type LintFinding struct {
+	Check    string `json:"check"`
+	Severity string `json:"severity"`
+	Message  string `json:"message"`
+}
+
+// lintPlaceholder replaces files lint can't read, so the template can still be rendered and checked.
+const lintPlaceholder = "lint-placeholder"
+
+// azureRegions are the regions of the Azure public and US government clouds. Their names are words run
+// together, there is no pattern that tells them from a typo.
+var azureRegions = []string{
+	"australiacentral", "australiacentral2", "australiaeast", "australiasoutheast", "brazilsouth", "brazilsoutheast",
+	"canadacentral", "canadaeast", "centralindia", "centralus", "eastasia", "eastus", "eastus2", "francecentral",
+	"francesouth", "germanynorth", "germanywestcentral", "israelcentral", "italynorth", "japaneast", "japanwest",
+	"jioindiacentral", "jioindiawest", "koreacentral", "koreasouth", "mexicocentral", "newzealandnorth",
+	"northcentralus", "northeurope", "norwayeast", "norwaywest", "polandcentral", "qatarcentral", "southafricanorth",
+	"southafricawest", "southcentralus", "southeastasia", "southindia", "spaincentral", "swedencentral",
+	"switzerlandnorth", "switzerlandwest", "uaecentral", "uaenorth", "uksouth", "ukwest", "usgovarizona",
+	"usgovtexas", "usgovvirginia", "westcentralus", "westeurope", "westindia", "westus", "westus2", "westus3",
+}
+
+// regionPatterns match region names of a platform. They catch typos and regions of a different cloud,
+// not regions that don't exist yet, except on Azure where only the listed regions match.
+var regionPatterns = map[string]*regexp.Regexp{
+	"aws":      regexp.MustCompile(`^(us|eu|ap|sa|ca|me|af|il|mx)(-gov|-iso[a-z]?)?-(north|south|east|west|central|northeast|southeast|northwest|southwest)-\d$`),
+	"gcp":      regexp.MustCompile(`^(us|europe|asia|australia|northamerica|southamerica|me|africa)-(north|south|east|west|central|northeast|southeast|northwest|southwest)\d+$`),
+	"azure":    regexp.MustCompile(`^(` + strings.Join(azureRegions, "|") + `)$`),
+	"alibaba":  regexp.MustCompile(`^(cn|ap|eu|us|me)-[a-z]+(-\d)?$`),
+	"ibmcloud": regexp.MustCompile(`^(us|eu|jp|au|ca|br)-[a-z]{2,5}$`),
+	"powervs":  regexp.MustCompile(`^([a-z]{3}|(us|eu)-[a-z]{2,5})$`),
+}
+
+// sshKeyPrefixes are types of public keys openshift-install accepts.
+var sshKeyPrefixes = []string{"ssh-rsa ", "ssh-ed25519 ", "ecdsa-sha2-", "sk-ssh-ed25519@openssh.com ", "sk-ecdsa-sha2-"}
+
+// renderedInstallConfig holds the parts of install-config.yaml lint checks.
+type renderedInstallConfig struct {
+	CredentialsMode string `yaml:"credentialsMode"`
+	Platform        map[string]struct {
+		Region string `yaml:"region"`
+	} `yaml:"platform"`
+}
+
+type linter struct {
+	conf     Config
+	findings []LintFinding
+}
+
+func (l *linter) add(check, severity, format string, args ...interface{}) {
+	l.findings = append(l.findings, LintFinding{Check: check, Severity: severity, Message: fmt.Sprintf(format, args...)})
+}
+
+// Lint renders install-config.yaml for conf and reports problems openshift-install or the cloud would fail on
+// later. It only reads local files: no cloud API is called and no image is pulled.
+func Lint(conf Config) []LintFinding {
+	findings, _ := LintRender(conf)
+	return findings
+}
+
+// LintRender is Lint that also returns the rendered install-config.yaml with secrets redacted, nil if it can't
+// be rendered, followed by agent-config.yaml for agent-based installations. It works for every cloud without
+// credentials of the cloud.
+func LintRender(conf Config) ([]LintFinding, []byte) {
+	l := &linter{conf: conf}
+	if _, ok := cloudTemplatesMap[conf.Cloud]; !ok {
+		l.add("cloud", LintError, "unknown cloud %q, use one of: %s", conf.Cloud, strings.Join(GetCloudKeys(), ", "))
+		return l.findings, nil
+	}
+	if l.conf.ClusterName == AutoClusterName && conf.Cloud != "vsphere" {
+		l.conf.ClusterName = GenerateClusterName(conf.Cloud)
+	}
+	l.conf.SshPublicKey = l.lintSSHKey()
+	l.conf.PullSecret = l.lintPullSecret()
+	l.lintName()
+	if conf.Cloud == "vsphere" {
+		for _, p := range vsphereConfigProblems(&l.conf) {
+			l.add("vsphere", LintError, "%s", p)
+		}
+	}
+	l.lintTags()
+	if err := ValidateArchitecture(&l.conf); err != nil {
+		l.add("arch", LintError, "%v", err)
+	}
+	if err := ValidateTopology(&l.conf); err != nil {
+		l.add("topology", LintError, "%v", err)
+	}
+	l.lintNetworking()
+	l.lintMirror()
+	for _, p := range vpcProblems(&l.conf) {
+		l.add("vpc", LintError, "%s", p)
+	}
+	l.lintPlatform()
+	agentErrors := agentProblems(&l.conf)
+	for _, p := range agentErrors {
+		l.add("agent", LintError, "%s", p)
+	}
+	if slices.Contains(ccoctlClouds, conf.Cloud) {
+		l.conf.ResourceGroup = CcoctlName(&l.conf)
+	}
+
+	rendered, err := renderInstallConfig(l.conf)
+	if err != nil {
+		l.add("render", LintError, "could not render install-config.yaml: %v", err)
+		return l.findings, nil
+	}
+	redacted, _ := renderInstallConfig(l.conf.Redacted())
+	// Hosts that can't be parsed would render a broken agent-config.yaml, they're reported already.
+	if conf.Cloud == agentCloud && redacted != nil && len(agentErrors) == 0 {
+		if agentConfig, err := renderAgentConfig(l.conf); err != nil {
+			l.add("render", LintError, "could not render %v: %v", agentConfigFile, err)
+		} else {
+			redacted = append(append(redacted, "\n---\n"...), agentConfig...)
+		}
+	}
+	var ic renderedInstallConfig
+	if err := yaml.Unmarshal(rendered, &ic); err != nil {
+		l.add("render", LintError, "rendered install-config.yaml is not valid YAML: %v", err)
+		return l.findings, redacted
+	}
+	l.lintCredentialsMode(ic)
+	l.lintRegion(ic)
+	return l.findings, redacted
+}
+
+// renderInstallConfig renders the template of conf.Cloud into memory.
+func renderInstallConfig(conf Config) ([]byte, error) {
+	name := cloudTemplatesMap[conf.Cloud]
+	tmp, err := parseCloudTemplate(name)
+	if err != nil {
+		return nil, err
+	}
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, conf); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func (l *linter) readFile(check, key, path string) (string, bool) {
+	if path == "" {
+		l.add(check, LintError, "%s is not set", key)
+		return "", false
+	}
+	content, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		l.add(check, LintError, "%s can not be read: %v", key, err)
+		return "", false
+	}
+	return string(content), true
+}
+
+func (l *linter) lintSSHKey() string {
+	key, ok := l.readFile("ssh-key", "sshPublicKeyFile", l.conf.SshPublicKeyFile)
+	if !ok {
+		return lintPlaceholder
+	}
+	key = strings.TrimSpace(key)
+	switch {
+	case strings.Contains(key, "PRIVATE KEY"):
+		l.add("ssh-key", LintError, "sshPublicKeyFile %v contains a private key, use the .pub file", l.conf.SshPublicKeyFile)
+	case !slices.ContainsFunc(sshKeyPrefixes, func(p string) bool { return strings.HasPrefix(key, p) }):
+		l.add("ssh-key", LintError, "sshPublicKeyFile %v does not contain an OpenSSH public key", l.conf.SshPublicKeyFile)
+	}
+	return key
+}
+
+func (l *linter) lintPullSecret() string {
+	content, ok := l.readFile("pull-secret", "pullSecretFile", l.conf.PullSecretFile)
+	if !ok {
+		return lintPlaceholder
+	}
+	var parsed struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(content), &parsed); err != nil {
+		l.add("pull-secret", LintError, "pullSecretFile %v is not valid JSON: %v", l.conf.PullSecretFile, err)
+		return lintPlaceholder
+	}
+	if len(parsed.Auths) == 0 {
+		l.add("pull-secret", LintError, "pullSecretFile %v has no auths", l.conf.PullSecretFile)
+	}
+	registries := make([]string, 0, len(parsed.Auths))
+	for registry := range parsed.Auths {
+		registries = append(registries, registry)
+	}
+	slices.Sort(registries)
+	for _, registry := range registries {
+		auth := parsed.Auths[registry].Auth
+		if auth == "" {
+			l.add("pull-secret", LintError, "auth of %v is empty, release images can't be pulled with it", registry)
+			continue
+		}
+		decoded, err := base64.StdEncoding.DecodeString(auth)
+		if err != nil || !strings.Contains(string(decoded), ":") {
+			l.add("pull-secret", LintError, "auth of %v is not base64 encoded <user>:<password>", registry)
+		}
+	}
+	var compact bytes.Buffer
+	if err := json.Compact(&compact, []byte(content)); err != nil {
+		return lintPlaceholder
+	}
+	return compact.String()
+}
+
+func (l *linter) lintName() {
+	warnings, err := ValidateClusterName(&l.conf)
+	if err != nil {
+		l.add("name", LintError, "%v", err)
+	}
+	for _, w := range warnings {
+		l.add("name", LintWarning, "%s", w)
+	}
+	if slices.Contains(ccoctlClouds, l.conf.Cloud) && l.conf.ResourceGroup != "" {
+		if name := CcoctlName(&l.conf); name != l.conf.ResourceGroup {
+			l.add("name", LintWarning, "resourceGroup %q is not valid for ccoctl, %q is used instead", l.conf.ResourceGroup, name)
+		}
+	}
+}
+
+func (l *linter) lintTags() {
+	if _, err := parseTags(l.conf.Tags); err != nil {
+		l.add("tags", LintError, "%v", err)
+	}
+	if l.conf.ExpirationDate == "" && l.conf.ExpiresIn != "" {
+		if _, err := time.ParseDuration(l.conf.ExpiresIn); err != nil {
+			l.add("tags", LintError, "invalid expiresIn %q: %v", l.conf.ExpiresIn, err)
+		}
+	}
+}
+
+func (l *linter) lintNetworking() {
+	for _, p := range networkingProblems(&l.conf) {
+		l.add("networking", LintError, "%s", p)
+	}
+	n := l.conf.Networking()
+	if n.Type == NetworkTypeSDN {
+		l.add("networking", LintWarning, "networkType %s was removed in OpenShift 4.15, newer releases require %s", NetworkTypeSDN, NetworkTypeOVN)
+	}
+	if n.Publish == PublishInternal {
+		l.add("networking", LintWarning, "publish %s makes the API private, openshift-install has to run from a host that can reach the cluster network", PublishInternal)
+	}
+}
+
+func (l *linter) lintMirror() {
+	for _, p := range mirrorProblems(&l.conf) {
+		l.add("mirror", LintError, "%s", p)
+	}
+	if l.conf.MirrorRegistry == "" {
+		return
+	}
+	if l.conf.ImageSourcesFile != "" {
+		l.add("mirror", LintWarning, "imageSourcesFile is replaced by the mapping of mirroring to mirrorRegistry")
+	}
+	if l.conf.AdditionalTrustBundleFile == "" {
+		l.add("mirror", LintWarning, "additionalTrustBundleFile is not set, nodes pull from the mirror only if its certificate is publicly trusted")
+	}
+}
+
+func (l *linter) lintPlatform() {
+	for _, p := range platformConfigProblems(&l.conf) {
+		l.add("platform", LintError, "%s", p)
+	}
+	if l.conf.Platform() == "nutanix" {
+		if password, err := l.conf.nutanixPassword(); err == nil {
+			l.conf.NutanixPassword = password
+		}
+	}
+	if slices.Contains(ibmServiceIDClouds, l.conf.Cloud) && os.Getenv(ibmCloudAPIKeyEnv) == "" {
+		l.add("platform", LintWarning, "%s is not set, openshift-install and ccoctl need an IBM Cloud API key", ibmCloudAPIKeyEnv)
+	}
+}
+
+func (l *linter) lintCredentialsMode(ic renderedInstallConfig) {
+	usesCcoctl := slices.Contains(ccoctlClouds, l.conf.Cloud)
+	switch {
+	case ic.CredentialsMode == "Manual" && !usesCcoctl:
+		l.add("credentials-mode", LintError, "credentialsMode: Manual requires credentials created by ccoctl, use one of: %s",
+			strings.Join(ccoctlClouds, ", "))
+	case ic.CredentialsMode != "Manual" && usesCcoctl:
+		l.add("credentials-mode", LintError, "cloud %v creates credentials with ccoctl, the template must set credentialsMode: Manual", l.conf.Cloud)
+	}
+}
+
+func (l *linter) lintRegion(ic renderedInstallConfig) {
+	platform := platformOf(l.conf.Cloud)
+	pattern, ok := regionPatterns[platform]
+	if !ok {
+		return
+	}
+	region := ic.Platform[platform].Region
+	switch {
+	case region == "":
+		l.add("region", LintError, "install-config.yaml does not set platform.%s.region", platform)
+	case !pattern.MatchString(region):
+		l.add("region", LintError, "%q is not a valid %s region", region, platform)
+	}
+	if l.conf.CloudRegion == "" || l.conf.CloudRegion == region {
+		return
+	}
+	if !pattern.MatchString(l.conf.CloudRegion) {
+		l.add("region", LintWarning, "cloudRegion %q is not a valid %s region", l.conf.CloudRegion, platform)
+	} else {
+		l.add("region", LintWarning, "cloudRegion %q is not used by the %s template, the cluster is installed in %q",
+			l.conf.CloudRegion, l.conf.Cloud, region)
+	}
+}
+
+// LintErrors returns the number of findings with error severity.
+func LintErrors(findings []LintFinding) int {
+	n := 0
+	for _, f := range findings {
+		if f.Severity == LintError {
+			n++
+		}
+	}
+	return n
+}
@@ -0,0 +1,44 @@
+package utils
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestLintRegion(t *testing.T) {
+	tests := []struct {
+		name        string
+		cloud       string
+		cloudRegion string
+		want        []string
+	}{
+		{name: "aws region", cloud: "aws", cloudRegion: "us-east-1"},
+		{name: "aws typo", cloud: "aws", cloudRegion: "us-esat-1", want: []string{`cloudRegion "us-esat-1" is not a valid aws region`}},
+		{name: "gcp region of aws", cloud: "gcp", cloudRegion: "us-east-1", want: []string{`cloudRegion "us-east-1" is not a valid gcp region`}},
+		{name: "azure region", cloud: "azure", cloudRegion: "centralus"},
+		{
+			name:        "azure region the template doesn't use",
+			cloud:       "azure",
+			cloudRegion: "eastus2",
+			want:        []string{`cloudRegion "eastus2" is not used by the azure template, the cluster is installed in "centralus"`},
+		},
+		{name: "azure typo", cloud: "azure", cloudRegion: "eastu2", want: []string{`cloudRegion "eastu2" is not a valid azure region`}},
+		{name: "azure region of aws", cloud: "azure-wi", cloudRegion: "useast", want: []string{`cloudRegion "useast" is not a valid azure region`}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := lintTestConfig(t, tt.cloud)
+			conf.CloudRegion = tt.cloudRegion
+			findings, _ := LintRender(conf)
+			var got []string
+			for _, f := range findings {
+				if f.Check == "region" {
+					got = append(got, f.Message)
+				}
+			}
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("unexpected region findings %q", got)
+			}
+		})
+	}
+}
@@ -0,0 +1,218 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	machineAPINamespace     = "openshift-machine-api"
+	machineSetLabel         = "machine.openshift.io/cluster-api-machineset"
+	machineRoleLabel        = "machine.openshift.io/cluster-api-machine-role"
+	machineTypeLabel        = "machine.openshift.io/cluster-api-machine-type"
+	machinePoolTimeout      = 30 * time.Minute
+	machinePoolPollInterval = 30 * time.Second
+	// machineSetsDir keeps MachineSets created for extra pools, so they can be reviewed and reapplied.
+	machineSetsDir = "machinesets"
+)
+
+type machineSetList struct {
+	Items []map[string]any `json:"items"`
+}
+
+// nestedMap returns the map at path in obj, creating missing maps on the way.
+func nestedMap(obj map[string]any, path ...string) map[string]any {
+	for _, key := range path {
+		next, ok := obj[key].(map[string]any)
+		if !ok {
+			next = map[string]any{}
+			obj[key] = next
+		}
+		obj = next
+	}
+	return obj
+}
+
+// machineSetForPool copies a worker MachineSet for the pool, the copy gets the pool's node role label,
+// replicas and instance type and volume settings.
+func machineSetForPool(worker map[string]any, pool MachinePool, platform string, replicas int) (map[string]any, error) {
+	// Round trip through JSON for a deep copy.
+	content, err := json.Marshal(worker)
+	if err != nil {
+		return nil, err
+	}
+	var ms map[string]any
+	if err := json.Unmarshal(content, &ms); err != nil {
+		return nil, err
+	}
+
+	metadata := nestedMap(ms, "metadata")
+	workerName, _ := metadata["name"].(string)
+	if !strings.Contains(workerName, "-worker-") {
+		return nil, fmt.Errorf("unexpected name of worker MachineSet %q", workerName)
+	}
+	name := strings.Replace(workerName, "-worker-", "-"+pool.Name+"-", 1)
+	ms = map[string]any{
+		"apiVersion": ms["apiVersion"],
+		"kind":       ms["kind"],
+		"metadata":   map[string]any{"name": name, "namespace": machineAPINamespace, "labels": metadata["labels"]},
+		"spec":       ms["spec"],
+	}
+	spec := nestedMap(ms, "spec")
+	spec["replicas"] = replicas
+	nestedMap(spec, "selector", "matchLabels")[machineSetLabel] = name
+	labels := nestedMap(spec, "template", "metadata", "labels")
+	labels[machineSetLabel] = name
+	labels[machineRoleLabel] = pool.Name
+	labels[machineTypeLabel] = pool.Name
+	nestedMap(spec, "template", "spec", "metadata", "labels")["node-role.kubernetes.io/"+pool.Name] = ""
+
+	provider := nestedMap(spec, "template", "spec", "providerSpec", "value")
+	switch platform {
+	case "aws":
+		if pool.Type != "" {
+			provider["instanceType"] = pool.Type
+		}
+		if devices, ok := provider["blockDevices"].([]any); ok && len(devices) > 0 {
+			if root, ok := devices[0].(map[string]any); ok {
+				ebs := nestedMap(root, "ebs")
+				if pool.VolumeSize > 0 {
+					ebs["volumeSize"] = pool.VolumeSize
+				}
+				if pool.VolumeType != "" {
+					ebs["volumeType"] = pool.VolumeType
+				}
+			}
+		}
+	case "gcp":
+		if pool.Type != "" {
+			provider["machineType"] = pool.Type
+		}
+		if disks, ok := provider["disks"].([]any); ok && len(disks) > 0 {
+			if root, ok := disks[0].(map[string]any); ok {
+				if pool.VolumeSize > 0 {
+					root["sizeGb"] = pool.VolumeSize
+				}
+				if pool.VolumeType != "" {
+					root["type"] = pool.VolumeType
+				}
+			}
+		}
+	case "azure":
+		if pool.Type != "" {
+			provider["vmSize"] = pool.Type
+		}
+		if pool.VolumeSize > 0 {
+			nestedMap(provider, "osDisk")["diskSizeGB"] = pool.VolumeSize
+		}
+		if pool.VolumeType != "" {
+			nestedMap(provider, "osDisk", "managedDisk")["storageAccountType"] = pool.VolumeType
+		}
+	case "vsphere":
+		if pool.Type != "" {
+			cpus, memory, err := vsphereMachineSize(pool.Type)
+			if err != nil {
+				return nil, err
+			}
+			provider["numCPUs"] = cpus
+			provider["numCoresPerSocket"] = cpus
+			provider["memoryMiB"] = memory * 1024
+		}
+		if pool.VolumeSize > 0 {
+			provider["diskGiB"] = pool.VolumeSize
+		}
+	default:
+		return nil, fmt.Errorf("machine pools are not supported on %v", platform)
+	}
+	return ms, nil
+}
+
+// CreateMachinePools creates MachineSets of extra pools by copying the worker MachineSets of the cluster,
+// replicas are spread over the zones of the workers. It waits until all machines of the pools are ready.
+func CreateMachinePools(ctx context.Context, outputDir, platform string, pools []MachinePool) {
+	if len(pools) == 0 {
+		return
+	}
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		panic(err)
+	}
+	var workers machineSetList
+	if err := ocGetNamespaced(ctx, DefaultExecutor, dir, machineAPINamespace, "machinesets.machine.openshift.io", &workers); err != nil {
+		panic(fmt.Errorf("could not list MachineSets: %v", err))
+	}
+	var workerSets []map[string]any
+	for _, ms := range workers.Items {
+		labels := nestedMap(ms, "spec", "template", "metadata", "labels")
+		if labels[machineRoleLabel] == "worker" {
+			workerSets = append(workerSets, ms)
+		}
+	}
+	if len(workerSets) == 0 {
+		panic(fmt.Errorf("no worker MachineSet found to create machine pools from"))
+	}
+	if err := os.MkdirAll(filepath.Join(dir, machineSetsDir), 0755); err != nil {
+		panic(err)
+	}
+
+	want := map[string]int{}
+	for _, pool := range pools {
+		for i, worker := range workerSets {
+			// Spread replicas over zones, the first zones get the remainder.
+			replicas := pool.Replicas / len(workerSets)
+			if i < pool.Replicas%len(workerSets) {
+				replicas++
+			}
+			ms, err := machineSetForPool(worker, pool, platform, replicas)
+			if err != nil {
+				panic(fmt.Errorf("machine pool %v: %v", pool.Name, err))
+			}
+			name := nestedMap(ms, "metadata")["name"].(string)
+			content, err := json.MarshalIndent(ms, "", "  ")
+			if err != nil {
+				panic(err)
+			}
+			path := filepath.Join(dir, machineSetsDir, name+".json")
+			if err := os.WriteFile(path, content, 0644); err != nil {
+				panic(err)
+			}
+			logger(ctx).Printf("Creating MachineSet %v with %d replicas for machine pool %v", name, replicas, pool.Name)
+			runCommand(ctx, filepath.Join(dir, "oc"), dir, "--kubeconfig", KubeconfigPath(dir), "apply", "-f", path)
+			want[name] = replicas
+		}
+	}
+	waitForMachineSets(ctx, dir, want)
+}
+
+// waitForMachineSets polls until every MachineSet has the wanted number of ready replicas.
+func waitForMachineSets(ctx context.Context, dir string, want map[string]int) {
+	deadline := time.Now().Add(machinePoolTimeout)
+	for {
+		var pending []string
+		for name, replicas := range want {
+			var ms struct {
+				Status struct {
+					ReadyReplicas int `json:"readyReplicas"`
+				} `json:"status"`
+			}
+			err := ocGetNamespaced(ctx, DefaultExecutor, dir, machineAPINamespace, "machinesets.machine.openshift.io/"+name, &ms)
+			if err != nil || ms.Status.ReadyReplicas < replicas {
+				pending = append(pending, fmt.Sprintf("%s %d/%d", name, ms.Status.ReadyReplicas, replicas))
+			}
+		}
+		if len(pending) == 0 {
+			logger(ctx).Printf("Machine pools are ready.")
+			return
+		}
+		if time.Now().After(deadline) {
+			panic(fmt.Errorf("machine pools not ready after %v: %s", machinePoolTimeout, strings.Join(pending, ", ")))
+		}
+		logger(ctx).Printf("Waiting for machine pools: %s", strings.Join(pending, ", "))
+		sleepContext(ctx, machinePoolPollInterval)
+	}
+}
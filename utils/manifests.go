@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// manifestResource identifies a resource defined in a manifest file.
+type manifestResource struct {
+	Kind     string `yaml:"kind"`
+	Metadata struct {
+		Name      string `yaml:"name"`
+		Namespace string `yaml:"namespace"`
+	} `yaml:"metadata"`
+}
+
+func (r manifestResource) String() string {
+	if r.Metadata.Namespace != "" {
+		return fmt.Sprintf("%s %s/%s", r.Kind, r.Metadata.Namespace, r.Metadata.Name)
+	}
+	return fmt.Sprintf("%s %s", r.Kind, r.Metadata.Name)
+}
+
+// manifestResources returns resources defined in a YAML or JSON file, files that can't be parsed define none.
+func manifestResources(ctx context.Context, path string) []string {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil
+	}
+	var resources []string
+	decoder := yaml.NewDecoder(bytes.NewReader(content))
+	for {
+		var r manifestResource
+		if err := decoder.Decode(&r); err != nil {
+			if !errors.Is(err, io.EOF) {
+				logger(ctx).Printf("Warning: could not parse %v, conflicts with its resources are not detected: %v", path, err)
+			}
+			return resources
+		}
+		if r.Kind != "" && r.Metadata.Name != "" {
+			resources = append(resources, r.String())
+		}
+	}
+}
+
+// manifestCopy is a file to copy into a manifests dir of the installer.
+type manifestCopy struct {
+	src, dst string
+	// replaces are generated files that define the same resources as src.
+	replaces []string
+}
+
+// planManifestCopies lists files of srcDir to copy into dstDir and conflicts with files already in dstDir: a file
+// with the same name and different content, or a file defining the same resource.
+func planManifestCopies(ctx context.Context, srcDir, dstDir string) (copies []manifestCopy, conflicts []string) {
+	entries, err := os.ReadDir(srcDir)
+	if err != nil {
+		panic(fmt.Errorf("could not read extra manifests: %v", err))
+	}
+
+	generated := map[string][]string{}
+	existing, _ := os.ReadDir(dstDir)
+	for _, e := range existing {
+		if e.Type().IsRegular() {
+			path := filepath.Join(dstDir, e.Name())
+			for _, r := range manifestResources(ctx, path) {
+				generated[r] = append(generated[r], path)
+			}
+		}
+	}
+
+	for _, e := range entries {
+		src := filepath.Join(srcDir, e.Name())
+		// The installer reads only files directly in manifests/ and openshift/.
+		if e.IsDir() {
+			logger(ctx).Printf("Warning: ignoring directory %v, only files directly in %v are used", src, srcDir)
+			continue
+		}
+		c := manifestCopy{src: src, dst: filepath.Join(dstDir, e.Name())}
+		if current, err := os.ReadFile(c.dst); err == nil {
+			if wanted, _ := os.ReadFile(src); bytes.Equal(current, wanted) {
+				continue
+			}
+			conflicts = append(conflicts, fmt.Sprintf("%v would replace generated file %v", src, c.dst))
+		}
+		for _, r := range manifestResources(ctx, src) {
+			for _, path := range generated[r] {
+				if path == c.dst {
+					continue
+				}
+				conflicts = append(conflicts, fmt.Sprintf("%v defines %v, already defined by generated file %v", src, r, path))
+				c.replaces = append(c.replaces, path)
+			}
+		}
+		copies = append(copies, c)
+	}
+	return copies, conflicts
+}
+
+func copyFile(src, dst string) error {
+	content, err := os.ReadFile(src)
+	if err != nil {
+		return err
+	}
+	return os.WriteFile(dst, content, 0644)
+}
+
+// CreateManifests runs the manifests stage of openshift-install unless it already ran in outputDir.
+func CreateManifests(ctx context.Context, outputDir string) {
+	if _, err := os.Stat(filepath.Join(outputDir, "manifests")); err == nil {
+		return
+	}
+	logger(ctx).Printf("Creating installation manifests.")
+	_, _, _ = runCommand(ctx, toolPath(outputDir, "openshift-install"), outputDir, "create", "manifests", "--log-level", "debug")
+}
+
+// InjectExtraManifests copies user manifests into manifests/ and openshift/ of the installation. Conflicts with
+// generated files fail the installation unless overwrite is set, in which case user files win.
+func InjectExtraManifests(ctx context.Context, outputDir, extraManifests, extraOpenshift string, overwrite bool) {
+	if extraManifests == "" && extraOpenshift == "" {
+		return
+	}
+	CreateManifests(ctx, outputDir)
+
+	var copies []manifestCopy
+	var conflicts []string
+	for _, extra := range []struct{ src, dst string }{
+		{extraManifests, "manifests"},
+		{extraOpenshift, "openshift"},
+	} {
+		if extra.src == "" {
+			continue
+		}
+		c, conflict := planManifestCopies(ctx, os.ExpandEnv(extra.src), filepath.Join(outputDir, extra.dst))
+		copies = append(copies, c...)
+		conflicts = append(conflicts, conflict...)
+	}
+
+	if len(conflicts) > 0 {
+		sort.Strings(conflicts)
+		if !overwrite {
+			panic(fmt.Errorf("extra manifests conflict with generated files, use --overwrite-manifests to replace them:\n  %s",
+				strings.Join(conflicts, "\n  ")))
+		}
+		for _, c := range conflicts {
+			logger(ctx).Printf("Warning: overwriting: %v", c)
+		}
+	}
+
+	if err := os.MkdirAll(filepath.Join(outputDir, "openshift"), 0755); err != nil {
+		panic(err)
+	}
+	for _, c := range copies {
+		for _, path := range c.replaces {
+			if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+				panic(fmt.Errorf("could not remove %v: %v", path, err))
+			}
+		}
+		logger(ctx).Printf("Adding manifest %v", c.dst)
+		if err := copyFile(c.src, c.dst); err != nil {
+			panic(fmt.Errorf("could not copy %v: %v", c.src, err))
+		}
+	}
+}
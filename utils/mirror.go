@@ -0,0 +1,250 @@
+package utils
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// mirrorDir keeps the merged credentials and the mirror mapping of a mirrored installation.
+	mirrorDir = "mirror"
+	// imageSourcesFileName is written by mirrorRelease, it's the install-config section oc prints after mirroring.
+	imageSourcesFileName = "image-sources.yaml"
+	// imageDigestSourcesVersion is the first minor release of 4.x reading imageDigestSources, older ones read
+	// imageContentSources.
+	imageDigestSourcesVersion = 14
+)
+
+// mirrorRepositoryPattern matches <host>[:<port>]/<repository> without a tag or digest.
+var mirrorRepositoryPattern = regexp.MustCompile(`^[a-zA-Z0-9.-]+(:[0-9]+)?(/[a-z0-9._-]+)+$`)
+
+// ImageSource maps a source repository of release images to its mirrors.
+type ImageSource struct {
+	Source  string   `yaml:"source"`
+	Mirrors []string `yaml:"mirrors"`
+}
+
+// ImageSources is the part of install-config.yaml redirecting image pulls to mirrors, only one of the lists is set.
+type ImageSources struct {
+	ImageDigestSources  []ImageSource `yaml:"imageDigestSources,omitempty"`
+	ImageContentSources []ImageSource `yaml:"imageContentSources,omitempty"`
+}
+
+func readImageSources(path string) (ImageSources, error) {
+	var sources ImageSources
+	content, err := os.ReadFile(os.ExpandEnv(path))
+	if err != nil {
+		return sources, err
+	}
+	if err := yaml.Unmarshal(content, &sources); err != nil {
+		return sources, fmt.Errorf("%v is not valid YAML: %v", path, err)
+	}
+	if len(sources.ImageDigestSources)+len(sources.ImageContentSources) == 0 {
+		return sources, fmt.Errorf("%v has no imageDigestSources or imageContentSources", path)
+	}
+	return sources, nil
+}
+
+// ImageSources returns the mirror mapping of imageSourcesFile, nil if it's not set or mirroring to mirrorRegistry
+// didn't write it yet. It panics if the file can't be used, install-config.yaml without the mapping would make a
+// disconnected cluster pull from the internet.
+func (c Config) ImageSources() *ImageSources {
+	if c.ImageSourcesFile == "" {
+		return nil
+	}
+	sources, err := readImageSources(c.ImageSourcesFile)
+	if os.IsNotExist(err) && c.MirrorRegistry != "" {
+		return nil
+	}
+	if err != nil {
+		panic(fmt.Errorf("imageSourcesFile: %v", err))
+	}
+	return &sources
+}
+
+// ValidateMirror checks the mirror registry, its credentials and the trust bundle without contacting the registry.
+func ValidateMirror(conf *Config) error {
+	if problems := mirrorProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid mirror configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func mirrorProblems(conf *Config) []string {
+	var problems []string
+	if conf.MirrorRegistry != "" && !mirrorRepositoryPattern.MatchString(conf.MirrorRegistry) {
+		problems = append(problems, fmt.Sprintf("mirrorRegistry %q must be <host>[:<port>]/<repository> without a tag", conf.MirrorRegistry))
+	}
+	if conf.MirrorAuthFile != "" {
+		if content, err := os.ReadFile(os.ExpandEnv(conf.MirrorAuthFile)); err != nil {
+			problems = append(problems, fmt.Sprintf("mirrorAuthFile can not be read: %v", err))
+		} else if _, err := mergePullSecrets("{}", string(content)); err != nil {
+			problems = append(problems, fmt.Sprintf("mirrorAuthFile %v: %v", conf.MirrorAuthFile, err))
+		}
+	}
+	if conf.AdditionalTrustBundleFile != "" {
+		if content, err := os.ReadFile(os.ExpandEnv(conf.AdditionalTrustBundleFile)); err != nil {
+			problems = append(problems, fmt.Sprintf("additionalTrustBundleFile can not be read: %v", err))
+		} else if !strings.Contains(string(content), "-----BEGIN CERTIFICATE-----") {
+			problems = append(problems, fmt.Sprintf("additionalTrustBundleFile %v does not contain PEM certificates", conf.AdditionalTrustBundleFile))
+		}
+	}
+	// Without a mirror registry the file has to exist, otherwise it's written by mirroring.
+	if conf.ImageSourcesFile != "" && conf.MirrorRegistry == "" {
+		if _, err := readImageSources(conf.ImageSourcesFile); err != nil {
+			problems = append(problems, fmt.Sprintf("imageSourcesFile: %v", err))
+		}
+	}
+	return problems
+}
+
+// mergePullSecrets adds the registry credentials of extra to base, extra wins for registries in both.
+func mergePullSecrets(base, extra string) (string, error) {
+	var merged map[string]any
+	if err := json.Unmarshal([]byte(base), &merged); err != nil {
+		return "", fmt.Errorf("pull secret is not valid JSON: %v", err)
+	}
+	var add struct {
+		Auths map[string]any `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(extra), &add); err != nil {
+		return "", fmt.Errorf("not valid JSON: %v", err)
+	}
+	if len(add.Auths) == 0 {
+		return "", fmt.Errorf("no auths to merge")
+	}
+	auths, ok := merged["auths"].(map[string]any)
+	if !ok {
+		auths = map[string]any{}
+		merged["auths"] = auths
+	}
+	for registry, auth := range add.Auths {
+		auths[registry] = auth
+	}
+	content, err := json.Marshal(merged)
+	if err != nil {
+		return "", err
+	}
+	return string(content), nil
+}
+
+// parseMirrorOutput finds the install-config section in output of `oc adm release mirror`, older oc prints
+// imageContentSources and newer imageDigestSources with the same mapping.
+func parseMirrorOutput(out string) ([]ImageSource, error) {
+	var section []string
+	scanner := bufio.NewScanner(strings.NewReader(out))
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case section == nil && (strings.HasPrefix(line, "imageContentSources:") || strings.HasPrefix(line, "imageDigestSources:")):
+			section = []string{line}
+		case section != nil && (strings.HasPrefix(line, "-") || strings.HasPrefix(line, " ")):
+			section = append(section, line)
+		case section != nil:
+			// The section ends with the first line that's not part of the list.
+			return parseImageSourcesSection(section)
+		}
+	}
+	if section == nil {
+		return nil, fmt.Errorf("oc adm release mirror did not print imageContentSources or imageDigestSources")
+	}
+	return parseImageSourcesSection(section)
+}
+
+func parseImageSourcesSection(lines []string) ([]ImageSource, error) {
+	var sources ImageSources
+	if err := yaml.Unmarshal([]byte(strings.Join(lines, "\n")), &sources); err != nil {
+		return nil, fmt.Errorf("could not parse mirror mapping printed by oc: %v", err)
+	}
+	return append(sources.ImageDigestSources, sources.ImageContentSources...), nil
+}
+
+// minorVersion returns the minor version of an OpenShift 4 release version, e.g. 17 for 4.17.0-0.ci-2024-07-25.
+func minorVersion(version string) (int, error) {
+	parts := strings.SplitN(version, ".", 3)
+	if len(parts) < 2 || parts[0] != "4" {
+		return 0, fmt.Errorf("unexpected release version %q", version)
+	}
+	return strconv.Atoi(parts[1])
+}
+
+// mirrorRelease mirrors the release image to mirrorRegistry and writes the mapping to imageSourcesFile, which is
+// rendered into install-config.yaml. It uses oc of the system, the tools of the release are not extracted yet.
+func mirrorRelease(ctx context.Context, conf *Config) {
+	dir, err := filepath.Abs(filepath.Join(conf.OutputDir, mirrorDir))
+	if err != nil {
+		panic(err)
+	}
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		panic(err)
+	}
+
+	// oc reads the release from its registry and pushes to the mirror, it needs credentials of both.
+	auth, err := os.ReadFile(os.ExpandEnv(conf.PullSecretFile))
+	if err != nil {
+		panic(fmt.Errorf("could not read pull secret: %v", err))
+	}
+	authFile := filepath.Join(dir, "auth.json")
+	merged := string(auth)
+	if conf.MirrorAuthFile != "" {
+		mirrorAuth, err := os.ReadFile(os.ExpandEnv(conf.MirrorAuthFile))
+		if err != nil {
+			panic(fmt.Errorf("could not read mirrorAuthFile: %v", err))
+		}
+		if merged, err = mergePullSecrets(string(auth), string(mirrorAuth)); err != nil {
+			panic(fmt.Errorf("could not merge mirror credentials: %v", err))
+		}
+		registerPullSecret(merged)
+	}
+	if err := os.WriteFile(authFile, []byte(merged), 0600); err != nil {
+		panic(err)
+	}
+
+	info := mustReleaseInfo(ctx, conf)
+	minor, err := minorVersion(info.Metadata.Version)
+	if err != nil {
+		panic(err)
+	}
+	args := []string{"adm", "release", "mirror", "-a", authFile, "--from", conf.Image, "--to", conf.MirrorRegistry,
+		"--to-release-image", conf.MirrorRegistry + ":" + info.Metadata.Version}
+	if info.architecture() == ArchMulti {
+		args = append(args, "--keep-manifest-list=true")
+	}
+	if conf.MirrorInsecure {
+		args = append(args, "--insecure=true")
+	}
+	logger(ctx).Printf("Mirroring release %v to %v", info.Metadata.Version, conf.MirrorRegistry)
+	out, _, _ := runCommand(ctx, "oc", dir, args...)
+	mapping, err := parseMirrorOutput(out)
+	if err != nil {
+		panic(err)
+	}
+
+	var sources ImageSources
+	if minor >= imageDigestSourcesVersion {
+		sources.ImageDigestSources = mapping
+	} else {
+		sources.ImageContentSources = mapping
+	}
+	var buf bytes.Buffer
+	enc := yaml.NewEncoder(&buf)
+	enc.SetIndent(2)
+	if err := enc.Encode(sources); err != nil {
+		panic(err)
+	}
+	conf.ImageSourcesFile = filepath.Join(dir, imageSourcesFileName)
+	if err := os.WriteFile(conf.ImageSourcesFile, buf.Bytes(), 0644); err != nil {
+		panic(err)
+	}
+	logger(ctx).Printf("Mirror mapping saved to %v", conf.ImageSourcesFile)
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// mirrorOutput is the end of `oc adm release mirror` output, with the policy for upgrades that has to be ignored.
+const mirrorOutput = `Success
+Update image:  registry.example.com:5000/ocp/release:4.13.0-x86_64
+Mirror prefix: registry.example.com:5000/ocp/release
+
+To use the new mirrored repository to install, add the following section to the install-config.yaml:
+
+%s:
+- mirrors:
+  - registry.example.com:5000/ocp/release
+  source: quay.io/openshift-release-dev/ocp-release
+- mirrors:
+  - registry.example.com:5000/ocp/release
+  source: quay.io/openshift-release-dev/ocp-v4.0-art-dev
+
+
+To use the new mirrored repository for upgrades, use the following to create an ImageContentSourcePolicy:
+
+apiVersion: operator.openshift.io/v1alpha1
+kind: ImageContentSourcePolicy
+metadata:
+  name: example
+spec:
+  repositoryDigestMirrors:
+  - mirrors:
+    - registry.example.com:5000/ocp/release
+    source: quay.io/openshift-release-dev/ocp-v4.0-art-dev
+`
+
+func TestParseMirrorOutput(t *testing.T) {
+	want := []ImageSource{
+		{Source: "quay.io/openshift-release-dev/ocp-release", Mirrors: []string{"registry.example.com:5000/ocp/release"}},
+		{Source: "quay.io/openshift-release-dev/ocp-v4.0-art-dev", Mirrors: []string{"registry.example.com:5000/ocp/release"}},
+	}
+	for _, section := range []string{"imageContentSources", "imageDigestSources"} {
+		got, err := parseMirrorOutput(strings.Replace(mirrorOutput, "%s", section, 1))
+		if err != nil {
+			t.Fatalf("%s: %v", section, err)
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("%s: unexpected mapping %#v", section, got)
+		}
+	}
+
+	// The section may end the output.
+	out := mirrorOutput[:strings.Index(mirrorOutput, "\n\n\nTo use")]
+	if got, err := parseMirrorOutput(strings.Replace(out, "%s", "imageDigestSources", 1)); err != nil || !reflect.DeepEqual(got, want) {
+		t.Errorf("unexpected mapping at the end of output %#v, error %v", got, err)
+	}
+
+	if _, err := parseMirrorOutput("error: unable to connect to registry.example.com:5000\n"); err == nil {
+		t.Errorf("expected error for output without mapping")
+	}
+}
+
+func TestMergePullSecrets(t *testing.T) {
+	base := `{"auths":{"quay.io":{"auth":"cXVheQ=="},"registry.example.com:5000":{"auth":"b2xk"}}}`
+	tests := []struct {
+		name  string
+		base  string
+		extra string
+		want  map[string]string
+		err   string
+	}{
+		{
+			name:  "adds and replaces registries",
+			base:  base,
+			extra: `{"auths":{"registry.example.com:5000":{"auth":"bmV3"},"mirror.example.com":{"auth":"bWlycm9y"}}}`,
+			want: map[string]string{
+				"quay.io":                   "cXVheQ==",
+				"registry.example.com:5000": "bmV3",
+				"mirror.example.com":        "bWlycm9y",
+			},
+		},
+		{
+			name:  "base without auths",
+			base:  `{}`,
+			extra: `{"auths":{"mirror.example.com":{"auth":"bWlycm9y"}}}`,
+			want:  map[string]string{"mirror.example.com": "bWlycm9y"},
+		},
+		{name: "invalid base", base: `{"auths":`, extra: `{"auths":{}}`, err: "pull secret is not valid JSON"},
+		{name: "invalid extra", base: base, extra: `auths`, err: "not valid JSON"},
+		{name: "extra without auths", base: base, extra: `{"auths":{}}`, err: "no auths to merge"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			merged, err := mergePullSecrets(tt.base, tt.extra)
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			var got struct {
+				Auths map[string]struct {
+					Auth string `json:"auth"`
+				} `json:"auths"`
+			}
+			if err := json.Unmarshal([]byte(merged), &got); err != nil {
+				t.Fatal(err)
+			}
+			auths := map[string]string{}
+			for registry, auth := range got.Auths {
+				auths[registry] = auth.Auth
+			}
+			if !reflect.DeepEqual(auths, tt.want) {
+				t.Errorf("unexpected auths %v", auths)
+			}
+		})
+	}
+}
+
+func TestConfigImageSources(t *testing.T) {
+	dir := t.TempDir()
+	valid := filepath.Join(dir, "image-sources.yaml")
+	if err := os.WriteFile(valid, []byte("imageDigestSources:\n- mirrors:\n  - m.example.com/ocp\n  source: quay.io/ocp\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	empty := filepath.Join(dir, "empty.yaml")
+	if err := os.WriteFile(empty, []byte("foo: bar\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	missing := filepath.Join(dir, "missing.yaml")
+
+	if sources := (Config{ImageSourcesFile: valid}).ImageSources(); sources == nil || len(sources.ImageDigestSources) != 1 {
+		t.Errorf("unexpected sources %#v", sources)
+	}
+	if sources := (Config{}).ImageSources(); sources != nil {
+		t.Errorf("expected no sources without a file, got %#v", sources)
+	}
+	// Mirroring writes the file, it doesn't exist when rendering before that, e.g. in lint.
+	if sources := (Config{ImageSourcesFile: missing, MirrorRegistry: "m.example.com/ocp"}).ImageSources(); sources != nil {
+		t.Errorf("expected no sources before mirroring, got %#v", sources)
+	}
+
+	for _, conf := range []Config{{ImageSourcesFile: missing}, {ImageSourcesFile: empty}} {
+		func() {
+			defer func() {
+				if r := recover(); r == nil {
+					t.Errorf("%v: expected a panic", conf.ImageSourcesFile)
+				}
+			}()
+			conf.ImageSources()
+		}()
+	}
+}
@@ -0,0 +1,198 @@
+package utils
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"regexp"
+	"strings"
+	"time"
+)
+
+const (
+	// AutoClusterName makes Run generate a short unique cluster name.
+	AutoClusterName = "auto"
+
+	// maxDNSLabelLength is the DNS-1123 label limit, metadata.name ends up in api.<name>.<baseDomain>.
+	maxDNSLabelLength = 63
+
+	// maxInfraIDPrefixLength is how much of metadata.name openshift-install keeps in the infrastructure ID,
+	// which is the prefix of all cloud resources: <name truncated to 21 chars>-<5 random chars>.
+	maxInfraIDPrefixLength = 21
+)
+
+var dnsLabelPattern = regexp.MustCompile(`^[a-z0-9]([-a-z0-9]*[a-z0-9])?$`)
+
+// namingRules are extra restrictions of a platform on metadata.name and on the ccoctl --name value.
+type namingRules struct {
+	maxNameLength    int  // limit of metadata.name, 0 means only the DNS label limit applies
+	nameStartsLetter bool // metadata.name has to start with a letter
+	ccoctlMaxLength  int  // limit of ccoctl --name, it prefixes IAM roles, buckets and identity pools
+	ccoctlAlnumOnly  bool // ccoctl --name is used as a storage account name, only [a-z0-9] allowed
+}
+
+var platformNamingRules = map[string]namingRules{
+	"aws":     {ccoctlMaxLength: 32},
+	"gcp":     {nameStartsLetter: true, ccoctlMaxLength: 32},
+	"azure":   {ccoctlMaxLength: 24, ccoctlAlnumOnly: true},
+	"vsphere": {},
+	"alibaba": {maxNameLength: 32},
+	// Service IDs created by ccoctl are prefixed with the name.
+	"ibmcloud":  {ccoctlMaxLength: 32},
+	"powervs":   {ccoctlMaxLength: 32},
+	"nutanix":   {},
+	"openstack": {},
+}
+
+// platformOf returns the platform of a --cloud value, e.g. "aws" for "aws-sts".
+func platformOf(cloud string) string {
+	return strings.SplitN(cloud, "-", 2)[0]
+}
+
+// ValidateDNSLabel checks that name is a valid DNS-1123 label.
+func ValidateDNSLabel(name string) error {
+	if len(name) > maxDNSLabelLength {
+		return fmt.Errorf("%q is longer than %d characters", name, maxDNSLabelLength)
+	}
+	if !dnsLabelPattern.MatchString(name) {
+		return fmt.Errorf("%q must consist of lowercase letters, digits and '-', and start and end with a letter or digit", name)
+	}
+	return nil
+}
+
+// ClusterName returns metadata.name rendered into install-config.yaml by the templates.
+func ClusterName(conf *Config) string {
+	if conf.Cloud == "vsphere" {
+		return conf.UserName
+	}
+	return conf.UserName + "-" + conf.ClusterName
+}
+
+// ValidateClusterName checks the final cluster name against DNS rules and the limits of the platform.
+// The returned warnings are about names that are valid but get shortened by openshift-install.
+func ValidateClusterName(conf *Config) (warnings []string, err error) {
+	name := ClusterName(conf)
+	if err := ValidateDNSLabel(name); err != nil {
+		return nil, fmt.Errorf("invalid cluster name: %v", err)
+	}
+	rules := platformNamingRules[platformOf(conf.Cloud)]
+	if rules.nameStartsLetter && (name[0] < 'a' || name[0] > 'z') {
+		return nil, fmt.Errorf("invalid cluster name: %q has to start with a letter on %s", name, conf.Cloud)
+	}
+	if rules.maxNameLength > 0 && len(name) > rules.maxNameLength {
+		return nil, fmt.Errorf("invalid cluster name: %q is longer than %d characters allowed on %s", name, rules.maxNameLength, conf.Cloud)
+	}
+	if len(name) > maxInfraIDPrefixLength {
+		warnings = append(warnings, fmt.Sprintf("cluster name %q is longer than %d characters, cloud resources will be prefixed with %q only",
+			name, maxInfraIDPrefixLength, strings.TrimRight(name[:maxInfraIDPrefixLength], "-")))
+	}
+	return warnings, nil
+}
+
+// GenerateClusterName returns a short name unlikely to collide with other clusters of the user,
+// e.g. "aws-1019-k3x9". It's short enough to keep the whole infrastructure ID readable.
+func GenerateClusterName(cloud string) string {
+	const alphabet = "abcdefghijklmnopqrstuvwxyz0123456789"
+	b := make([]byte, 4)
+	if _, err := rand.Read(b); err != nil {
+		panic(fmt.Errorf("could not generate cluster name: %v", err))
+	}
+	for i := range b {
+		b[i] = alphabet[int(b[i])%len(alphabet)]
+	}
+	return fmt.Sprintf("%s-%s-%s", platformOf(cloud), time.Now().Format("0102"), b)
+}
+
+// resolveClusterName generates the cluster name if it's set to auto and validates the result.
+func resolveClusterName(ctx context.Context, conf *Config) {
+	if conf.ClusterName == AutoClusterName && conf.Cloud != "vsphere" {
+		conf.ClusterName = GenerateClusterName(conf.Cloud)
+		logger(ctx).Printf("Generated cluster name: %v", conf.ClusterName)
+	}
+	warnings, err := ValidateClusterName(conf)
+	if err != nil {
+		panic(err)
+	}
+	for _, w := range warnings {
+		logger(ctx).Printf("Warning: %v", w)
+	}
+}
+
+// CcoctlName returns the --name passed to ccoctl. It prefixes cloud resources ccoctl creates, so it's derived
+// from the cluster name plus a hash: reruns for the same cluster get the same name, different clusters with
+// a common prefix don't collide. A resourceGroup set in the configuration is used instead, made valid for the cloud.
+func CcoctlName(conf *Config) string {
+	rules := platformNamingRules[platformOf(conf.Cloud)]
+	if conf.ResourceGroup != "" {
+		if rules.ccoctlAlnumOnly {
+			return SanitizeResourceGroupName(conf.ResourceGroup)
+		}
+		name := strings.Trim(sanitizeName(conf.ResourceGroup), "-")
+		if rules.ccoctlMaxLength > 0 && len(name) > rules.ccoctlMaxLength {
+			name = strings.TrimRight(name[:rules.ccoctlMaxLength], "-")
+		}
+		return name
+	}
+
+	sum := sha256.Sum256([]byte(conf.Cloud + "/" + conf.CloudRegion + "/" + ClusterName(conf)))
+	hash := hex.EncodeToString(sum[:])[:6]
+	base := sanitizeName(ClusterName(conf))
+	separator := "-"
+	if rules.ccoctlAlnumOnly {
+		base = strings.ReplaceAll(base, "-", "")
+		separator = ""
+	}
+	if room := rules.ccoctlMaxLength - len(separator) - len(hash); rules.ccoctlMaxLength > 0 && len(base) > room {
+		base = base[:room]
+	}
+	return strings.TrimRight(base, "-") + separator + hash
+}
+
+// sanitizeName lowercases name and replaces characters not allowed in DNS labels with '-'.
+func sanitizeName(name string) string {
+	var result strings.Builder
+	for _, char := range strings.ToLower(strings.TrimSpace(name)) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '-' {
+			result.WriteRune(char)
+		} else {
+			result.WriteRune('-')
+		}
+	}
+	return result.String()
+}
+
+// SanitizeResourceGroupName ensures the string meets Azure storage account requirements:
+// - Between 3 and 24 characters
+// - Only lowercase letters and numbers
+// - Trims newline characters
+func SanitizeResourceGroupName(name string) string {
+	// First trim the newline character
+	name = strings.TrimSuffix(name, "\n")
+
+	// Convert to lowercase
+	name = strings.ToLower(name)
+
+	// Keep only lowercase letters and numbers
+	var result strings.Builder
+	for _, char := range name {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') {
+			result.WriteRune(char)
+		}
+	}
+
+	// Truncate to 24 characters if longer
+	sanitized := result.String()
+	if len(sanitized) > 24 {
+		sanitized = sanitized[:24]
+	}
+
+	// Ensure at least 3 characters
+	// If not enough valid characters, append placeholder digits
+	for len(sanitized) < 3 {
+		sanitized += "0"
+	}
+
+	return sanitized
+}
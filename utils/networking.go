@@ -0,0 +1,380 @@
+package utils
+
+import (
+	"fmt"
+	"net"
+	"net/url"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Network types of the cluster. OpenShiftSDN was removed in OpenShift 4.15, it's only accepted for older releases.
+const (
+	NetworkTypeOVN = "OVNKubernetes"
+	NetworkTypeSDN = "OpenShiftSDN"
+)
+
+// NetworkTypes are valid values of --network-type.
+var NetworkTypes = []string{NetworkTypeOVN, NetworkTypeSDN}
+
+// Publishing strategies of the API and ingress.
+const (
+	PublishExternal = "External"
+	PublishInternal = "Internal"
+)
+
+// Defaults rendered when networks are not configured.
+const (
+	defaultClusterNetwork = "10.128.0.0/14"
+	defaultHostPrefix     = 23
+	defaultMachineNetwork = "10.0.0.0/16"
+	defaultServiceNetwork = "172.30.0.0/16"
+	// ipv6HostPrefix is the only host prefix OVN-Kubernetes supports for IPv6 cluster networks.
+	ipv6HostPrefix = 64
+	// maxIPv4HostPrefix leaves at least a few addresses for pods of a node.
+	maxIPv4HostPrefix = 28
+)
+
+// ipv6Platforms support IPv6 and dual-stack installations, with installer-provisioned infrastructure or agents.
+var ipv6Platforms = []string{"vsphere", AgentPlatformNone, AgentPlatformBaremetal}
+
+// internalPublishPlatforms support private clusters.
+var internalPublishPlatforms = []string{"aws", "gcp", "azure"}
+
+// ovnInternalNetworks are used by OVN-Kubernetes itself, cluster networks must not overlap with them.
+var ovnInternalNetworks = []string{"100.64.0.0/16", "100.88.0.0/16", "169.254.169.0/29", "fd98::/64", "fd97::/64", "fd69::/112"}
+
+// ClusterNetworkCIDR is a pod network, every node gets a subnet of HostPrefix size from it.
+type ClusterNetworkCIDR struct {
+	CIDR       string
+	HostPrefix int
+}
+
+// Networking holds the networking settings rendered into install-config.yaml, defaults applied.
+type Networking struct {
+	Type            string
+	ClusterNetworks []ClusterNetworkCIDR
+	MachineNetworks []string
+	ServiceNetworks []string
+	Publish         string
+	HTTPProxy       string
+	HTTPSProxy      string
+	NoProxy         string
+}
+
+// splitList splits a comma separated value and drops empty items.
+func splitList(s string) []string {
+	var items []string
+	for _, item := range strings.Split(s, ",") {
+		if item = strings.TrimSpace(item); item != "" {
+			items = append(items, item)
+		}
+	}
+	return items
+}
+
+func (c Config) hostPrefix() int {
+	if n, err := strconv.Atoi(c.HostPrefix); err == nil {
+		return n
+	}
+	return defaultHostPrefix
+}
+
+// Networking returns the networking settings of the cluster. IPv4 cluster networks get hostPrefix, IPv6 ones 64.
+func (c Config) Networking() Networking {
+	n := Networking{
+		Type:            c.NetworkType,
+		MachineNetworks: splitList(c.MachineNetwork),
+		ServiceNetworks: splitList(c.ServiceNetwork),
+		Publish:         c.Publish,
+		HTTPProxy:       c.HTTPProxy,
+		HTTPSProxy:      c.HTTPSProxy,
+		NoProxy:         strings.Join(splitList(c.NoProxy), ","),
+	}
+	clusterNetworks := splitList(c.ClusterNetwork)
+	if len(clusterNetworks) == 0 {
+		clusterNetworks = []string{defaultClusterNetwork}
+	}
+	for _, cidr := range clusterNetworks {
+		prefix := c.hostPrefix()
+		if isIPv6CIDR(cidr) {
+			prefix = ipv6HostPrefix
+		}
+		n.ClusterNetworks = append(n.ClusterNetworks, ClusterNetworkCIDR{CIDR: cidr, HostPrefix: prefix})
+	}
+	if n.Type == "" {
+		n.Type = NetworkTypeOVN
+	}
+	if len(n.MachineNetworks) == 0 {
+		n.MachineNetworks = []string{defaultMachineNetwork}
+	}
+	if len(n.ServiceNetworks) == 0 {
+		n.ServiceNetworks = []string{defaultServiceNetwork}
+	}
+	if n.Publish == "" {
+		n.Publish = PublishExternal
+	}
+	return n
+}
+
+func isIPv6CIDR(cidr string) bool {
+	ip, _, err := net.ParseCIDR(cidr)
+	return err == nil && ip.To4() == nil
+}
+
+func ipFamily(ip net.IP) string {
+	if ip.To4() != nil {
+		return "IPv4"
+	}
+	return "IPv6"
+}
+
+// ipFamilies returns the IP families of the networks in order of first occurrence, the first one is primary.
+func ipFamilies(networks []*net.IPNet) []string {
+	var families []string
+	for _, n := range networks {
+		if f := ipFamily(n.IP); !slices.Contains(families, f) {
+			families = append(families, f)
+		}
+	}
+	return families
+}
+
+// ValidateNetworking checks that networks, publishing strategy and proxy settings are consistent.
+func ValidateNetworking(conf *Config) error {
+	if problems := networkingProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid networking configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func networkingProblems(conf *Config) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	n := conf.Networking()
+	platform := conf.Platform()
+
+	if !slices.Contains(NetworkTypes, n.Type) {
+		add("invalid networkType %q, valid values are: %s", n.Type, strings.Join(NetworkTypes, ", "))
+	}
+	if n.Publish != PublishExternal && n.Publish != PublishInternal {
+		add("invalid publish %q, valid values are: %s, %s", n.Publish, PublishExternal, PublishInternal)
+	} else if n.Publish == PublishInternal && !slices.Contains(internalPublishPlatforms, platform) {
+		add("publish %s is not supported on %v", PublishInternal, conf.Cloud)
+	}
+	if conf.HostPrefix != "" {
+		if _, err := strconv.Atoi(conf.HostPrefix); err != nil {
+			add("hostPrefix must be a number, got %q", conf.HostPrefix)
+		}
+	}
+
+	type network struct {
+		name string
+		net  *net.IPNet
+	}
+	var all []network
+	parse := func(key, cidr string) *net.IPNet {
+		ip, parsed, err := net.ParseCIDR(cidr)
+		if err != nil {
+			add("%s %q is not a valid CIDR", key, cidr)
+			return nil
+		}
+		if !ip.Equal(parsed.IP) {
+			add("%s %s has host bits set, use %s", key, cidr, parsed)
+		}
+		all = append(all, network{fmt.Sprintf("%s %s", key, cidr), parsed})
+		return parsed
+	}
+	var clusterNets, machineNets, serviceNets []*net.IPNet
+	for _, c := range n.ClusterNetworks {
+		parsed := parse("clusterNetwork", c.CIDR)
+		if parsed == nil {
+			continue
+		}
+		clusterNets = append(clusterNets, parsed)
+		ones, _ := parsed.Mask.Size()
+		switch {
+		case ipFamily(parsed.IP) == "IPv6" && ones > ipv6HostPrefix:
+			add("IPv6 clusterNetwork %s must be /%d or larger", c.CIDR, ipv6HostPrefix)
+		case ipFamily(parsed.IP) == "IPv4" && (c.HostPrefix <= ones || c.HostPrefix > maxIPv4HostPrefix):
+			add("hostPrefix %d of clusterNetwork %s must be between %d and %d", c.HostPrefix, c.CIDR, ones+1, maxIPv4HostPrefix)
+		}
+	}
+	for _, cidr := range n.MachineNetworks {
+		if parsed := parse("machineNetwork", cidr); parsed != nil {
+			machineNets = append(machineNets, parsed)
+		}
+	}
+	for _, cidr := range n.ServiceNetworks {
+		if parsed := parse("serviceNetwork", cidr); parsed != nil {
+			serviceNets = append(serviceNets, parsed)
+		}
+	}
+	if len(n.ServiceNetworks) > 2 || len(ipFamilies(serviceNets)) < len(serviceNets) {
+		add("serviceNetwork takes one network, or one of each IP family for dual-stack")
+	}
+
+	for i := range all {
+		for j := i + 1; j < len(all); j++ {
+			a, b := all[i].net, all[j].net
+			if a.Contains(b.IP) || b.Contains(a.IP) {
+				add("%s overlaps with %s", all[i].name, all[j].name)
+			}
+		}
+	}
+	if n.Type == NetworkTypeOVN {
+		for _, cidr := range ovnInternalNetworks {
+			_, internal, _ := net.ParseCIDR(cidr)
+			for _, nw := range all {
+				if nw.net.Contains(internal.IP) || internal.Contains(nw.net.IP) {
+					add("%s overlaps with %s used internally by %s", nw.name, cidr, NetworkTypeOVN)
+				}
+			}
+		}
+	}
+
+	// Dual-stack requires both families in every list, with the same primary family.
+	families := ipFamilies(clusterNets)
+	for _, l := range []struct {
+		key  string
+		nets []*net.IPNet
+	}{{"machineNetwork", machineNets}, {"serviceNetwork", serviceNets}} {
+		if got := ipFamilies(l.nets); len(got) > 0 && len(families) > 0 && !slices.Equal(got, families) {
+			add("%s is %s but clusterNetwork is %s, IP families and their order must match",
+				l.key, strings.Join(got, "/"), strings.Join(families, "/"))
+		}
+	}
+	if slices.Contains(families, "IPv6") {
+		if !slices.Contains(ipv6Platforms, platform) {
+			add("IPv6 and dual-stack networking are not supported on %v", conf.Cloud)
+		}
+		if n.Type != NetworkTypeOVN {
+			add("IPv6 and dual-stack networking require networkType %s", NetworkTypeOVN)
+		}
+	}
+
+	switch platform {
+	case "vsphere":
+		problems = append(problems, vipProblems(machineNets, families,
+			vipList{"vSphereApiVIP", conf.VSphereAPIVIPs()}, vipList{"vSphereIngressVIP", conf.VSphereIngressVIPs()})...)
+	case "nutanix":
+		problems = append(problems, vipProblems(machineNets, families,
+			vipList{"nutanixApiVIP", conf.NutanixAPIVIPs()}, vipList{"nutanixIngressVIP", conf.NutanixIngressVIPs()})...)
+	case AgentPlatformBaremetal:
+		problems = append(problems, vipProblems(machineNets, families,
+			vipList{"agentApiVIP", conf.AgentBaremetalAPIVIPs()}, vipList{"agentIngressVIP", conf.AgentBaremetalIngressVIPs()})...)
+	}
+	return append(problems, proxyProblems(n)...)
+}
+
+// vipList is the setting of API or ingress VIPs of a platform with on-premise load balancing.
+type vipList struct {
+	key   string
+	value []string
+}
+
+// vipProblems checks that VIPs are in a machine network and that there is one of each IP family.
+func vipProblems(machineNets []*net.IPNet, families []string, vips ...vipList) []string {
+	var problems []string
+	for _, v := range vips {
+		if len(v.value) == 0 {
+			// Missing values are reported by the platform checks.
+			continue
+		}
+		var got []string
+		for _, vip := range v.value {
+			ip := net.ParseIP(vip)
+			if ip == nil {
+				continue
+			}
+			got = append(got, ipFamily(ip))
+			if !slices.ContainsFunc(machineNets, func(n *net.IPNet) bool { return n.Contains(ip) }) {
+				problems = append(problems, fmt.Sprintf("%s address %s is not in any machineNetwork", v.key, vip))
+			}
+		}
+		if len(got) == len(v.value) && len(families) > 0 && !slices.Equal(got, families) {
+			problems = append(problems, fmt.Sprintf("%s needs one address of each IP family of the cluster in order: %s",
+				v.key, strings.Join(families, ", ")))
+		}
+	}
+	return problems
+}
+
+func proxyProblems(n Networking) []string {
+	var problems []string
+	proxies := []struct {
+		key     string
+		value   string
+		schemes []string
+	}{{"httpProxy", n.HTTPProxy, []string{"http"}}, {"httpsProxy", n.HTTPSProxy, []string{"http", "https"}}}
+	for _, p := range proxies {
+		if p.value == "" {
+			continue
+		}
+		u, err := url.Parse(p.value)
+		if err != nil || !slices.Contains(p.schemes, u.Scheme) || u.Hostname() == "" {
+			// The value may contain credentials, don't print it.
+			problems = append(problems, fmt.Sprintf("%s must be a URL of scheme %s with a host", p.key, strings.Join(p.schemes, " or ")))
+		}
+	}
+	if n.NoProxy == "" {
+		return problems
+	}
+	if n.HTTPProxy == "" && n.HTTPSProxy == "" {
+		problems = append(problems, "noProxy is set without httpProxy or httpsProxy")
+	}
+	for _, entry := range strings.Split(n.NoProxy, ",") {
+		switch {
+		case entry == "*":
+			problems = append(problems, "noProxy * is not supported, don't configure a proxy instead")
+		case strings.ContainsAny(entry, " \t/") && !isCIDR(entry):
+			problems = append(problems, fmt.Sprintf("noProxy entry %q is not a domain, IP address or CIDR", entry))
+		}
+	}
+	return problems
+}
+
+func isCIDR(s string) bool {
+	_, _, err := net.ParseCIDR(s)
+	return err == nil
+}
+
+// redactURLPassword masks the password of a URL with credentials, e.g. of a proxy.
+func redactURLPassword(s string) string {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return s
+	}
+	if _, ok := u.User.Password(); !ok {
+		return s
+	}
+	// The URL is rebuilt rather than the password searched in s, parsing decoded it and s may escape
+	// it differently. RedactedValue is inserted unescaped to keep it readable.
+	u.User = url.User(u.User.Username())
+	user := u.User.String() + "@"
+	return strings.Replace(u.String(), user, strings.TrimSuffix(user, "@")+":"+RedactedValue+"@", 1)
+}
+
+// registerURLPassword makes the password of a URL with credentials a secret, decoded and escaped as it is in s,
+// which is how the URL is logged and rendered.
+func registerURLPassword(s string) {
+	u, err := url.Parse(s)
+	if err != nil || u.User == nil {
+		return
+	}
+	password, ok := u.User.Password()
+	if !ok {
+		return
+	}
+	RegisterSecret(password)
+	// url.Parse takes the user info up to the last "@" of the authority.
+	_, rest, _ := strings.Cut(s, "://")
+	authority, _, _ := strings.Cut(rest, "/")
+	if i := strings.LastIndex(authority, "@"); i >= 0 {
+		_, raw, _ := strings.Cut(authority[:i], ":")
+		RegisterSecret(raw)
+	}
+}
@@ -2,11 +2,13 @@ package utils
 
 import (
 	"bytes"
+	"context"
 	"encoding/json"
 	"fmt"
 	"log"
 	"os"
 	"path/filepath"
+	"strings"
 	"syscall"
 	"text/template"
 
@@ -15,9 +17,10 @@ import (
 	"golang.org/x/term"
 )
 
-func userConfirm() bool {
+// UserConfirm asks a yes/no question in the terminal.
+func UserConfirm(label string) bool {
 	prompt := promptui.Select{
-		Label: "Select[Yes/No]",
+		Label: label + " [Yes/No]",
 		Items: []string{"Yes", "No"},
 	}
 	_, result, err := prompt.Run()
@@ -28,26 +31,104 @@ func userConfirm() bool {
 }
 
 // All configuration is loaded into this structure and then used to parse templates.
+// Fields tagged `secret:"true"` are masked whenever the configuration is logged or dumped.
 type Config struct {
-	Action                  string `ini:"action"`
-	Cloud                   string `ini:"cloud"`
-	ClusterName             string `ini:"clusterName"`
-	UserName                string `ini:"userName"`
-	OutputDir               string `ini:"outputDir"`
-	CloudRegion             string `ini:"cloudRegion"`
-	Image                   string `ini:"image"`
-	VSpherePassword         string `ini:"vSpherePassword"`
-	VSphereBaseDomain       string `ini:"vSphereBaseDomain"`
-	VSphereVCenterSubdomain string `ini:"vSphereVCenterSubdomain"`
-	VSphereApiVIP           string `ini:"vSphereApiVIP"`
-	VSphereIngressVIP       string `ini:"vSphereIngressVIP"`
-	SshPublicKeyFile        string `ini:"sshPublicKeyFile"`
-	SshPublicKey            string `ini:"sshPublicKey"`
-	PullSecretFile          string `ini:"pullSecretFile"`
-	PullSecret              string `ini:"pullSecret"`
-	Engine                  string `ini:"engine"`
-	ResourceGroup           string `ini:"resourceGroup"` // Obtained later by sanitizing infra name from manifest file if unset.
-	DryRun                  bool   `ini:"dryRun"`
+	Action                     string `ini:"action"`
+	Cloud                      string `ini:"cloud"`
+	ClusterName                string `ini:"clusterName"`
+	UserName                   string `ini:"userName"`
+	OutputDir                  string `ini:"outputDir"`
+	CloudRegion                string `ini:"cloudRegion"`
+	Image                      string `ini:"image"`
+	VSpherePassword            string `ini:"vSpherePassword" secret:"true"`
+	VSpherePasswordFile        string `ini:"vSpherePasswordFile"` // Used when VSpherePassword is not set.
+	VSphereBaseDomain          string `ini:"vSphereBaseDomain"`
+	VSphereVCenterSubdomain    string `ini:"vSphereVCenterSubdomain"`
+	VSphereApiVIP              string `ini:"vSphereApiVIP"`
+	VSphereIngressVIP          string `ini:"vSphereIngressVIP"`
+	SshPublicKeyFile           string `ini:"sshPublicKeyFile"`
+	SshPublicKey               string `ini:"sshPublicKey" secret:"true"`
+	PullSecretFile             string `ini:"pullSecretFile"`
+	PullSecret                 string `ini:"pullSecret" secret:"true"`
+	Engine                     string `ini:"engine"`
+	ResourceGroup              string `ini:"resourceGroup"` // Obtained later by sanitizing infra name from manifest file if unset.
+	Owner                      string `ini:"owner"`         // Owner tag of cloud resources, userName if unset.
+	Team                       string `ini:"team"`
+	ExpiresIn                  string `ini:"expiresIn"`      // Sets expirationDate relative to the time of installation, e.g. 48h.
+	ExpirationDate             string `ini:"expirationDate"` // RFC 3339, takes precedence over expiresIn.
+	Tags                       string `ini:"tags"`           // Additional tags: key=value,key=value
+	HooksFile                  string `ini:"hooksFile"`      // Post-install hooks, see hooks.yaml.template.
+	ExtraManifests             string `ini:"extraManifests"` // Directory of files added to manifests/ before installation.
+	ExtraOpenshift             string `ini:"extraOpenshift"` // Directory of files added to openshift/ before installation.
+	OverwriteManifests         bool   `ini:"overwriteManifests"`
+	Topology                   string `ini:"topology"` // ha, compact or sno
+	ControlPlaneReplicas       string `ini:"controlPlaneReplicas"`
+	ControlPlaneType           string `ini:"controlPlaneType"`
+	ControlPlaneVolumeSize     string `ini:"controlPlaneVolumeSize"` // GiB
+	ControlPlaneVolumeType     string `ini:"controlPlaneVolumeType"`
+	ComputeReplicas            string `ini:"computeReplicas"`
+	ComputeType                string `ini:"computeType"`
+	ComputeVolumeSize          string `ini:"computeVolumeSize"` // GiB
+	ComputeVolumeType          string `ini:"computeVolumeType"`
+	MachinePools               string `ini:"machinePools"`     // Extra pools: <name>:replicas=3,type=m6i.2xlarge;<name>:...
+	Arch                       string `ini:"arch"`             // amd64, arm64 or multi
+	ControlPlaneArch           string `ini:"controlPlaneArch"` // Overrides arch of control plane nodes.
+	ComputeArch                string `ini:"computeArch"`      // Overrides arch of worker nodes.
+	NetworkType                string `ini:"networkType"`      // OVNKubernetes unless set.
+	ClusterNetwork             string `ini:"clusterNetwork"`   // Pod networks: <cidr>,<cidr>
+	HostPrefix                 string `ini:"hostPrefix"`       // Node subnet size of IPv4 cluster networks, IPv6 ones use 64.
+	MachineNetwork             string `ini:"machineNetwork"`
+	ServiceNetwork             string `ini:"serviceNetwork"`
+	Publish                    string `ini:"publish"`    // External or Internal
+	HTTPProxy                  string `ini:"httpProxy"`  // Password in the URL is masked like secrets.
+	HTTPSProxy                 string `ini:"httpsProxy"` // Password in the URL is masked like secrets.
+	NoProxy                    string `ini:"noProxy"`
+	MirrorRegistry             string `ini:"mirrorRegistry"` // Repository the release is mirrored to: <host>[:<port>]/<repository>
+	MirrorAuthFile             string `ini:"mirrorAuthFile"` // Credentials of the mirror, merged into the pull secret.
+	MirrorInsecure             bool   `ini:"mirrorInsecure"`
+	ImageSourcesFile           string `ini:"imageSourcesFile"`          // Mirror mapping, written by mirroring when mirrorRegistry is set.
+	AdditionalTrustBundleFile  string `ini:"additionalTrustBundleFile"` // CA certificates trusted by the cluster, e.g. of the mirror.
+	AdditionalTrustBundle      string `ini:"additionalTrustBundle"`
+	AWSSubnets                 string `ini:"awsSubnets"` // Existing subnets: subnet-...,subnet-...
+	AWSHostedZone              string `ini:"awsHostedZone"`
+	GCPNetwork                 string `ini:"gcpNetwork"`
+	GCPControlPlaneSubnet      string `ini:"gcpControlPlaneSubnet"`
+	GCPComputeSubnet           string `ini:"gcpComputeSubnet"`
+	GCPNetworkProject          string `ini:"gcpNetworkProject"` // Host project of a shared VPC.
+	AzureNetworkResourceGroup  string `ini:"azureNetworkResourceGroup"`
+	AzureVirtualNetwork        string `ini:"azureVirtualNetwork"`
+	AzureControlPlaneSubnet    string `ini:"azureControlPlaneSubnet"`
+	AzureComputeSubnet         string `ini:"azureComputeSubnet"`
+	BaseDomain                 string `ini:"baseDomain"`            // DNS domain of IBM Cloud, Power VS, Nutanix, OpenStack and agent clusters.
+	IBMCloudResourceGroup      string `ini:"ibmCloudResourceGroup"` // Existing resource group, the installer creates one if unset.
+	PowerVSUserID              string `ini:"powervsUserID"`
+	PowerVSZone                string `ini:"powervsZone"`
+	PowerVSResourceGroup       string `ini:"powervsResourceGroup"`
+	PowerVSServiceInstanceGUID string `ini:"powervsServiceInstanceGUID"` // Existing workspace, the installer creates one if unset.
+	NutanixPrismCentral        string `ini:"nutanixPrismCentral"`
+	NutanixPort                string `ini:"nutanixPort"` // 9440 unless set.
+	NutanixUsername            string `ini:"nutanixUsername"`
+	NutanixPassword            string `ini:"nutanixPassword" secret:"true"`
+	NutanixPasswordFile        string `ini:"nutanixPasswordFile"` // Used when NutanixPassword is not set.
+	NutanixPrismElementAddress string `ini:"nutanixPrismElementAddress"`
+	NutanixPrismElementUUID    string `ini:"nutanixPrismElementUUID"`
+	NutanixSubnetUUID          string `ini:"nutanixSubnetUUID"`
+	NutanixApiVIP              string `ini:"nutanixApiVIP"`
+	NutanixIngressVIP          string `ini:"nutanixIngressVIP"`
+	OpenStackCloud             string `ini:"openstackCloud"`      // Entry of clouds.yaml.
+	OpenStackCloudsFile        string `ini:"openstackCloudsFile"` // clouds.yaml, found where OpenStack clients look if unset.
+	OpenStackExternalNetwork   string `ini:"openstackExternalNetwork"`
+	OpenStackFlavor            string `ini:"openstackFlavor"`
+	OpenStackAPIFloatingIP     string `ini:"openstackApiFloatingIP"`
+	OpenStackIngressFloatingIP string `ini:"openstackIngressFloatingIP"`
+	AgentPlatform              string `ini:"agentPlatform"` // none or baremetal, none unless set.
+	AgentHosts                 string `ini:"agentHosts"`    // <hostname>:role=master,mac=<MAC>,ip=<CIDR>;<hostname>:...
+	RendezvousIP               string `ini:"rendezvousIP"`
+	AgentGateway               string `ini:"agentGateway"` // Default gateway of hosts with static networking.
+	AgentDNS                   string `ini:"agentDNS"`     // DNS servers of hosts with static networking.
+	AgentApiVIP                string `ini:"agentApiVIP"`
+	AgentIngressVIP            string `ini:"agentIngressVIP"`
+	DryRun                     bool   `ini:"dryRun"`
 }
 
 type TemplateParser struct {
@@ -55,6 +136,8 @@ type TemplateParser struct {
 	requestedCloud    string
 	outputFile        string
 	cloudTemplatesMap map[string]string
+	// logger is the logger of the run the parser renders for.
+	logger *log.Logger
 }
 
 // cloudTemplatesMap maps --cloud <NAME> argument to a specific template file.
@@ -62,15 +145,20 @@ type TemplateParser struct {
 // For workload identity this is basically just one parameter in the template,
 // similar for ODF where we just make nodes more beefy.
 var cloudTemplatesMap = map[string]string{
-	"aws":      "aws_basic.tmpl",
-	"aws-sts":  "aws_sts.tmpl",
-	"aws-odf":  "aws_odf.tmpl",
-	"vsphere":  "vsphere_basic.tmpl",
-	"alibaba":  "alibaba_basic.tmpl",
-	"azure":    "azure_basic.tmpl",
-	"azure-wi": "azure_wi.tmpl",
-	"gcp-wif":  "gcp_wif.tmpl",
-	"gcp":      "gcp_basic.tmpl",
+	"aws":       "aws_basic.tmpl",
+	"aws-sts":   "aws_sts.tmpl",
+	"aws-odf":   "aws_odf.tmpl",
+	"vsphere":   "vsphere_basic.tmpl",
+	"alibaba":   "alibaba_basic.tmpl",
+	"azure":     "azure_basic.tmpl",
+	"azure-wi":  "azure_wi.tmpl",
+	"gcp-wif":   "gcp_wif.tmpl",
+	"gcp":       "gcp_basic.tmpl",
+	"ibmcloud":  "ibmcloud_basic.tmpl",
+	"powervs":   "powervs_basic.tmpl",
+	"nutanix":   "nutanix_basic.tmpl",
+	"openstack": "openstack_basic.tmpl",
+	"agent":     "agent_basic.tmpl",
 }
 
 // GetCloudKeys returns a slice of all cloud keys from cloudTemplatesMap
@@ -82,10 +170,10 @@ func GetCloudKeys() []string {
 	return keys
 }
 
-func NewTemplateParser(data *Config) TemplateParser {
-	log.Printf("Creating TemplateParser for cloud: %v\n", data.Cloud)
-	log.Printf("TemplateParser data: %#v\n", data)
-	templateParser := TemplateParser{}
+func NewTemplateParser(ctx context.Context, data *Config) TemplateParser {
+	logger(ctx).Printf("Creating TemplateParser for cloud: %v\n", data.Cloud)
+	logger(ctx).Printf("TemplateParser data: %#v\n", data)
+	templateParser := TemplateParser{logger: logger(ctx)}
 
 	templateParser.requestedCloud = data.Cloud
 	templateParser.data = *data
@@ -93,16 +181,64 @@ func NewTemplateParser(data *Config) TemplateParser {
 	//Flip file paths to string.
 	templateParser.data.SshPublicKey = templateParser.fileToString(data.SshPublicKeyFile, false)
 	templateParser.data.PullSecret = templateParser.fileToString(data.PullSecretFile, true)
+	if data.VSpherePassword == "" && data.VSpherePasswordFile != "" {
+		templateParser.data.VSpherePassword = strings.TrimSpace(templateParser.fileToString(data.VSpherePasswordFile, false))
+	}
+	if data.NutanixPassword == "" && data.NutanixPasswordFile != "" {
+		templateParser.data.NutanixPassword = strings.TrimSpace(templateParser.fileToString(data.NutanixPasswordFile, false))
+	}
+	if data.MirrorAuthFile != "" {
+		merged, err := mergePullSecrets(templateParser.data.PullSecret, templateParser.fileToString(data.MirrorAuthFile, true))
+		if err != nil {
+			panic(fmt.Errorf("could not merge mirrorAuthFile into the pull secret: %v", err))
+		}
+		templateParser.data.PullSecret = merged
+	}
+	if data.AdditionalTrustBundleFile != "" {
+		templateParser.data.AdditionalTrustBundle = strings.TrimSpace(templateParser.fileToString(data.AdditionalTrustBundleFile, false))
+	}
+	registerPullSecret(templateParser.data.PullSecret)
+	RegisterSecret(templateParser.data.VSpherePassword)
+	RegisterSecret(templateParser.data.NutanixPassword)
+	registerURLPassword(templateParser.data.HTTPProxy)
+	registerURLPassword(templateParser.data.HTTPSProxy)
 
 	//Output file name.
 	templateParser.outputFile = "install-config.yaml"
 
 	//Mapping from argument to file.
 	templateParser.cloudTemplatesMap = cloudTemplatesMap
-	log.Printf("TemplateParser created with cloud: %v\n", templateParser.requestedCloud)
+	logger(ctx).Printf("TemplateParser created with cloud: %v\n", templateParser.requestedCloud)
 	return templateParser
 }
 
+// templateFuncs are helper functions available in all templates.
+var templateFuncs = template.FuncMap{
+	"yamlQuote":    yamlQuote,
+	"poolPlatform": poolPlatform,
+	"indent":       indent,
+}
+
+// sharedTemplates define machine pools, networking, proxy, mirror and existing network settings, they're parsed
+// together with every cloud template.
+var sharedTemplates = []string{"machinepools.tmpl", "networking.tmpl", "mirror.tmpl", "vpc.tmpl"}
+
+// parseCloudTemplate parses the named cloud template with the shared templates it uses.
+func parseCloudTemplate(name string) (*template.Template, error) {
+	return template.New(name).Funcs(templateFuncs).ParseFS(templates.F, append([]string{name}, sharedTemplates...)...)
+}
+
+// yamlQuote renders a value as a single quoted YAML scalar, so it may contain any characters.
+func yamlQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// indent prefixes every line of s with n spaces, for multi-line values in YAML block scalars.
+func indent(n int, s string) string {
+	pad := strings.Repeat(" ", n)
+	return pad + strings.ReplaceAll(s, "\n", "\n"+pad)
+}
+
 func (t *TemplateParser) getTemplatePath(filename string) string {
 	dir, error := templates.F.ReadDir(".")
 	if error != nil {
@@ -143,18 +279,18 @@ func (t *TemplateParser) getSupportedClouds() []string {
 }
 
 func (t *TemplateParser) fileToString(file string, compact bool) string {
-	log.Printf("Reading file: %v\n", file)
+	t.logger.Printf("Reading file: %v\n", file)
 	expandedFilePath := os.ExpandEnv(file)
 	content, err := os.ReadFile(expandedFilePath)
 	if err != nil {
-		log.Fatal(err)
+		t.logger.Fatal(err)
 	}
 
 	if compact {
-		log.Printf("Compacting json file: %v\n", file)
+		t.logger.Printf("Compacting json file: %v\n", file)
 		buffer := new(bytes.Buffer)
 		if err := json.Compact(buffer, content); err != nil {
-			log.Fatal(err)
+			t.logger.Fatal(err)
 		}
 		return buffer.String()
 	}
@@ -166,9 +302,9 @@ func (t *TemplateParser) fileToString(file string, compact bool) string {
 func (t *TemplateParser) ParseTemplate() {
 	templateFileName := t.getTemplateName(t.requestedCloud)
 
-	log.Printf("Using template: %v with data: %+v\n", templateFileName, t.data)
+	t.logger.Printf("Using template: %v with data: %+v\n", templateFileName, t.data)
 
-	tmp := template.Must(template.New(templateFileName).ParseFS(templates.F, templateFileName))
+	tmp := template.Must(parseCloudTemplate(templateFileName))
 
 	output := filepath.Join(t.data.OutputDir, t.outputFile)
 
@@ -189,19 +325,54 @@ func (t *TemplateParser) ParseTemplate() {
 	//	t.data.VSpherePassword = password
 	//}
 
-	f, err := os.OpenFile(output, os.O_WRONLY|os.O_CREATE, 0755)
-	if err != nil {
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, t.data); err != nil {
 		panic(err)
 	}
-	defer f.Close()
-
-	err = tmp.Execute(f, t.data)
-	if err != nil {
+	// install-config.yaml contains the pull secret and possibly the vSphere password.
+	if err := writeFileAtomic(output, buf.Bytes(), 0600); err != nil {
 		panic(err)
 	}
 
-	//TODO: maybe the install config should be backed up? openshift-install will destroy it
+	// openshift-install consumes install-config.yaml, keep a copy without secrets for troubleshooting.
+	t.writeRedactedCopy(tmp)
+
+	if t.requestedCloud == agentCloud {
+		writeAgentConfig(t.data)
+	}
+}
 
+// writeRedactedCopy starts a new history entry with install-config.yaml rendered without secrets.
+func (t *TemplateParser) writeRedactedCopy(tmp *template.Template) {
+	var buf bytes.Buffer
+	if err := tmp.Execute(&buf, t.data.Redacted()); err != nil {
+		panic(err)
+	}
+	output := filepath.Join(newHistoryEntry(t.data.OutputDir), t.outputFile)
+	if err := os.WriteFile(output, buf.Bytes(), 0600); err != nil {
+		panic(err)
+	}
+}
+
+// writeFileAtomic replaces path with content, so an interrupted write never leaves a truncated or mixed file.
+func writeFileAtomic(path string, content []byte, perm os.FileMode) error {
+	f, err := os.CreateTemp(filepath.Dir(path), "."+filepath.Base(path)+".tmp-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if err := f.Chmod(perm); err != nil {
+		f.Close()
+		return err
+	}
+	if _, err := f.Write(content); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), path)
 }
 
 func passwordPrompt(prompt string) string {
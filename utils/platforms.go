@@ -0,0 +1,287 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+	"regexp"
+	"slices"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	defaultNutanixPort = "9440"
+	// nutanixCredentialsFile is the ccoctl nutanix credentials source written to the output dir.
+	nutanixCredentialsFile = "nutanix-credentials.yaml"
+	// openstackCloudsFile is where openshift-install looks for clouds.yaml first, its working directory.
+	openstackCloudsFile = "clouds.yaml"
+	// ibmCloudAPIKeyEnv authenticates openshift-install and ccoctl on IBM Cloud and Power VS.
+	ibmCloudAPIKeyEnv = "IC_API_KEY"
+	// ccoctlNameFile keeps the --name of ccoctl used at create, destroy deletes what ccoctl created by it.
+	ccoctlNameFile = toolDir + "/ccoctl-name"
+)
+
+var uuidPattern = regexp.MustCompile(`^[0-9a-f]{8}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{4}-[0-9a-f]{12}$`)
+
+// ibmServiceIDClouds create a service ID per CredentialsRequest with `ccoctl ibmcloud`, which is deleted with
+// the cluster.
+var ibmServiceIDClouds = []string{"ibmcloud", "powervs"}
+
+// NutanixPrismPort returns the port of Prism Central and Prism Element.
+func (c Config) NutanixPrismPort() string {
+	if c.NutanixPort == "" {
+		return defaultNutanixPort
+	}
+	return c.NutanixPort
+}
+
+// NutanixAPIVIPs returns the API VIPs of a Nutanix cluster.
+func (c Config) NutanixAPIVIPs() []string {
+	return splitList(c.NutanixApiVIP)
+}
+
+// NutanixIngressVIPs returns the ingress VIPs of a Nutanix cluster.
+func (c Config) NutanixIngressVIPs() []string {
+	return splitList(c.NutanixIngressVIP)
+}
+
+// nutanixPassword returns nutanixPassword, or the content of nutanixPasswordFile if it's not set.
+func (c Config) nutanixPassword() (string, error) {
+	if c.NutanixPassword != "" || c.NutanixPasswordFile == "" {
+		return c.NutanixPassword, nil
+	}
+	content, err := os.ReadFile(os.ExpandEnv(c.NutanixPasswordFile))
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(content)), nil
+}
+
+// openstackCloudsPath returns openstackCloudsFile, or the first clouds.yaml found where OpenStack clients look.
+func (c Config) openstackCloudsPath() string {
+	if c.OpenStackCloudsFile != "" {
+		return os.ExpandEnv(c.OpenStackCloudsFile)
+	}
+	candidates := []string{os.Getenv("OS_CLIENT_CONFIG_FILE"), os.ExpandEnv("${HOME}/.config/openstack/clouds.yaml"), "/etc/openstack/clouds.yaml"}
+	for _, path := range candidates {
+		if _, err := os.Stat(path); path != "" && err == nil {
+			return path
+		}
+	}
+	return ""
+}
+
+// openstackClouds returns names of the clouds defined in a clouds.yaml file.
+func openstackClouds(path string) ([]string, error) {
+	content, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var parsed struct {
+		Clouds map[string]any `yaml:"clouds"`
+	}
+	if err := yaml.Unmarshal(content, &parsed); err != nil {
+		return nil, fmt.Errorf("%v is not valid YAML: %v", path, err)
+	}
+	var names []string
+	for name := range parsed.Clouds {
+		names = append(names, name)
+	}
+	slices.Sort(names)
+	return names, nil
+}
+
+// ValidatePlatformConfig checks that values required by the templates of IBM Cloud, Nutanix, OpenStack and
+// Power VS are set. Like ValidateVSphereConfig it doesn't touch the network.
+func ValidatePlatformConfig(conf *Config) error {
+	if problems := platformConfigProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid %s configuration: %s", conf.Platform(), strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func platformConfigProblems(conf *Config) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	var required []struct{ key, value string }
+	switch conf.Platform() {
+	case "ibmcloud":
+		required = []struct{ key, value string }{
+			{"baseDomain", conf.BaseDomain},
+			{"cloudRegion", conf.CloudRegion},
+		}
+	case "powervs":
+		required = []struct{ key, value string }{
+			{"baseDomain", conf.BaseDomain},
+			{"powervsUserID", conf.PowerVSUserID},
+			{"cloudRegion", conf.CloudRegion},
+			{"powervsZone", conf.PowerVSZone},
+			{"powervsResourceGroup", conf.PowerVSResourceGroup},
+		}
+		if guid := conf.PowerVSServiceInstanceGUID; guid != "" && !uuidPattern.MatchString(guid) {
+			add("powervsServiceInstanceGUID %q is not a GUID", guid)
+		}
+	case "nutanix":
+		required = []struct{ key, value string }{
+			{"baseDomain", conf.BaseDomain},
+			{"nutanixPrismCentral", conf.NutanixPrismCentral},
+			{"nutanixUsername", conf.NutanixUsername},
+			{"nutanixPrismElementAddress", conf.NutanixPrismElementAddress},
+			{"nutanixPrismElementUUID", conf.NutanixPrismElementUUID},
+			{"nutanixSubnetUUID", conf.NutanixSubnetUUID},
+			{"nutanixApiVIP", conf.NutanixApiVIP},
+			{"nutanixIngressVIP", conf.NutanixIngressVIP},
+		}
+		for _, u := range []struct{ key, value string }{
+			{"nutanixPrismElementUUID", conf.NutanixPrismElementUUID},
+			{"nutanixSubnetUUID", conf.NutanixSubnetUUID},
+		} {
+			if u.value != "" && !uuidPattern.MatchString(u.value) {
+				add("%s %q is not a UUID", u.key, u.value)
+			}
+		}
+		for _, v := range []struct {
+			key   string
+			value []string
+		}{{"nutanixApiVIP", conf.NutanixAPIVIPs()}, {"nutanixIngressVIP", conf.NutanixIngressVIPs()}} {
+			for _, vip := range v.value {
+				if net.ParseIP(vip) == nil {
+					add("%s is not a valid IP address: %q", v.key, vip)
+				}
+			}
+		}
+		if conf.NutanixPassword == "" && conf.NutanixPasswordFile == "" {
+			add("nutanixPassword or nutanixPasswordFile is required")
+		} else if _, err := conf.nutanixPassword(); err != nil {
+			add("nutanixPasswordFile can not be read: %v", err)
+		}
+	case "openstack":
+		required = []struct{ key, value string }{
+			{"baseDomain", conf.BaseDomain},
+			{"openstackCloud", conf.OpenStackCloud},
+			{"openstackFlavor", conf.OpenStackFlavor},
+		}
+		for _, ip := range []struct{ key, value string }{
+			{"openstackApiFloatingIP", conf.OpenStackAPIFloatingIP},
+			{"openstackIngressFloatingIP", conf.OpenStackIngressFloatingIP},
+		} {
+			if ip.value == "" {
+				continue
+			}
+			if net.ParseIP(ip.value) == nil {
+				add("%s is not a valid IP address: %q", ip.key, ip.value)
+			}
+			if conf.OpenStackExternalNetwork == "" {
+				add("%s requires openstackExternalNetwork", ip.key)
+			}
+		}
+		problems = append(problems, openstackCloudProblems(conf)...)
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			add("%s is required", r.key)
+		}
+	}
+	return problems
+}
+
+// openstackCloudProblems checks that openstackCloud is defined in the clouds.yaml openshift-install gets.
+func openstackCloudProblems(conf *Config) []string {
+	path := conf.openstackCloudsPath()
+	if path == "" {
+		return []string{"no clouds.yaml found, set openstackCloudsFile"}
+	}
+	clouds, err := openstackClouds(path)
+	if err != nil {
+		return []string{fmt.Sprintf("openstackCloudsFile can not be read: %v", err)}
+	}
+	if conf.OpenStackCloud != "" && !slices.Contains(clouds, conf.OpenStackCloud) {
+		return []string{fmt.Sprintf("cloud %q is not defined in %v, use one of: %s", conf.OpenStackCloud, path, strings.Join(clouds, ", "))}
+	}
+	return nil
+}
+
+// writeNutanixCredentials writes the Prism Central credentials ccoctl nutanix creates the cluster's secrets from.
+func writeNutanixCredentials(conf *Config) string {
+	password, err := conf.nutanixPassword()
+	if err != nil {
+		panic(fmt.Errorf("could not read nutanixPasswordFile: %v", err))
+	}
+	credentials := map[string]any{
+		"credentials": []any{map[string]any{
+			"type": "basic_auth",
+			"data": map[string]any{
+				"prismCentral": map[string]string{"username": conf.NutanixUsername, "password": password},
+			},
+		}},
+	}
+	content, err := yaml.Marshal(credentials)
+	if err != nil {
+		panic(err)
+	}
+	path := filepath.Join(conf.OutputDir, nutanixCredentialsFile)
+	if err := os.WriteFile(path, content, 0600); err != nil {
+		panic(err)
+	}
+	return path
+}
+
+// copyOpenstackClouds copies clouds.yaml to the output dir, openshift-install and oc run there read it first.
+func copyOpenstackClouds(ctx context.Context, conf *Config) {
+	path := conf.openstackCloudsPath()
+	content, err := os.ReadFile(path)
+	if err != nil {
+		panic(fmt.Errorf("could not read clouds.yaml: %v", err))
+	}
+	logger(ctx).Printf("Using OpenStack cloud %v of %v", conf.OpenStackCloud, path)
+	if err := os.WriteFile(filepath.Join(conf.OutputDir, openstackCloudsFile), content, 0600); err != nil {
+		panic(err)
+	}
+}
+
+// mustIBMCloudAPIKey checks that openshift-install and ccoctl can authenticate to IBM Cloud.
+func mustIBMCloudAPIKey() {
+	if os.Getenv(ibmCloudAPIKeyEnv) == "" {
+		panic(fmt.Errorf("%s has to be set to an IBM Cloud API key", ibmCloudAPIKeyEnv))
+	}
+}
+
+// recordCcoctlName keeps the ccoctl name of the cluster being created in its output dir. It depends on the region
+// and configuration, destroy can't derive it again reliably.
+func recordCcoctlName(outputDir, name string) {
+	path := filepath.Join(outputDir, ccoctlNameFile)
+	err := os.MkdirAll(filepath.Dir(path), 0755)
+	if err == nil {
+		err = os.WriteFile(path, []byte(name+"\n"), 0644)
+	}
+	if err != nil {
+		panic(fmt.Errorf("could not record ccoctl name: %v", err))
+	}
+}
+
+// installedCcoctlName returns the ccoctl name of the cluster installed in outputDir, as recorded at create.
+// For clusters installed before it was recorded, it's derived again with the cluster name from metadata.json and
+// the region of conf, which has to be the region of the installation.
+func installedCcoctlName(ctx context.Context, conf *Config) string {
+	content, err := os.ReadFile(filepath.Join(conf.OutputDir, ccoctlNameFile))
+	if err == nil {
+		return strings.TrimSpace(string(content))
+	}
+	if !os.IsNotExist(err) {
+		panic(fmt.Errorf("could not read ccoctl name: %v", err))
+	}
+	logger(ctx).Printf("Warning: ccoctl name of the cluster is not recorded in %v, deriving it from region %v", ccoctlNameFile, conf.CloudRegion)
+	name, err := installedClusterName(conf.OutputDir)
+	if err != nil {
+		panic(err)
+	}
+	installed := *conf
+	installed.ClusterName = strings.TrimPrefix(name, conf.UserName+"-")
+	return CcoctlName(&installed)
+}
@@ -0,0 +1,284 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+
+	"gopkg.in/yaml.v3"
+)
+
+// lintTestConfig returns a configuration of cloud with an SSH key and pull secret lint can read.
+func lintTestConfig(t *testing.T, cloud string) Config {
+	t.Helper()
+	dir := t.TempDir()
+	files := map[string]string{
+		"id_ed25519.pub":   "ssh-ed25519 AAAAC3NzaC1lZDI1NTE5AAAAIHRlc3Q= jdoe@example.com\n",
+		"pull-secret.json": `{"auths":{"quay.io":{"auth":"dXNlcjpwYXNz"}}}`,
+	}
+	for name, content := range files {
+		if err := os.WriteFile(filepath.Join(dir, name), []byte(content), 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return Config{
+		Cloud:            cloud,
+		UserName:         "jdoe",
+		ClusterName:      "c1",
+		BaseDomain:       "example.com",
+		SshPublicKeyFile: filepath.Join(dir, "id_ed25519.pub"),
+		PullSecretFile:   filepath.Join(dir, "pull-secret.json"),
+	}
+}
+
+// writeCloudsFile writes a clouds.yaml defining the clouds to a temporary directory.
+func writeCloudsFile(t *testing.T, clouds ...string) string {
+	t.Helper()
+	content := "clouds:\n"
+	for _, cloud := range clouds {
+		content += "  " + cloud + ":\n    auth:\n      auth_url: https://keystone.example.com:5000/v3\n"
+	}
+	path := filepath.Join(t.TempDir(), "clouds.yaml")
+	if err := os.WriteFile(path, []byte(content), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// renderedPlatform returns the platform section of a rendered install-config.yaml.
+func renderedPlatform(t *testing.T, rendered []byte) string {
+	t.Helper()
+	var ic struct {
+		Platform map[string]any `yaml:"platform"`
+	}
+	if err := yaml.Unmarshal(rendered, &ic); err != nil {
+		t.Fatalf("rendered install-config.yaml is not valid YAML: %v\n%s", err, rendered)
+	}
+	out, err := yaml.Marshal(ic.Platform)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(out)
+}
+
+func TestLintRenderPlatforms(t *testing.T) {
+	t.Setenv(ibmCloudAPIKeyEnv, "test")
+	cloudsFile := writeCloudsFile(t, "openstack", "psi")
+	passwordFile := filepath.Join(t.TempDir(), "nutanix-password")
+	if err := os.WriteFile(passwordFile, []byte("s3cret\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		cloud    string
+		modify   func(c *Config)
+		platform string
+		errors   []string
+	}{
+		{
+			name:  "ibmcloud",
+			cloud: "ibmcloud",
+			modify: func(c *Config) {
+				c.CloudRegion = "us-south"
+				c.IBMCloudResourceGroup = "ocp-qe"
+			},
+			platform: `ibmcloud:
+    region: us-south
+    resourceGroupName: ocp-qe
+`,
+		},
+		{
+			name:     "ibmcloud without resource group",
+			cloud:    "ibmcloud",
+			modify:   func(c *Config) { c.CloudRegion = "eu-de" },
+			platform: "ibmcloud:\n    region: eu-de\n",
+		},
+		{
+			name:   "ibmcloud without region and domain",
+			cloud:  "ibmcloud",
+			modify: func(c *Config) { c.BaseDomain = "" },
+			errors: []string{"baseDomain is required", "cloudRegion is required"},
+		},
+		{
+			name:  "powervs",
+			cloud: "powervs",
+			modify: func(c *Config) {
+				c.CloudRegion = "dal"
+				c.PowerVSZone = "dal10"
+				c.PowerVSUserID = "jdoe@example.com"
+				c.PowerVSResourceGroup = "ocp-qe"
+				c.PowerVSServiceInstanceGUID = "0c4f2a7e-6b8a-4c3e-9f0a-1b2c3d4e5f60"
+			},
+			platform: `powervs:
+    powervsResourceGroup: ocp-qe
+    region: dal
+    serviceInstanceGUID: 0c4f2a7e-6b8a-4c3e-9f0a-1b2c3d4e5f60
+    userID: jdoe@example.com
+    zone: dal10
+`,
+		},
+		{
+			name:  "powervs with missing values",
+			cloud: "powervs",
+			modify: func(c *Config) {
+				c.CloudRegion = "dal"
+				c.PowerVSServiceInstanceGUID = "workspace"
+			},
+			errors: []string{
+				`powervsServiceInstanceGUID "workspace" is not a GUID`,
+				"powervsUserID is required",
+				"powervsZone is required",
+				"powervsResourceGroup is required",
+			},
+		},
+		{
+			name:  "nutanix",
+			cloud: "nutanix",
+			modify: func(c *Config) {
+				c.MachineNetwork = "10.40.0.0/24"
+				c.NutanixPrismCentral = "pc.example.com"
+				c.NutanixUsername = "admin"
+				c.NutanixPasswordFile = passwordFile
+				c.NutanixPrismElementAddress = "pe.example.com"
+				c.NutanixPrismElementUUID = "00061e5f-0a24-4e7c-9b5a-3e5d1f7a2b4c"
+				c.NutanixSubnetUUID = "5b9c1e2d-3f4a-4b6c-8d7e-9f0a1b2c3d4e"
+				c.NutanixApiVIP = "10.40.0.5"
+				c.NutanixIngressVIP = "10.40.0.6"
+			},
+			platform: `nutanix:
+    apiVIPs:
+        - 10.40.0.5
+    ingressVIPs:
+        - 10.40.0.6
+    prismCentral:
+        endpoint:
+            address: pc.example.com
+            port: 9440
+        password: <redacted>
+        username: admin
+    prismElements:
+        - endpoint:
+            address: pe.example.com
+            port: 9440
+          uuid: 00061e5f-0a24-4e7c-9b5a-3e5d1f7a2b4c
+    subnetUUIDs:
+        - 5b9c1e2d-3f4a-4b6c-8d7e-9f0a1b2c3d4e
+`,
+		},
+		{
+			name:  "nutanix with invalid values",
+			cloud: "nutanix",
+			modify: func(c *Config) {
+				c.MachineNetwork = "10.40.0.0/24"
+				c.NutanixPrismCentral = "pc.example.com"
+				c.NutanixUsername = "admin"
+				c.NutanixPrismElementAddress = "pe.example.com"
+				c.NutanixPrismElementUUID = "pe-1"
+				c.NutanixApiVIP = "10.40.0.5"
+				c.NutanixIngressVIP = "ingress"
+			},
+			errors: []string{
+				`nutanixPrismElementUUID "pe-1" is not a UUID`,
+				`nutanixIngressVIP is not a valid IP address: "ingress"`,
+				"nutanixPassword or nutanixPasswordFile is required",
+				"nutanixSubnetUUID is required",
+			},
+		},
+		{
+			name:  "openstack",
+			cloud: "openstack",
+			modify: func(c *Config) {
+				c.OpenStackCloudsFile = cloudsFile
+				c.OpenStackCloud = "psi"
+				c.OpenStackFlavor = "ci.m1.xlarge"
+				c.OpenStackExternalNetwork = "provider_net_shared"
+				c.OpenStackAPIFloatingIP = "10.0.100.5"
+				c.OpenStackIngressFloatingIP = "10.0.100.6"
+			},
+			platform: `openstack:
+    apiFloatingIP: 10.0.100.5
+    cloud: psi
+    defaultMachinePlatform:
+        type: ci.m1.xlarge
+    externalNetwork: provider_net_shared
+    ingressFloatingIP: 10.0.100.6
+`,
+		},
+		{
+			name:  "openstack with unknown cloud",
+			cloud: "openstack",
+			modify: func(c *Config) {
+				c.OpenStackCloudsFile = cloudsFile
+				c.OpenStackCloud = "prod"
+				c.OpenStackAPIFloatingIP = "10.0.100.5"
+			},
+			errors: []string{
+				"openstackApiFloatingIP requires openstackExternalNetwork",
+				`cloud "prod" is not defined in ` + cloudsFile + ", use one of: openstack, psi",
+				"openstackFlavor is required",
+			},
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			conf := lintTestConfig(t, tt.cloud)
+			tt.modify(&conf)
+			findings, rendered := LintRender(conf)
+			var errors []string
+			for _, f := range findings {
+				if f.Severity != LintError {
+					continue
+				}
+				if f.Check != "platform" {
+					// Invalid configurations may fail other checks too, e.g. of the region.
+					if tt.errors == nil {
+						t.Errorf("unexpected %v error: %v", f.Check, f.Message)
+					}
+					continue
+				}
+				errors = append(errors, f.Message)
+			}
+			if !reflect.DeepEqual(errors, tt.errors) {
+				t.Errorf("unexpected errors:\n%s\nwant:\n%s", strings.Join(errors, "\n"), strings.Join(tt.errors, "\n"))
+			}
+			if tt.platform == "" {
+				return
+			}
+			if rendered == nil {
+				t.Fatal("install-config.yaml was not rendered")
+			}
+			if got := renderedPlatform(t, rendered); got != tt.platform {
+				t.Errorf("unexpected platform:\n%s\nwant:\n%s", got, tt.platform)
+			}
+		})
+	}
+}
+
+func TestInstalledCcoctlName(t *testing.T) {
+	dir := t.TempDir()
+	if err := os.WriteFile(filepath.Join(dir, "metadata.json"), []byte(`{"clusterName":"jdoe-c1"}`), 0600); err != nil {
+		t.Fatal(err)
+	}
+	conf := Config{Cloud: "ibmcloud", UserName: "jdoe", ClusterName: "c1", CloudRegion: "us-south", OutputDir: dir}
+	created := CcoctlName(&conf)
+	recordCcoctlName(dir, created)
+
+	// Destroy may run with a different region, the recorded name is used.
+	conf.CloudRegion = "us-east-1"
+	if got := installedCcoctlName(context.Background(), &conf); got != created {
+		t.Errorf("expected recorded ccoctl name %v, got %v", created, got)
+	}
+
+	// Clusters installed before the name was recorded derive it again.
+	if err := os.Remove(filepath.Join(dir, ccoctlNameFile)); err != nil {
+		t.Fatal(err)
+	}
+	conf.CloudRegion = "us-south"
+	if got := installedCcoctlName(context.Background(), &conf); got != created {
+		t.Errorf("expected derived ccoctl name %v, got %v", created, got)
+	}
+}
@@ -0,0 +1,108 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+)
+
+const (
+	// ProfilesPath is the directory with named configuration profiles, selected with --profile.
+	ProfilesPath = HomeConfigPath + "/profiles"
+
+	// ProfileExtendsKey names the profile a profile inherits values from.
+	ProfileExtendsKey = "extends"
+
+	profileSuffix = ".env"
+)
+
+var profileNamePattern = regexp.MustCompile(`^[A-Za-z0-9][A-Za-z0-9._-]*$`)
+
+// ProfilePath returns path of the profile file with the given name.
+func ProfilePath(name string) (string, error) {
+	if !profileNamePattern.MatchString(name) {
+		return "", fmt.Errorf("invalid profile name %q, use letters, digits, '.', '_' and '-'", name)
+	}
+	return filepath.Join(os.ExpandEnv(ProfilesPath), name+profileSuffix), nil
+}
+
+// ListProfiles returns names of all profiles, sorted.
+func ListProfiles() ([]string, error) {
+	entries, err := os.ReadDir(os.ExpandEnv(ProfilesPath))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	var names []string
+	for _, e := range entries {
+		if !e.IsDir() && strings.HasSuffix(e.Name(), profileSuffix) {
+			names = append(names, strings.TrimSuffix(e.Name(), profileSuffix))
+		}
+	}
+	sort.Strings(names)
+	return names, nil
+}
+
+// ResolveProfile follows the extends chain of the named profile and returns paths of the profile files,
+// the base profile first and the named profile last, so later files override earlier ones.
+func ResolveProfile(name string) ([]string, error) {
+	var chain []string
+	seen := map[string]bool{}
+	for name != "" {
+		if seen[name] {
+			return nil, fmt.Errorf("profile %q extends itself through: %s", name, strings.Join(append(profileNames(chain), name), " -> "))
+		}
+		seen[name] = true
+		path, err := ProfilePath(name)
+		if err != nil {
+			return nil, err
+		}
+		values, err := ReadConfigFile(path)
+		if err != nil {
+			return nil, fmt.Errorf("could not read profile %q: %v", name, err)
+		}
+		chain = append([]string{path}, chain...)
+		name = strings.TrimSpace(values[ProfileExtendsKey])
+	}
+	return chain, nil
+}
+
+// profileNames returns profile names of the given profile files in the order they extend each other.
+func profileNames(chain []string) []string {
+	var names []string
+	for i := len(chain) - 1; i >= 0; i-- {
+		names = append(names, strings.TrimSuffix(filepath.Base(chain[i]), profileSuffix))
+	}
+	return names
+}
+
+// CopyProfile creates profile dst with the content of profile src, an existing dst is not overwritten.
+func CopyProfile(src, dst string) error {
+	srcPath, err := ProfilePath(src)
+	if err != nil {
+		return err
+	}
+	dstPath, err := ProfilePath(dst)
+	if err != nil {
+		return err
+	}
+	content, err := os.ReadFile(srcPath)
+	if err != nil {
+		return fmt.Errorf("could not read profile %q: %v", src, err)
+	}
+	f, err := os.OpenFile(dstPath, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if os.IsExist(err) {
+		return fmt.Errorf("profile %q already exists", dst)
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	_, err = f.Write(content)
+	return err
+}
@@ -0,0 +1,169 @@
+package utils
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"sync"
+)
+
+// RedactedValue replaces secrets in logs and configuration dumps.
+const RedactedValue = "<redacted>"
+
+// Redacted returns a copy of the configuration with every field tagged `secret:"true"` and proxy passwords masked.
+func (c Config) Redacted() Config {
+	c.HTTPProxy = redactURLPassword(c.HTTPProxy)
+	c.HTTPSProxy = redactURLPassword(c.HTTPSProxy)
+	v := reflect.ValueOf(&c).Elem()
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		if t.Field(i).Tag.Get("secret") == "true" && v.Field(i).Kind() == reflect.String && v.Field(i).String() != "" {
+			v.Field(i).SetString(RedactedValue)
+		}
+	}
+	return c
+}
+
+// IsSecretField reports whether the named Config field holds a secret.
+func IsSecretField(name string) bool {
+	field, ok := reflect.TypeOf(Config{}).FieldByName(name)
+	return ok && field.Tag.Get("secret") == "true"
+}
+
+// plainConfig has the fields of Config without its methods, so it can be formatted without recursion.
+type plainConfig Config
+
+// String is used for %v and %+v, secrets are masked.
+func (c Config) String() string {
+	return fmt.Sprintf("%+v", plainConfig(c.Redacted()))
+}
+
+// GoString is used for %#v, secrets are masked.
+func (c Config) GoString() string {
+	return strings.Replace(fmt.Sprintf("%#v", plainConfig(c.Redacted())), "utils.plainConfig", "utils.Config", 1)
+}
+
+var secrets = struct {
+	sync.RWMutex
+	values map[string]struct{}
+}{values: map[string]struct{}{}}
+
+// RegisterSecret makes the redacting writer mask every occurrence of value.
+func RegisterSecret(value string) {
+	value = strings.TrimSpace(value)
+	// Very short values would mask unrelated output.
+	if len(value) < 4 {
+		return
+	}
+	secrets.Lock()
+	defer secrets.Unlock()
+	secrets.values[value] = struct{}{}
+}
+
+// registerPullSecret registers the whole pull secret and each registry credential in it.
+func registerPullSecret(pullSecret string) {
+	RegisterSecret(pullSecret)
+	var parsed struct {
+		Auths map[string]struct {
+			Auth string `json:"auth"`
+		} `json:"auths"`
+	}
+	if err := json.Unmarshal([]byte(pullSecret), &parsed); err != nil {
+		return
+	}
+	for _, a := range parsed.Auths {
+		RegisterSecret(a.Auth)
+	}
+}
+
+// Redact masks all registered secrets and well known secret patterns in s.
+func Redact(s string) string {
+	secrets.RLock()
+	values := make([]string, 0, len(secrets.values))
+	for value := range secrets.values {
+		values = append(values, value)
+	}
+	secrets.RUnlock()
+	// Longer secrets first, a secret may contain others, like the pull secret contains registry credentials.
+	slices.SortFunc(values, func(a, b string) int { return len(b) - len(a) })
+	for _, value := range values {
+		s = strings.ReplaceAll(s, value, RedactedValue)
+	}
+	return string(MaskInstallLog([]byte(s)))
+}
+
+// RedactingWriter masks secrets in everything written to it. A secret may be split across writes of command
+// output, the end of a write that starts a registered secret is held back until the next write or Flush.
+// Writes ending a line are never held back, so lines of the logger are written as they come.
+type RedactingWriter struct {
+	mu      sync.Mutex
+	w       io.Writer
+	pending string
+}
+
+// NewRedactingWriter wraps w so that secrets are masked in everything written to it. Used as output of the
+// standard logger, so that command output logged by runCommand doesn't leak secrets either.
+func NewRedactingWriter(w io.Writer) *RedactingWriter {
+	return &RedactingWriter{w: w}
+}
+
+func (r *RedactingWriter) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := Redact(r.pending + string(p))
+	r.pending = ""
+	if !strings.HasSuffix(s, "\n") {
+		n := partialSecretLen(s)
+		s, r.pending = s[:len(s)-n], s[len(s)-n:]
+	}
+	if _, err := io.WriteString(r.w, s); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
+
+// Flush writes what was held back as a possible start of a secret.
+func (r *RedactingWriter) Flush() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	_, err := io.WriteString(r.w, r.pending)
+	r.pending = ""
+	return err
+}
+
+// partialSecretLen returns the length of the longest end of s that is the start of a registered secret.
+func partialSecretLen(s string) int {
+	secrets.RLock()
+	defer secrets.RUnlock()
+	longest := 0
+	for value := range secrets.values {
+		for n := min(len(value)-1, len(s)); n > longest; n-- {
+			if strings.HasSuffix(s, value[:n]) {
+				longest = n
+				break
+			}
+		}
+	}
+	return longest
+}
+
+// restrictPermissions makes a file or a directory tree readable only by the current user.
+func restrictPermissions(path string) {
+	err := filepath.Walk(path, func(p string, info os.FileInfo, err error) error {
+		if err != nil {
+			return err
+		}
+		if info.IsDir() {
+			return os.Chmod(p, 0700)
+		}
+		return os.Chmod(p, 0600)
+	})
+	if err != nil && !os.IsNotExist(err) {
+		fmt.Printf("Warning: could not restrict permissions of %v: %v\n", path, err)
+	}
+}
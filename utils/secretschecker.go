@@ -1,6 +1,7 @@
 package utils
 
 import (
+	"context"
 	"log"
 	"net/url"
 	"os"
@@ -24,12 +25,12 @@ func getDomainFromURL(imageURL string) string {
 	return domain
 }
 
-func MustContainerEngineLogin(pullSecretFile, imageUrl, engine string) {
+func MustContainerEngineLogin(ctx context.Context, pullSecretFile, imageUrl, engine string) {
 	registryDomain := getDomainFromURL(imageUrl)
 	baseCmd := engine
 	args := []string{"login", "--authfile", os.ExpandEnv(pullSecretFile), registryDomain}
-	log.Printf("Verifying we can login with %v to: %v", engine, registryDomain)
-	_, _, rc := runCommand(baseCmd, "", args...)
+	logger(ctx).Printf("Verifying we can login with %v to: %v", engine, registryDomain)
+	_, _, rc := runCommand(ctx, baseCmd, "", args...)
 
 	if rc != 0 {
 		panic("Could not login to registry: " + registryDomain)
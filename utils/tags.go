@@ -0,0 +1,131 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"sort"
+	"strings"
+	"time"
+)
+
+// Version of the tool, set at build time with -ldflags "-X github.com/RomanBednar/install-tools/utils.Version=<version>".
+var Version = "dev"
+
+// Tags added to cloud resources of every cluster, so clusters left behind in shared accounts can be traced
+// back to their creator and cleaned up after they expire.
+const (
+	TagOwner          = "owner"
+	TagTeam           = "team"
+	TagExpirationDate = "expirationDate"
+	TagCreatedBy      = "createdBy"
+)
+
+// DefaultExpiresIn is used when expiresIn is not configured, "0" disables the expiration tag.
+const DefaultExpiresIn = "48h"
+
+// parseTags parses additional tags in "key=value,key=value" format.
+func parseTags(s string) (map[string]string, error) {
+	tags := map[string]string{}
+	for _, pair := range strings.Split(s, ",") {
+		pair = strings.TrimSpace(pair)
+		if pair == "" {
+			continue
+		}
+		key, value, ok := strings.Cut(pair, "=")
+		key = strings.TrimSpace(key)
+		if !ok || key == "" {
+			return nil, fmt.Errorf("invalid tag %q, expected key=value", pair)
+		}
+		tags[key] = strings.TrimSpace(value)
+	}
+	return tags, nil
+}
+
+// resolveTags validates tag settings and sets the expiration date from expiresIn, so all resources of one
+// installation get the same date. An expiration date set in the configuration is kept.
+func resolveTags(ctx context.Context, conf *Config) {
+	if _, err := parseTags(conf.Tags); err != nil {
+		panic(err)
+	}
+	if conf.ExpirationDate != "" {
+		return
+	}
+	if conf.ExpiresIn == "" {
+		conf.ExpiresIn = DefaultExpiresIn
+	}
+	expiresIn, err := time.ParseDuration(conf.ExpiresIn)
+	if err != nil {
+		panic(fmt.Errorf("invalid expiresIn %q: %v", conf.ExpiresIn, err))
+	}
+	if expiresIn <= 0 {
+		return
+	}
+	conf.ExpirationDate = time.Now().UTC().Add(expiresIn).Format(time.RFC3339)
+	logger(ctx).Printf("Cluster resources will be tagged to expire on %v", conf.ExpirationDate)
+}
+
+// ResourceTags returns tags for cloud resources of the cluster, rendered into userTags on AWS and Azure.
+// Owner defaults to the user name, tags with empty values are left out.
+func (c Config) ResourceTags() map[string]string {
+	tags, _ := parseTags(c.Tags)
+	owner := c.Owner
+	if owner == "" {
+		owner = c.UserName
+	}
+	for key, value := range map[string]string{
+		TagOwner:          owner,
+		TagTeam:           c.Team,
+		TagExpirationDate: c.ExpirationDate,
+		TagCreatedBy:      "install-tools-" + Version,
+	} {
+		if value != "" {
+			tags[key] = value
+		}
+	}
+	for key, value := range tags {
+		if value == "" {
+			delete(tags, key)
+		}
+	}
+	return tags
+}
+
+// ResourceLabels returns ResourceTags converted to GCP label rules: lowercase letters, digits, '_' and '-',
+// at most 63 characters, keys starting with a letter.
+func (c Config) ResourceLabels() map[string]string {
+	labels := map[string]string{}
+	for key, value := range c.ResourceTags() {
+		key = gcpLabel(key)
+		if key == "" || key[0] < 'a' || key[0] > 'z' {
+			key = "tag-" + key
+		}
+		labels[key] = gcpLabel(value)
+	}
+	return labels
+}
+
+func gcpLabel(s string) string {
+	var result strings.Builder
+	for _, char := range strings.ToLower(s) {
+		if (char >= 'a' && char <= 'z') || (char >= '0' && char <= '9') || char == '_' || char == '-' {
+			result.WriteRune(char)
+		} else {
+			result.WriteRune('_')
+		}
+	}
+	label := result.String()
+	if len(label) > 63 {
+		label = label[:63]
+	}
+	return label
+}
+
+// ccoctlUserTags formats ResourceTags for the ccoctl --user-tags flag.
+func ccoctlUserTags(c *Config) string {
+	var pairs []string
+	for key, value := range c.ResourceTags() {
+		pairs = append(pairs, key+"="+value)
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ",")
+}
@@ -0,0 +1,346 @@
+package utils
+
+import (
+	"fmt"
+	"slices"
+	"strconv"
+	"strings"
+)
+
+// Topologies set default replicas of the control plane and compute pools.
+const (
+	TopologyHA      = "ha"      // 3 control plane nodes and 3 workers
+	TopologyCompact = "compact" // 3 schedulable control plane nodes, no workers
+	TopologySNO     = "sno"     // single node
+)
+
+// Topologies are valid values of --topology.
+var Topologies = []string{TopologyHA, TopologyCompact, TopologySNO}
+
+// edgePoolName is the only compute pool besides worker openshift-install accepts, other pools are created
+// as MachineSets after installation.
+const edgePoolName = "edge"
+
+// defaultInstanceTypes are rendered when no instance type is configured, keyed by cloud or platform and then by
+// architecture. Missing entries leave the type to openshift-install.
+var defaultInstanceTypes = map[string]map[string]struct{ controlPlane, compute string }{
+	"aws":     {ArchARM64: {"m6g.xlarge", "m6g.large"}},
+	"aws-odf": {ArchAMD64: {"m6i.4xlarge", "m6i.4xlarge"}, ArchARM64: {"m6g.4xlarge", "m6g.4xlarge"}},
+	"azure":   {ArchARM64: {"Standard_D8ps_v5", "Standard_D4ps_v5"}},
+	"gcp":     {ArchARM64: {"t2a-standard-4", "t2a-standard-4"}},
+}
+
+// volumeTypes are root volume types of a platform.
+var volumeTypes = map[string][]string{
+	"aws":   {"gp2", "gp3", "io1", "io2", "st1", "sc1", "standard"},
+	"gcp":   {"pd-standard", "pd-ssd", "pd-balanced", "hyperdisk-balanced"},
+	"azure": {"Standard_LRS", "StandardSSD_LRS", "Premium_LRS", "PremiumV2_LRS"},
+}
+
+// topologyPlatforms support the topology settings, other platforms keep the pools of their template.
+var topologyPlatforms = []string{"aws", "gcp", "azure", "vsphere"}
+
+// MachinePool is a pool of machines of the cluster. Empty or zero values are left to openshift-install.
+type MachinePool struct {
+	Name     string
+	Replicas int
+	Arch     string
+	// Type is the instance type, on vSphere <cpus>x<memory GiB>, e.g. 8x32.
+	Type       string
+	VolumeSize int // root volume size in GiB
+	VolumeType string
+	Zones      []string
+}
+
+// Platform returns the platform of the cloud, e.g. "aws" for aws-sts. Agent-based installations use the
+// configured agent platform.
+func (c Config) Platform() string {
+	if c.Cloud == agentCloud {
+		return c.AgentPlatformName()
+	}
+	return platformOf(c.Cloud)
+}
+
+func (c Config) topology() string {
+	if c.Topology == "" {
+		return TopologyHA
+	}
+	return c.Topology
+}
+
+func defaultInstanceType(cloud, arch string, controlPlane bool) string {
+	types, ok := defaultInstanceTypes[cloud]
+	if !ok {
+		types = defaultInstanceTypes[platformOf(cloud)]
+	}
+	if controlPlane {
+		return types[arch].controlPlane
+	}
+	return types[arch].compute
+}
+
+// replicas returns value parsed as a replica count, or def if it's not set.
+func replicas(value string, def int) int {
+	if n, err := strconv.Atoi(value); err == nil {
+		return n
+	}
+	return def
+}
+
+// ControlPlanePool returns the control plane pool rendered into install-config.yaml.
+func (c Config) ControlPlanePool() MachinePool {
+	def := 3
+	if c.topology() == TopologySNO {
+		def = 1
+	}
+	pool := MachinePool{
+		Name:       "master",
+		Replicas:   replicas(c.ControlPlaneReplicas, def),
+		Arch:       c.ControlPlaneArchitecture(),
+		Type:       c.ControlPlaneType,
+		VolumeSize: replicas(c.ControlPlaneVolumeSize, 0),
+		VolumeType: c.ControlPlaneVolumeType,
+	}
+	if pool.Type == "" {
+		pool.Type = defaultInstanceType(c.Cloud, pool.Arch, true)
+	}
+	return pool
+}
+
+// ComputePools returns compute pools rendered into install-config.yaml: the worker pool and an edge pool if configured.
+func (c Config) ComputePools() []MachinePool {
+	def := 3
+	if c.topology() != TopologyHA {
+		def = 0
+	}
+	worker := MachinePool{
+		Name:       "worker",
+		Replicas:   replicas(c.ComputeReplicas, def),
+		Arch:       c.ComputeArchitecture(),
+		Type:       c.ComputeType,
+		VolumeSize: replicas(c.ComputeVolumeSize, 0),
+		VolumeType: c.ComputeVolumeType,
+	}
+	if worker.Type == "" {
+		worker.Type = defaultInstanceType(c.Cloud, worker.Arch, false)
+	}
+	pools := []MachinePool{worker}
+	extra, _ := parseMachinePools(c.MachinePools)
+	for _, p := range extra {
+		if p.Name == edgePoolName {
+			p.Arch = worker.Arch
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
+// PostInstallPools returns extra pools created as MachineSets once the cluster is installed, e.g. infra.
+func (c Config) PostInstallPools() []MachinePool {
+	extra, _ := parseMachinePools(c.MachinePools)
+	var pools []MachinePool
+	for _, p := range extra {
+		if p.Name != edgePoolName {
+			pools = append(pools, p)
+		}
+	}
+	return pools
+}
+
+// parseMachinePools parses extra pools given as "<name>:replicas=3,type=m6i.2xlarge;<name>:...". Pools accept
+// replicas, type, volumeSize, volumeType and zones separated by '+'.
+func parseMachinePools(s string) ([]MachinePool, error) {
+	var pools []MachinePool
+	for _, spec := range strings.Split(s, ";") {
+		spec = strings.TrimSpace(spec)
+		if spec == "" {
+			continue
+		}
+		name, settings, _ := strings.Cut(spec, ":")
+		pool := MachinePool{Name: strings.TrimSpace(name), Replicas: 1}
+		if err := ValidateDNSLabel(pool.Name); err != nil {
+			return nil, fmt.Errorf("invalid machine pool name %q: %v", pool.Name, err)
+		}
+		if pool.Name == "worker" || pool.Name == "master" {
+			return nil, fmt.Errorf("machine pool %q is configured with the compute and control plane settings", pool.Name)
+		}
+		for _, setting := range strings.Split(settings, ",") {
+			setting = strings.TrimSpace(setting)
+			if setting == "" {
+				continue
+			}
+			key, value, ok := strings.Cut(setting, "=")
+			if !ok {
+				return nil, fmt.Errorf("invalid setting %q of machine pool %v, expected key=value", setting, pool.Name)
+			}
+			var err error
+			switch key {
+			case "replicas":
+				pool.Replicas, err = strconv.Atoi(value)
+			case "type":
+				pool.Type = value
+			case "volumeSize":
+				pool.VolumeSize, err = strconv.Atoi(value)
+			case "volumeType":
+				pool.VolumeType = value
+			case "zones":
+				pool.Zones = strings.Split(value, "+")
+			default:
+				return nil, fmt.Errorf("unknown setting %q of machine pool %v", key, pool.Name)
+			}
+			if err != nil {
+				return nil, fmt.Errorf("invalid %s of machine pool %v: %v", key, pool.Name, err)
+			}
+		}
+		if slices.ContainsFunc(pools, func(p MachinePool) bool { return p.Name == pool.Name }) {
+			return nil, fmt.Errorf("duplicate machine pool %q", pool.Name)
+		}
+		pools = append(pools, pool)
+	}
+	return pools, nil
+}
+
+// vsphereMachineSize parses a vSphere instance type <cpus>x<memory GiB>.
+func vsphereMachineSize(instanceType string) (cpus, memoryGiB int, err error) {
+	c, m, ok := strings.Cut(instanceType, "x")
+	if ok {
+		cpus, err = strconv.Atoi(c)
+		if err == nil {
+			memoryGiB, err = strconv.Atoi(m)
+		}
+	}
+	if !ok || err != nil || cpus <= 0 || memoryGiB <= 0 {
+		return 0, 0, fmt.Errorf("invalid vSphere machine size %q, expected <cpus>x<memory GiB>, e.g. 8x32", instanceType)
+	}
+	return cpus, memoryGiB, nil
+}
+
+// poolPlatform renders the platform section of a machine pool as a YAML flow mapping.
+func poolPlatform(platform string, pool MachinePool) string {
+	var fields []string
+	add := func(key string, value any) {
+		if s, ok := value.(string); ok {
+			value = yamlQuote(s)
+		}
+		fields = append(fields, fmt.Sprintf("%s: %v", key, value))
+	}
+
+	if platform == "vsphere" {
+		if cpus, memory, err := vsphereMachineSize(pool.Type); err == nil {
+			add("cpus", cpus)
+			add("coresPerSocket", cpus)
+			add("memoryMB", memory*1024)
+		}
+		if pool.VolumeSize > 0 {
+			fields = append(fields, fmt.Sprintf("osDisk: {diskSizeGB: %d}", pool.VolumeSize))
+		}
+	} else {
+		if pool.Type != "" {
+			add("type", pool.Type)
+		}
+		diskKey, sizeKey, typeKey := "osDisk", "diskSizeGB", "diskType"
+		if platform == "aws" {
+			diskKey, sizeKey, typeKey = "rootVolume", "size", "type"
+		}
+		var disk []string
+		if pool.VolumeSize > 0 {
+			disk = append(disk, fmt.Sprintf("%s: %d", sizeKey, pool.VolumeSize))
+		}
+		if pool.VolumeType != "" {
+			disk = append(disk, fmt.Sprintf("%s: %s", typeKey, yamlQuote(pool.VolumeType)))
+		}
+		if len(disk) > 0 {
+			fields = append(fields, fmt.Sprintf("%s: {%s}", diskKey, strings.Join(disk, ", ")))
+		}
+		if len(pool.Zones) > 0 {
+			quoted := make([]string, len(pool.Zones))
+			for i, z := range pool.Zones {
+				quoted[i] = yamlQuote(z)
+			}
+			fields = append(fields, fmt.Sprintf("zones: [%s]", strings.Join(quoted, ", ")))
+		}
+	}
+	if len(fields) == 0 {
+		return "{}"
+	}
+	return fmt.Sprintf("{%s: {%s}}", platform, strings.Join(fields, ", "))
+}
+
+// ValidateTopology checks topology and machine pool settings.
+func ValidateTopology(conf *Config) error {
+	if conf.Topology != "" && !slices.Contains(Topologies, conf.Topology) {
+		return fmt.Errorf("invalid topology %q, valid values are: %s", conf.Topology, strings.Join(Topologies, ", "))
+	}
+	counts := []struct{ key, value string }{
+		{"controlPlaneReplicas", conf.ControlPlaneReplicas},
+		{"computeReplicas", conf.ComputeReplicas},
+		{"controlPlaneVolumeSize", conf.ControlPlaneVolumeSize},
+		{"computeVolumeSize", conf.ComputeVolumeSize},
+	}
+	for _, c := range counts {
+		if n, err := strconv.Atoi(c.value); c.value != "" && (err != nil || n < 0) {
+			return fmt.Errorf("%s must be a non-negative number, got %q", c.key, c.value)
+		}
+	}
+	extra, err := parseMachinePools(conf.MachinePools)
+	if err != nil {
+		return err
+	}
+
+	platform := conf.Platform()
+	settings := conf.Topology != "" || conf.MachinePools != ""
+	for _, v := range []string{conf.ControlPlaneReplicas, conf.ComputeReplicas, conf.ControlPlaneType, conf.ComputeType,
+		conf.ControlPlaneVolumeSize, conf.ComputeVolumeSize, conf.ControlPlaneVolumeType, conf.ComputeVolumeType} {
+		settings = settings || v != ""
+	}
+	if !slices.Contains(topologyPlatforms, platform) {
+		if settings {
+			return fmt.Errorf("cloud %v does not support topology and machine pool settings", conf.Cloud)
+		}
+		return nil
+	}
+
+	controlPlane, compute := conf.ControlPlanePool(), conf.ComputePools()[0]
+	switch conf.topology() {
+	case TopologySNO:
+		if platform == "vsphere" {
+			return fmt.Errorf("topology %s is not supported on vSphere", TopologySNO)
+		}
+		if controlPlane.Replicas != 1 || compute.Replicas != 0 {
+			return fmt.Errorf("topology %s requires 1 control plane replica and 0 compute replicas", TopologySNO)
+		}
+	case TopologyCompact:
+		if controlPlane.Replicas != 3 || compute.Replicas != 0 {
+			return fmt.Errorf("topology %s requires 3 control plane replicas and 0 compute replicas", TopologyCompact)
+		}
+	default:
+		if controlPlane.Replicas < 3 || controlPlane.Replicas > 5 {
+			return fmt.Errorf("controlPlaneReplicas must be between 3 and 5, use topology %s for a single node", TopologySNO)
+		}
+	}
+
+	for _, p := range append([]MachinePool{controlPlane}, append(conf.ComputePools(), extra...)...) {
+		if p.Name == edgePoolName && (platform != "aws" || len(p.Zones) == 0) {
+			return fmt.Errorf("machine pool %s requires AWS and zones of Local or Wavelength Zones", edgePoolName)
+		}
+		if p.Replicas < 0 || p.VolumeSize < 0 {
+			return fmt.Errorf("machine pool %v has negative replicas or volume size", p.Name)
+		}
+		if platform == "vsphere" {
+			if p.Type != "" {
+				if _, _, err := vsphereMachineSize(p.Type); err != nil {
+					return fmt.Errorf("machine pool %v: %v", p.Name, err)
+				}
+			}
+			if p.VolumeType != "" || len(p.Zones) > 0 {
+				return fmt.Errorf("machine pool %v: volume type and zones are not supported on vSphere", p.Name)
+			}
+			continue
+		}
+		if p.VolumeType != "" && !slices.Contains(volumeTypes[platform], p.VolumeType) {
+			return fmt.Errorf("machine pool %v: invalid %s volume type %q, valid values are: %s",
+				p.Name, platform, p.VolumeType, strings.Join(volumeTypes[platform], ", "))
+		}
+	}
+	return nil
+}
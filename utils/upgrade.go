@@ -0,0 +1,354 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"time"
+
+	"gopkg.in/yaml.v3"
+)
+
+const (
+	// DefaultUpgradeTimeout is how long UpgradeCluster waits for the ClusterVersion rollout to complete.
+	DefaultUpgradeTimeout = 3 * time.Hour
+
+	upgradeInterval = 30 * time.Second
+	// upgradeFile is the record of an upgrade kept in its history entry.
+	upgradeFile = "upgrade.yaml"
+)
+
+var (
+	// upgradeAcceptTimeout is how long the cluster version operator gets to accept the target release. It
+	// downloads and verifies the payload first, a release it refuses never becomes the desired one. Tests
+	// shorten it.
+	upgradeAcceptTimeout = 10 * time.Minute
+
+	// gaVersionPattern matches versions published to quay.io, including candidates like 4.17.0-rc.1.
+	gaVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+(-(ec|fc|rc)\.\d+)?$`)
+	// ciVersionPattern matches nightly and CI builds, published to registry.ci.openshift.org only.
+	ciVersionPattern = regexp.MustCompile(`^\d+\.\d+\.\d+-0\.(nightly|ci)`)
+	// releaseArchitectureSuffix is how quay.io tags name the architecture of a release.
+	releaseArchitectureSuffix = map[string]string{
+		ArchAMD64:   "x86_64",
+		ArchARM64:   "aarch64",
+		ArchPPC64LE: "ppc64le",
+		ArchMulti:   "multi",
+	}
+)
+
+// UpgradeOptions select the release UpgradeCluster moves a cluster to: either an explicit release image, or a
+// channel and optionally a version within it.
+type UpgradeOptions struct {
+	// ToImage is a pull spec or a version of the target release.
+	ToImage string
+	// Channel is set on the cluster before upgrading, the current channel is kept if empty.
+	Channel string
+	// Version is the target version from the available updates, the latest one if empty.
+	Version              string
+	AllowExplicitUpgrade bool
+	Force                bool
+	Timeout              time.Duration
+}
+
+func (o UpgradeOptions) validate() error {
+	switch {
+	case o.ToImage != "" && (o.Channel != "" || o.Version != ""):
+		return fmt.Errorf("an explicit image can't be combined with a channel or version")
+	case o.ToImage == "" && o.Channel == "" && o.Version == "":
+		return fmt.Errorf("an image, a channel or a version to upgrade to is required")
+	case o.AllowExplicitUpgrade && o.ToImage == "":
+		return fmt.Errorf("explicit upgrades need an image to upgrade to")
+	}
+	return nil
+}
+
+// upgradeArgs returns arguments of oc adm upgrade to the target.
+func (o UpgradeOptions) upgradeArgs(image string) []string {
+	args := []string{"adm", "upgrade"}
+	switch {
+	case image != "":
+		args = append(args, "--to-image", image)
+	case o.Version != "":
+		args = append(args, "--to", o.Version)
+	default:
+		args = append(args, "--to-latest")
+	}
+	if o.AllowExplicitUpgrade {
+		args = append(args, "--allow-explicit-upgrade")
+	}
+	if o.Force {
+		args = append(args, "--force")
+	}
+	return args
+}
+
+// releaseImageForVersion returns the pull spec of a published release of the architecture.
+func releaseImageForVersion(version, arch string) (string, error) {
+	switch {
+	case ciVersionPattern.MatchString(version):
+		if arch == ArchAMD64 {
+			return "registry.ci.openshift.org/ocp/release:" + version, nil
+		}
+		return fmt.Sprintf("registry.ci.openshift.org/ocp-%s/release-%s:%s", arch, arch, version), nil
+	case gaVersionPattern.MatchString(version):
+		suffix, ok := releaseArchitectureSuffix[arch]
+		if !ok {
+			return "", fmt.Errorf("releases of architecture %q are not known, give the pull spec of version %v", arch, version)
+		}
+		return fmt.Sprintf("quay.io/openshift-release-dev/ocp-release:%s-%s", version, suffix), nil
+	default:
+		return "", fmt.Errorf("can't find the release image of version %q, give its pull spec instead", version)
+	}
+}
+
+// isPullSpec tells pull specs from versions, a pull spec always names a repository.
+func isPullSpec(image string) bool {
+	return strings.ContainsAny(image, "/@")
+}
+
+// clusterArchitecture returns the architecture of the release running in the cluster.
+func clusterArchitecture(ctx context.Context, e Executor, dir string, cv clusterVersion) (string, error) {
+	if strings.EqualFold(cv.Status.Desired.Architecture, ArchMulti) {
+		return ArchMulti, nil
+	}
+	var nodes struct {
+		Items []struct {
+			Status struct {
+				NodeInfo struct {
+					Architecture string `json:"architecture"`
+				} `json:"nodeInfo"`
+			} `json:"status"`
+		} `json:"items"`
+	}
+	if err := ocGet(ctx, e, dir, "nodes", &nodes); err != nil {
+		return "", err
+	}
+	if len(nodes.Items) == 0 {
+		return "", fmt.Errorf("no nodes found to get the architecture of the cluster")
+	}
+	return nodes.Items[0].Status.NodeInfo.Architecture, nil
+}
+
+// completedVersion returns the newest version the cluster completed the rollout of. The newest history entry
+// is Partial after a failed upgrade, the cluster never fully ran that version.
+func completedVersion(cv clusterVersion) string {
+	for _, h := range cv.Status.History {
+		if h.State == "Completed" {
+			return h.Version
+		}
+	}
+	return ""
+}
+
+// ocRun runs oc against the cluster in dir and returns its standard output.
+func ocRun(ctx context.Context, e Executor, dir string, args ...string) (string, error) {
+	cmd := Command{
+		Name: filepath.Join(dir, "oc"),
+		Args: append([]string{"--kubeconfig", KubeconfigPath(dir)}, args...),
+		Dir:  dir,
+	}
+	logger(ctx).Println("run command:", cmd)
+	out, err := e.Output(ctx, cmd)
+	return strings.TrimSpace(string(out)), err
+}
+
+// UpgradeCluster upgrades the cluster installed into outputDir with oc adm upgrade, using oc and the admin
+// kubeconfig from the output dir. It follows the ClusterVersion rollout until it completes, then verifies
+// health of the cluster. Every started upgrade is recorded in the inventory and history of outputDir.
+func UpgradeCluster(ctx context.Context, e Executor, outputDir string, opts UpgradeOptions) (err error) {
+	if err := opts.validate(); err != nil {
+		return err
+	}
+	if opts.Timeout == 0 {
+		opts.Timeout = DefaultUpgradeTimeout
+	}
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	for _, path := range []string{KubeconfigPath(dir), filepath.Join(dir, "oc")} {
+		if _, err := os.Stat(path); err != nil {
+			return fmt.Errorf("%v not found, was a cluster installed into %v? %w", filepath.Base(path), outputDir, err)
+		}
+	}
+
+	ctx, _, stopStepLog := startStepLog(ctx, dir)
+	defer stopStepLog()
+
+	var entry string
+	record := UpgradeRecord{Channel: opts.Channel, To: opts.Version, Force: opts.Force}
+	defer func() {
+		if entry == "" {
+			return
+		}
+		record.FinishedAt = time.Now().UTC()
+		switch {
+		case err == nil:
+			record.Result = ResultSucceeded
+		case errors.Is(err, ErrCancelled):
+			record.Result = ResultCancelled
+			record.Error = err.Error()
+		default:
+			record.Result = ResultFailed
+			record.Error = err.Error()
+		}
+		writeUpgradeRecord(ctx, dir, entry, record)
+	}()
+	defer func() {
+		if r := recover(); r != nil {
+			if e, ok := r.(error); ok {
+				err = e
+			} else {
+				err = fmt.Errorf("%v", r)
+			}
+			logger(ctx).Printf("Upgrade failed: %v", err)
+		}
+	}()
+
+	var cv clusterVersion
+	if err := ocGet(ctx, e, dir, "clusterversion/version", &cv); err != nil {
+		return err
+	}
+	record.From = completedVersion(cv)
+	if progressing := findCondition(cv.Status.Conditions, "Progressing"); progressing.Status == "True" && !opts.Force {
+		return fmt.Errorf("cluster is still progressing, wait for it or use force: %s", describeCondition(progressing))
+	}
+
+	image := opts.ToImage
+	if image != "" && !isPullSpec(image) {
+		record.To = image
+		arch, err := clusterArchitecture(ctx, e, dir, cv)
+		if err != nil {
+			return err
+		}
+		if image, err = releaseImageForVersion(opts.ToImage, arch); err != nil {
+			return err
+		}
+		logger(ctx).Printf("Upgrading to release image %v of version %v.", image, opts.ToImage)
+	}
+	record.Image = image
+	if (image != "" && image == cv.Status.Desired.Image) || (record.To != "" && record.To == record.From) {
+		return fmt.Errorf("cluster already runs %v", cv.Status.Desired.Version)
+	}
+
+	entry = newHistoryEntry(dir)
+	record.StartedAt = time.Now().UTC()
+	record.Result = ResultRunning
+	writeUpgradeFile(ctx, entry, record)
+	logger(ctx).Printf("Upgrading cluster from %v.", record.From)
+
+	channelChanged := false
+	if opts.Channel != "" && opts.Channel != cv.Spec.Channel {
+		logger(ctx).Printf("Switching channel from %q to %q.", cv.Spec.Channel, opts.Channel)
+		if _, err := ocRun(ctx, e, dir, "adm", "upgrade", "channel", opts.Channel); err != nil {
+			return err
+		}
+		channelChanged = true
+	}
+	if err := startUpgrade(ctx, e, dir, opts.upgradeArgs(image), channelChanged); err != nil {
+		return err
+	}
+
+	desired := waitForUpgrade(ctx, e, dir, cv.Status.Desired.Image, opts.Timeout)
+	record.To = desired.Version
+	record.Image = desired.Image
+	logger(ctx).Printf("Cluster upgraded to %v.", desired.Version)
+
+	return VerifyCluster(ctx, e, dir, DefaultVerifyTimeout)
+}
+
+// startUpgrade runs oc adm upgrade. Available updates of a new channel are retrieved by the cluster version
+// operator in the background, until then oc refuses their versions, so after a channel change it's retried.
+func startUpgrade(ctx context.Context, e Executor, dir string, args []string, channelChanged bool) error {
+	deadline := time.Now().Add(upgradeAcceptTimeout)
+	for {
+		out, err := ocRun(ctx, e, dir, args...)
+		if err == nil {
+			// oc succeeds without starting anything when the channel has no newer release.
+			if strings.Contains(out, "already at the latest") {
+				return fmt.Errorf("no update available: %s", out)
+			}
+			return nil
+		}
+		if errors.Is(err, ErrCancelled) || !channelChanged || !time.Now().Add(upgradeInterval).Before(deadline) {
+			return err
+		}
+		logger(ctx).Printf("Update not available yet, retrying: %v", err)
+		sleepContext(ctx, upgradeInterval)
+	}
+}
+
+// waitForUpgrade follows the ClusterVersion until a release other than previous is desired and its rollout
+// completed, and returns that release. Progress messages of the cluster version operator are logged as they
+// change.
+func waitForUpgrade(ctx context.Context, e Executor, dir, previous string, timeout time.Duration) release {
+	logger(ctx).Printf("Waiting up to %v for the upgrade to complete.", timeout)
+	start := time.Now()
+	deadline := start.Add(timeout)
+	var progress, problem string
+	for {
+		var cv clusterVersion
+		err := ocGet(ctx, e, dir, "clusterversion/version", &cv)
+		switch {
+		case errors.Is(err, ErrCancelled):
+			panic(err)
+		case err != nil:
+			// The API server is restarted during the upgrade, keep trying until the deadline.
+			problem = err.Error()
+		case cv.Status.Desired.Image == previous:
+			problem = "cluster did not accept the release"
+			if accepted := findCondition(cv.Status.Conditions, "ReleaseAccepted"); accepted.Status == "False" {
+				problem += ": " + describeCondition(accepted)
+			}
+			if !time.Now().Before(start.Add(upgradeAcceptTimeout)) {
+				panic(fmt.Errorf("%s within %v", problem, upgradeAcceptTimeout))
+			}
+		default:
+			desired := cv.Status.Desired
+			if len(cv.Status.History) > 0 {
+				latest := cv.Status.History[0]
+				if latest.Image == desired.Image && latest.State == "Completed" {
+					return desired
+				}
+			}
+			problem = fmt.Sprintf("upgrade to %v is not completed", desired.Version)
+			if failing := findCondition(cv.Status.Conditions, "Failing"); failing.Status == "True" {
+				problem += ", " + describeCondition(failing)
+			}
+			if p := findCondition(cv.Status.Conditions, "Progressing"); p.Message != "" && p.Message != progress {
+				progress = p.Message
+				logger(ctx).Printf("Upgrade progress: %v", strings.Join(strings.Fields(progress), " "))
+			}
+		}
+
+		if !time.Now().Add(upgradeInterval).Before(deadline) {
+			panic(fmt.Errorf("upgrade did not complete within %v: %s", timeout, problem))
+		}
+		sleepContext(ctx, upgradeInterval)
+	}
+}
+
+// writeUpgradeFile keeps the upgrade in its history entry, failures are only logged.
+func writeUpgradeFile(ctx context.Context, entry string, record UpgradeRecord) {
+	content, err := yaml.Marshal(record)
+	if err == nil {
+		err = writeFileAtomic(filepath.Join(entry, upgradeFile), []byte(Redact(string(content))), 0600)
+	}
+	if err != nil {
+		logger(ctx).Printf("Warning: could not write %v: %v", upgradeFile, err)
+	}
+}
+
+// writeUpgradeRecord keeps the finished upgrade in its history entry and the inventory. The upgrade itself is
+// done, failures are only logged.
+func writeUpgradeRecord(ctx context.Context, dir, entry string, record UpgradeRecord) {
+	writeUpgradeFile(ctx, entry, record)
+	if err := recordUpgrade(dir, record); err != nil {
+		logger(ctx).Printf("Warning: could not record upgrade in inventory: %v", err)
+	}
+}
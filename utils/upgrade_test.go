@@ -0,0 +1,300 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"slices"
+	"strings"
+	"testing"
+	"time"
+)
+
+const (
+	upgradeTestImage   = "quay.io/openshift-release-dev/ocp-release:4.17.2-x86_64"
+	upgradeTestCurrent = "quay.io/openshift-release-dev/ocp-release:4.17.1-x86_64"
+)
+
+// upgradeExecutor replies to oc like a cluster being upgraded: `oc get clusterversion/version` returns
+// testdata/upgrade/before.json until `oc adm upgrade` starts the upgrade and <after>.json since. Cluster
+// operators are the healthy ones of the verify tests.
+type upgradeExecutor struct {
+	after string
+	// upgradeOutput and upgradeErr are the result of oc adm upgrade.
+	upgradeOutput string
+	upgradeErr    error
+	started       bool
+	calls         []Command
+}
+
+func (f *upgradeExecutor) Output(_ context.Context, cmd Command) ([]byte, error) {
+	f.calls = append(f.calls, cmd)
+	args := cmd.Args[2:] // --kubeconfig <path>
+	switch {
+	case slices.Equal(args, []string{"get", "clusterversion/version", "-o", "json"}):
+		file := "before"
+		if f.started {
+			file = f.after
+		}
+		return os.ReadFile(filepath.Join("testdata", "upgrade", file+".json"))
+	case slices.Equal(args, []string{"get", "nodes", "-o", "json"}):
+		return os.ReadFile(filepath.Join("testdata", "upgrade", "nodes.json"))
+	case slices.Equal(args, []string{"get", "clusteroperators", "-o", "json"}):
+		return os.ReadFile(filepath.Join("testdata", "verify", "healthy", "clusteroperators.json"))
+	case len(args) > 2 && args[0] == "adm" && args[1] == "upgrade" && args[2] == "channel":
+		return nil, nil
+	case len(args) > 1 && args[0] == "adm" && args[1] == "upgrade":
+		f.started = f.upgradeErr == nil && !strings.Contains(f.upgradeOutput, "already at the latest")
+		return []byte(f.upgradeOutput), f.upgradeErr
+	}
+	return nil, errors.New("unexpected command: " + cmd.String())
+}
+
+// upgradeCommands returns the oc adm upgrade commands run, without the kubeconfig.
+func (f *upgradeExecutor) upgradeCommands() [][]string {
+	var commands [][]string
+	for _, c := range f.calls {
+		if args := c.Args[2:]; len(args) > 1 && args[0] == "adm" {
+			commands = append(commands, args)
+		}
+	}
+	return commands
+}
+
+// upgradeTestDir returns an output dir with oc and the kubeconfig UpgradeCluster looks for.
+func upgradeTestDir(t *testing.T) string {
+	t.Helper()
+	dir := t.TempDir()
+	for _, path := range []string{KubeconfigPath(dir), filepath.Join(dir, "oc")} {
+		if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+			t.Fatal(err)
+		}
+		if err := os.WriteFile(path, nil, 0600); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return dir
+}
+
+func TestReleaseImageForVersion(t *testing.T) {
+	tests := []struct {
+		version string
+		arch    string
+		want    string
+		err     string
+	}{
+		{version: "4.17.2", arch: ArchAMD64, want: "quay.io/openshift-release-dev/ocp-release:4.17.2-x86_64"},
+		{version: "4.17.0-rc.1", arch: ArchARM64, want: "quay.io/openshift-release-dev/ocp-release:4.17.0-rc.1-aarch64"},
+		{version: "4.18.0-ec.2", arch: ArchMulti, want: "quay.io/openshift-release-dev/ocp-release:4.18.0-ec.2-multi"},
+		{version: "4.17.0-0.nightly-2024-10-20-012345", arch: ArchAMD64, want: "registry.ci.openshift.org/ocp/release:4.17.0-0.nightly-2024-10-20-012345"},
+		{version: "4.17.0-0.nightly-2024-10-20-012345", arch: ArchARM64, want: "registry.ci.openshift.org/ocp-arm64/release-arm64:4.17.0-0.nightly-2024-10-20-012345"},
+		{version: "4.17.2", arch: "s390x", err: `releases of architecture "s390x" are not known`},
+		{version: "4.17", arch: ArchAMD64, err: `can't find the release image of version "4.17"`},
+	}
+	for _, tt := range tests {
+		got, err := releaseImageForVersion(tt.version, tt.arch)
+		if tt.err != "" {
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Errorf("%s/%s: expected error %q, got %v", tt.version, tt.arch, tt.err, err)
+			}
+			continue
+		}
+		if err != nil || got != tt.want {
+			t.Errorf("%s/%s: expected %v, got %v, error %v", tt.version, tt.arch, tt.want, got, err)
+		}
+	}
+}
+
+func TestUpgradeOptions(t *testing.T) {
+	tests := []struct {
+		name string
+		opts UpgradeOptions
+		args []string
+		err  string
+	}{
+		{
+			name: "explicit image",
+			opts: UpgradeOptions{ToImage: upgradeTestImage, AllowExplicitUpgrade: true, Force: true},
+			args: []string{"adm", "upgrade", "--to-image", upgradeTestImage, "--allow-explicit-upgrade", "--force"},
+		},
+		{name: "version", opts: UpgradeOptions{Version: "4.17.2"}, args: []string{"adm", "upgrade", "--to", "4.17.2"}},
+		{name: "latest of channel", opts: UpgradeOptions{Channel: "fast-4.17"}, args: []string{"adm", "upgrade", "--to-latest"}},
+		{name: "image and channel", opts: UpgradeOptions{ToImage: upgradeTestImage, Channel: "fast-4.17"}, err: "can't be combined"},
+		{name: "image and version", opts: UpgradeOptions{ToImage: upgradeTestImage, Version: "4.17.2"}, err: "can't be combined"},
+		{name: "no target", opts: UpgradeOptions{Force: true}, err: "is required"},
+		{name: "explicit without image", opts: UpgradeOptions{Version: "4.17.2", AllowExplicitUpgrade: true}, err: "need an image"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			err := tt.opts.validate()
+			if tt.err != "" {
+				if err == nil || !strings.Contains(err.Error(), tt.err) {
+					t.Fatalf("expected error %q, got %v", tt.err, err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if args := tt.opts.upgradeArgs(tt.opts.ToImage); !reflect.DeepEqual(args, tt.args) {
+				t.Errorf("unexpected arguments %v", args)
+			}
+		})
+	}
+}
+
+func TestUpgradeClusterAccepted(t *testing.T) {
+	tests := []struct {
+		name     string
+		opts     UpgradeOptions
+		commands [][]string
+		to       string
+	}{
+		{
+			name:     "explicit image",
+			opts:     UpgradeOptions{ToImage: upgradeTestImage, AllowExplicitUpgrade: true},
+			commands: [][]string{{"adm", "upgrade", "--to-image", upgradeTestImage, "--allow-explicit-upgrade"}},
+		},
+		{
+			name:     "version as image",
+			opts:     UpgradeOptions{ToImage: "4.17.2"},
+			commands: [][]string{{"adm", "upgrade", "--to-image", upgradeTestImage}},
+			to:       "4.17.2",
+		},
+		{
+			name: "version of another channel",
+			opts: UpgradeOptions{Channel: "fast-4.17", Version: "4.17.2"},
+			commands: [][]string{
+				{"adm", "upgrade", "channel", "fast-4.17"},
+				{"adm", "upgrade", "--to", "4.17.2"},
+			},
+			to: "4.17.2",
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := upgradeTestDir(t)
+			e := &upgradeExecutor{after: "accepted"}
+			if err := UpgradeCluster(context.Background(), e, dir, tt.opts); err != nil {
+				t.Fatal(err)
+			}
+			if got := e.upgradeCommands(); !reflect.DeepEqual(got, tt.commands) {
+				t.Errorf("unexpected commands %v", got)
+			}
+
+			inv, err := ReadInventory(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(inv.Upgrades) != 1 {
+				t.Fatalf("expected one upgrade in the inventory, got %#v", inv.Upgrades)
+			}
+			record := inv.Upgrades[0]
+			// The newest history entry of the cluster is a failed upgrade to 4.17.1.
+			if record.From != "4.17.0" || record.To != "4.17.2" || record.Image != upgradeTestImage || record.Result != ResultSucceeded {
+				t.Errorf("unexpected upgrade record %#v", record)
+			}
+			if inv.Version != "4.17.2" || inv.Image != upgradeTestImage {
+				t.Errorf("inventory does not follow the upgrade: %v %v", inv.Version, inv.Image)
+			}
+
+			entries, err := History(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(entries) != 1 || !entries[0].Upgrade {
+				t.Errorf("expected one upgrade in history, got %#v", entries)
+			}
+		})
+	}
+}
+
+func TestUpgradeClusterRejected(t *testing.T) {
+	accept := upgradeAcceptTimeout
+	upgradeAcceptTimeout = 0
+	defer func() { upgradeAcceptTimeout = accept }()
+
+	dir := upgradeTestDir(t)
+	err := UpgradeCluster(context.Background(), &upgradeExecutor{after: "rejected"}, dir, UpgradeOptions{ToImage: upgradeTestImage})
+	want := "cluster did not accept the release: ReleaseAccepted=False (RetrievePayload): Retrieving payload failed"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+	inv, err := ReadInventory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Upgrades) != 1 || inv.Upgrades[0].Result != ResultFailed || !strings.Contains(inv.Upgrades[0].Error, want) {
+		t.Errorf("unexpected upgrade records %#v", inv.Upgrades)
+	}
+	if inv.Version != "4.17.0" {
+		t.Errorf("failed upgrade changed the installed version to %v", inv.Version)
+	}
+}
+
+func TestUpgradeClusterTimedOut(t *testing.T) {
+	dir := upgradeTestDir(t)
+	opts := UpgradeOptions{ToImage: upgradeTestImage, Timeout: time.Nanosecond}
+	err := UpgradeCluster(context.Background(), &upgradeExecutor{after: "progressing"}, dir, opts)
+	want := "upgrade did not complete within 1ns: upgrade to 4.17.2 is not completed"
+	if err == nil || !strings.Contains(err.Error(), want) {
+		t.Fatalf("expected error %q, got %v", want, err)
+	}
+	inv, err := ReadInventory(dir)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(inv.Upgrades) != 1 || inv.Upgrades[0].Result != ResultFailed {
+		t.Errorf("unexpected upgrade records %#v", inv.Upgrades)
+	}
+}
+
+func TestUpgradeClusterNotStarted(t *testing.T) {
+	tests := []struct {
+		name string
+		e    *upgradeExecutor
+		opts UpgradeOptions
+		err  string
+		// recorded is true if the upgrade failed after it was recorded as started.
+		recorded bool
+	}{
+		{
+			name: "current release",
+			e:    &upgradeExecutor{},
+			opts: UpgradeOptions{ToImage: upgradeTestCurrent},
+			err:  "cluster already runs 4.17.1",
+		},
+		{
+			name:     "no update in channel",
+			e:        &upgradeExecutor{upgradeOutput: "info: Cluster is already at the latest available version 4.17.1"},
+			opts:     UpgradeOptions{Channel: "stable-4.17"},
+			err:      "no update available",
+			recorded: true,
+		},
+		{
+			name:     "refused by oc",
+			e:        &upgradeExecutor{upgradeErr: errors.New("error: the update 4.17.9 is not one of the available updates")},
+			opts:     UpgradeOptions{Version: "4.17.9"},
+			err:      "not one of the available updates",
+			recorded: true,
+		},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			dir := upgradeTestDir(t)
+			err := UpgradeCluster(context.Background(), tt.e, dir, tt.opts)
+			if err == nil || !strings.Contains(err.Error(), tt.err) {
+				t.Fatalf("expected error %q, got %v", tt.err, err)
+			}
+			inv, err := ReadInventory(dir)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if recorded := len(inv.Upgrades) > 0; recorded != tt.recorded {
+				t.Errorf("unexpected upgrade records %#v", inv.Upgrades)
+			}
+		})
+	}
+}
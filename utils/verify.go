@@ -0,0 +1,205 @@
+package utils
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+const (
+	// DefaultVerifyTimeout is how long VerifyCluster waits for a new cluster to settle.
+	DefaultVerifyTimeout = 30 * time.Minute
+
+	verifyInterval = 30 * time.Second
+)
+
+type condition struct {
+	Type    string `json:"type"`
+	Status  string `json:"status"`
+	Reason  string `json:"reason"`
+	Message string `json:"message"`
+}
+
+// resourceList is the part of `oc get <resource> -o json` output needed to check conditions.
+type resourceList struct {
+	Items []struct {
+		Metadata struct {
+			Name string `json:"name"`
+		} `json:"metadata"`
+		Status struct {
+			Conditions []condition `json:"conditions"`
+		} `json:"status"`
+	} `json:"items"`
+}
+
+// release is a release payload as ClusterVersion refers to it.
+type release struct {
+	Version      string `json:"version"`
+	Image        string `json:"image"`
+	Architecture string `json:"architecture"`
+}
+
+type clusterVersion struct {
+	Spec struct {
+		Channel string `json:"channel"`
+	} `json:"spec"`
+	Status struct {
+		Desired    release     `json:"desired"`
+		Conditions []condition `json:"conditions"`
+		History    []struct {
+			State   string `json:"state"`
+			Version string `json:"version"`
+			Image   string `json:"image"`
+		} `json:"history"`
+	} `json:"status"`
+}
+
+// ClusterHealth is the result of one health check, the cluster is healthy when there are no problems.
+type ClusterHealth struct {
+	Version  string
+	Problems []string
+}
+
+func (h ClusterHealth) Healthy() bool {
+	return len(h.Problems) == 0
+}
+
+func findCondition(conditions []condition, conditionType string) condition {
+	for _, c := range conditions {
+		if c.Type == conditionType {
+			return c
+		}
+	}
+	return condition{Type: conditionType, Status: "Unknown"}
+}
+
+// describeCondition formats a condition for the problem summary, e.g. "Degraded=True (Reason): message".
+func describeCondition(c condition) string {
+	s := c.Type + "=" + c.Status
+	if c.Reason != "" {
+		s += " (" + c.Reason + ")"
+	}
+	if c.Message != "" {
+		s += ": " + strings.Join(strings.Fields(c.Message), " ")
+	}
+	return s
+}
+
+// ocGet runs `oc get` against the cluster in outputDir using the oc extracted there and the admin kubeconfig.
+func ocGet(ctx context.Context, e Executor, outputDir string, resource string, into any) error {
+	return ocGetNamespaced(ctx, e, outputDir, "", resource, into)
+}
+
+// ocGetNamespaced is ocGet for resources in a namespace.
+func ocGetNamespaced(ctx context.Context, e Executor, outputDir, namespace, resource string, into any) error {
+	dir, err := filepath.Abs(outputDir)
+	if err != nil {
+		return err
+	}
+	args := []string{"--kubeconfig", KubeconfigPath(dir), "get", resource}
+	if namespace != "" {
+		args = append(args, "-n", namespace)
+	}
+	out, err := e.Output(ctx, Command{
+		Name: filepath.Join(dir, "oc"),
+		Args: append(args, "-o", "json"),
+		Dir:  dir,
+	})
+	if err != nil {
+		return err
+	}
+	if err := json.Unmarshal(out, into); err != nil {
+		return fmt.Errorf("could not parse %v: %v", resource, err)
+	}
+	return nil
+}
+
+// CheckClusterHealth checks once that every ClusterOperator is Available and not Degraded, every node is Ready
+// and the ClusterVersion rollout is completed.
+func CheckClusterHealth(ctx context.Context, e Executor, outputDir string) (ClusterHealth, error) {
+	var health ClusterHealth
+
+	var operators resourceList
+	if err := ocGet(ctx, e, outputDir, "clusteroperators", &operators); err != nil {
+		return health, err
+	}
+	for _, co := range operators.Items {
+		if available := findCondition(co.Status.Conditions, "Available"); available.Status != "True" {
+			health.Problems = append(health.Problems, fmt.Sprintf("clusteroperator/%s %s", co.Metadata.Name, describeCondition(available)))
+		}
+		if degraded := findCondition(co.Status.Conditions, "Degraded"); degraded.Status == "True" {
+			health.Problems = append(health.Problems, fmt.Sprintf("clusteroperator/%s %s", co.Metadata.Name, describeCondition(degraded)))
+		}
+	}
+	if len(operators.Items) == 0 {
+		health.Problems = append(health.Problems, "no clusteroperators found")
+	}
+
+	var nodes resourceList
+	if err := ocGet(ctx, e, outputDir, "nodes", &nodes); err != nil {
+		return health, err
+	}
+	for _, node := range nodes.Items {
+		if ready := findCondition(node.Status.Conditions, "Ready"); ready.Status != "True" {
+			health.Problems = append(health.Problems, fmt.Sprintf("node/%s %s", node.Metadata.Name, describeCondition(ready)))
+		}
+	}
+	if len(nodes.Items) == 0 {
+		health.Problems = append(health.Problems, "no nodes found")
+	}
+
+	var cv clusterVersion
+	if err := ocGet(ctx, e, outputDir, "clusterversion/version", &cv); err != nil {
+		return health, err
+	}
+	if len(cv.Status.History) == 0 {
+		health.Problems = append(health.Problems, "clusterversion/version has no history")
+	} else {
+		latest := cv.Status.History[0]
+		health.Version = latest.Version
+		if latest.State != "Completed" {
+			problem := fmt.Sprintf("clusterversion/version %s is %s", latest.Version, latest.State)
+			if failing := findCondition(cv.Status.Conditions, "Failing"); failing.Status == "True" {
+				problem += ", " + describeCondition(failing)
+			}
+			health.Problems = append(health.Problems, problem)
+		}
+	}
+	return health, nil
+}
+
+// VerifyCluster waits up to timeout for the cluster in outputDir to become healthy, a zero timeout checks once.
+// The returned error summarises the remaining problems.
+func VerifyCluster(ctx context.Context, e Executor, outputDir string, timeout time.Duration) error {
+	logger(ctx).Printf("Verifying cluster health, waiting up to %v.", timeout)
+	deadline := time.Now().Add(timeout)
+	for {
+		health, err := CheckClusterHealth(ctx, e, outputDir)
+		if errors.Is(err, ErrCancelled) {
+			return err
+		}
+		if err == nil && health.Healthy() {
+			logger(ctx).Printf("Cluster %v is healthy.", health.Version)
+			return nil
+		}
+		problems := health.Problems
+		if err != nil {
+			// The API may be briefly unavailable while operators roll out, keep trying until the deadline.
+			problems = []string{err.Error()}
+		}
+
+		if !time.Now().Add(verifyInterval).Before(deadline) {
+			return fmt.Errorf("cluster is not healthy:\n  %s", strings.Join(problems, "\n  "))
+		}
+		logger(ctx).Printf("Cluster is not healthy yet, %d problem(s), first: %v", len(problems), problems[0])
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %v", ErrCancelled, ctx.Err())
+		case <-time.After(verifyInterval):
+		}
+	}
+}
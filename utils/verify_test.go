@@ -0,0 +1,91 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+// fakeExecutor replies to `oc get <resource>` with output recorded in testdata/verify/<dir>.
+type fakeExecutor struct {
+	dir   string
+	calls []Command
+}
+
+func (f *fakeExecutor) Output(_ context.Context, cmd Command) ([]byte, error) {
+	f.calls = append(f.calls, cmd)
+	files := map[string]string{
+		"clusteroperators":       "clusteroperators.json",
+		"nodes":                  "nodes.json",
+		"clusterversion/version": "clusterversion.json",
+	}
+	for i, arg := range cmd.Args {
+		if arg == "get" && i+1 < len(cmd.Args) {
+			if file, ok := files[cmd.Args[i+1]]; ok {
+				return os.ReadFile(filepath.Join("testdata", "verify", f.dir, file))
+			}
+		}
+	}
+	return nil, errors.New("unexpected command: " + cmd.String())
+}
+
+func TestCheckClusterHealthHealthy(t *testing.T) {
+	e := &fakeExecutor{dir: "healthy"}
+	health, err := CheckClusterHealth(context.Background(), e, "/clusters/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !health.Healthy() {
+		t.Errorf("expected healthy cluster, got problems: %v", health.Problems)
+	}
+	if health.Version != "4.17.0-0.ci-2024-07-25-020703" {
+		t.Errorf("unexpected version %q", health.Version)
+	}
+
+	want := Command{
+		Name: "/clusters/c1/oc",
+		Args: []string{"--kubeconfig", "/clusters/c1/auth/kubeconfig", "get", "clusteroperators", "-o", "json"},
+		Dir:  "/clusters/c1",
+	}
+	if len(e.calls) != 3 || !reflect.DeepEqual(e.calls[0], want) {
+		t.Errorf("unexpected commands: %#v", e.calls)
+	}
+}
+
+func TestCheckClusterHealthDegraded(t *testing.T) {
+	health, err := CheckClusterHealth(context.Background(), &fakeExecutor{dir: "degraded"}, "/clusters/c1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"clusteroperator/storage Available=False (AWSEBSCSIDriverOperatorCR_AWSEBSDriverNodeServiceController_Deploying): " +
+			"AWSEBSCSIDriverOperatorCRAvailable: AWSEBSDriverNodeServiceControllerAvailable: Waiting for the DaemonSet to deploy the CSI Node Service",
+		"clusteroperator/storage Degraded=True (AWSEBSCSIDriverOperatorCR_AWSEBSDriverNodeServiceController_Deploying): " +
+			"AWSEBSCSIDriverOperatorCRDegraded: AWSEBSDriverNodeServiceControllerDegraded: DaemonSet is not available",
+		"node/ip-10-0-56-78.ec2.internal Ready=Unknown (NodeStatusUnknown): Kubelet stopped posting node status.",
+		"clusterversion/version 4.17.0-0.ci-2024-07-25-020703 is Partial, Failing=True (ClusterOperatorDegraded): Cluster operator storage is degraded",
+	}
+	if !reflect.DeepEqual(health.Problems, want) {
+		t.Errorf("unexpected problems:\n%s\nwant:\n%s", strings.Join(health.Problems, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestVerifyCluster(t *testing.T) {
+	if err := VerifyCluster(context.Background(), &fakeExecutor{dir: "healthy"}, "/clusters/c1", 0); err != nil {
+		t.Errorf("expected healthy cluster, got: %v", err)
+	}
+
+	err := VerifyCluster(context.Background(), &fakeExecutor{dir: "degraded"}, "/clusters/c1", 0)
+	if err == nil || !strings.Contains(err.Error(), "node/ip-10-0-56-78.ec2.internal Ready=Unknown") {
+		t.Errorf("expected summary of problems, got: %v", err)
+	}
+
+	err = VerifyCluster(context.Background(), &fakeExecutor{dir: "missing"}, "/clusters/c1", 0)
+	if err == nil || !strings.Contains(err.Error(), "no such file") {
+		t.Errorf("expected error of oc to be reported, got: %v", err)
+	}
+}
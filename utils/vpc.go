@@ -0,0 +1,129 @@
+package utils
+
+import (
+	"fmt"
+	"regexp"
+	"slices"
+	"strings"
+)
+
+var (
+	awsSubnetPattern     = regexp.MustCompile(`^subnet-([0-9a-f]{8}|[0-9a-f]{17})$`)
+	awsHostedZonePattern = regexp.MustCompile(`^Z[0-9A-Z]{1,31}$`)
+	gcpNamePattern       = regexp.MustCompile(`^[a-z]([-a-z0-9]{0,61}[a-z0-9])?$`)
+	gcpProjectPattern    = regexp.MustCompile(`^[a-z][-a-z0-9]{4,28}[a-z0-9]$`)
+)
+
+// vpcKey is a setting of an existing network, valid on one platform only.
+type vpcKey struct {
+	platform string
+	key      string
+	value    string
+}
+
+func (c Config) vpcKeys() []vpcKey {
+	return []vpcKey{
+		{"aws", "awsSubnets", c.AWSSubnets},
+		{"aws", "awsHostedZone", c.AWSHostedZone},
+		{"gcp", "gcpNetwork", c.GCPNetwork},
+		{"gcp", "gcpControlPlaneSubnet", c.GCPControlPlaneSubnet},
+		{"gcp", "gcpComputeSubnet", c.GCPComputeSubnet},
+		{"gcp", "gcpNetworkProject", c.GCPNetworkProject},
+		{"azure", "azureNetworkResourceGroup", c.AzureNetworkResourceGroup},
+		{"azure", "azureVirtualNetwork", c.AzureVirtualNetwork},
+		{"azure", "azureControlPlaneSubnet", c.AzureControlPlaneSubnet},
+		{"azure", "azureComputeSubnet", c.AzureComputeSubnet},
+	}
+}
+
+// AWSSubnetIDs returns IDs of existing subnets the cluster is installed to.
+func (c Config) AWSSubnetIDs() []string {
+	return splitList(c.AWSSubnets)
+}
+
+// ExistingNetwork reports whether the cluster is installed to an existing VPC or virtual network.
+func (c Config) ExistingNetwork() bool {
+	for _, k := range c.vpcKeys() {
+		if k.platform == c.Platform() && k.value != "" {
+			return true
+		}
+	}
+	return false
+}
+
+// ValidateVPC checks that settings of an existing network belong to the platform of the cloud and are complete.
+func ValidateVPC(conf *Config) error {
+	if problems := vpcProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid existing network configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func vpcProblems(conf *Config) []string {
+	var problems []string
+	add := func(format string, args ...interface{}) {
+		problems = append(problems, fmt.Sprintf(format, args...))
+	}
+	platform := conf.Platform()
+	for _, k := range conf.vpcKeys() {
+		if k.value != "" && k.platform != platform {
+			add("%s is only used on %s", k.key, k.platform)
+		}
+	}
+
+	// complete reports missing keys of a group that has to be set together.
+	complete := func(names ...string) {
+		var set, missing []string
+		for _, k := range conf.vpcKeys() {
+			if !slices.Contains(names, k.key) {
+				continue
+			}
+			if k.value != "" {
+				set = append(set, k.key)
+			} else {
+				missing = append(missing, k.key)
+			}
+		}
+		if len(set) > 0 && len(missing) > 0 {
+			add("%s requires %s", strings.Join(set, ", "), strings.Join(missing, ", "))
+		}
+	}
+	switch platform {
+	case "aws":
+		for _, id := range conf.AWSSubnetIDs() {
+			if !awsSubnetPattern.MatchString(id) {
+				add("awsSubnets: %q is not a subnet ID", id)
+			}
+		}
+		if conf.AWSHostedZone != "" {
+			if !awsHostedZonePattern.MatchString(conf.AWSHostedZone) {
+				add("awsHostedZone %q is not a hosted zone ID", conf.AWSHostedZone)
+			}
+			if conf.AWSSubnets == "" {
+				add("awsHostedZone requires awsSubnets")
+			}
+		}
+	case "gcp":
+		complete("gcpNetwork", "gcpControlPlaneSubnet", "gcpComputeSubnet")
+		for _, k := range conf.vpcKeys() {
+			if k.platform == "gcp" && k.key != "gcpNetworkProject" && k.value != "" && !gcpNamePattern.MatchString(k.value) {
+				add("%s %q is not a valid GCP resource name", k.key, k.value)
+			}
+		}
+		if conf.GCPNetworkProject != "" {
+			if conf.GCPNetwork == "" {
+				add("gcpNetworkProject requires gcpNetwork")
+			}
+			if !gcpProjectPattern.MatchString(conf.GCPNetworkProject) {
+				add("gcpNetworkProject %q is not a valid project ID", conf.GCPNetworkProject)
+			}
+		}
+	case "azure":
+		complete("azureNetworkResourceGroup", "azureVirtualNetwork", "azureControlPlaneSubnet", "azureComputeSubnet")
+	}
+	// Private clusters aren't reachable through networks created by the installer.
+	if conf.Networking().Publish == PublishInternal && !conf.ExistingNetwork() {
+		add("publish %s requires an existing network, set the %s network keys", PublishInternal, platform)
+	}
+	return problems
+}
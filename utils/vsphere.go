@@ -0,0 +1,98 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"slices"
+	"strings"
+	"time"
+)
+
+// ValidateVSphereConfig checks that all values needed to render the vSphere template are set.
+// It does not touch the network, so it can be used to validate configuration before it is stored.
+func ValidateVSphereConfig(conf *Config) error {
+	if problems := vsphereConfigProblems(conf); len(problems) > 0 {
+		return fmt.Errorf("invalid vSphere configuration: %s", strings.Join(problems, "; "))
+	}
+	return nil
+}
+
+func vsphereConfigProblems(conf *Config) []string {
+	var problems []string
+	required := []struct{ key, value string }{
+		{"userName", conf.UserName},
+		{"vSphereBaseDomain", conf.VSphereBaseDomain},
+		{"vSphereVCenterSubdomain", conf.VSphereVCenterSubdomain},
+		{"vSphereApiVIP", conf.VSphereApiVIP},
+		{"vSphereIngressVIP", conf.VSphereIngressVIP},
+	}
+	for _, r := range required {
+		if strings.TrimSpace(r.value) == "" {
+			problems = append(problems, fmt.Sprintf("%s is required", r.key))
+		}
+	}
+	for _, r := range required[3:] {
+		for _, vip := range splitList(r.value) {
+			if net.ParseIP(vip) == nil {
+				problems = append(problems, fmt.Sprintf("%s is not a valid IP address: %q", r.key, vip))
+			}
+		}
+	}
+	for _, vip := range conf.VSphereAPIVIPs() {
+		if slices.Contains(conf.VSphereIngressVIPs(), vip) {
+			problems = append(problems, "vSphereApiVIP and vSphereIngressVIP must be different")
+		}
+	}
+	if conf.VSpherePassword == "" && conf.VSpherePasswordFile == "" {
+		problems = append(problems, "vSpherePassword or vSpherePasswordFile is required")
+	}
+	if conf.VSpherePassword == "" && conf.VSpherePasswordFile != "" {
+		if _, err := os.Stat(os.ExpandEnv(conf.VSpherePasswordFile)); err != nil {
+			problems = append(problems, fmt.Sprintf("vSpherePasswordFile can not be read: %v", err))
+		}
+	}
+	return problems
+}
+
+// VSphereAPIVIPs returns the API VIPs, one for single-stack and one of each IP family for dual-stack clusters.
+func (c Config) VSphereAPIVIPs() []string {
+	return splitList(c.VSphereApiVIP)
+}
+
+// VSphereIngressVIPs returns the ingress VIPs, like VSphereAPIVIPs.
+func (c Config) VSphereIngressVIPs() []string {
+	return splitList(c.VSphereIngressVIP)
+}
+
+// vCenterURL returns URL of the vCenter configured for the installation.
+func vCenterURL(conf *Config) string {
+	return fmt.Sprintf("https://%s.%s/", conf.VSphereVCenterSubdomain, conf.VSphereBaseDomain)
+}
+
+// vspherePreflight runs checks that would otherwise make openshift-install fail late: configuration
+// completeness and vCenter reachability, which requires a VPN connection.
+func vspherePreflight(ctx context.Context, conf *Config) {
+	logger(ctx).Println("Running vSphere preflight checks.")
+	if err := ValidateVSphereConfig(conf); err != nil {
+		panic(err)
+	}
+	checkVCenterReachable(vCenterURL(conf))
+}
+
+func checkVCenterReachable(url string) {
+	client := &http.Client{
+		Timeout: 10 * time.Second,
+	}
+
+	resp, err := client.Get(url)
+	if err != nil {
+		fmt.Printf("Warning: URL %s is not reachable. Error: %v\n", url, err)
+		panic("VCenter is not reachable. Please check your VPN connection and try again.")
+	}
+	defer resp.Body.Close()
+
+	fmt.Printf("URL %s is reachable\n", url)
+}
@@ -0,0 +1,29 @@
+package main
+
+import (
+	"fmt"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/spf13/cobra"
+)
+
+var verifyCmd = &cobra.Command{
+	Use:   "verify <cluster-output-dir>",
+	Short: "Check health of an installed cluster",
+	Long: `Check that every ClusterOperator is Available and not Degraded, every node is Ready and the
+ClusterVersion rollout is completed. Uses oc and auth/kubeconfig from the output dir of the installation.`,
+	Args: cobra.ExactArgs(1),
+	RunE: func(cmd *cobra.Command, args []string) error {
+		timeout, _ := cmd.Flags().GetDuration("timeout")
+		if err := utils.VerifyCluster(cmd.Context(), utils.DefaultExecutor, args[0], timeout); err != nil {
+			return err
+		}
+		fmt.Println("Cluster is healthy.")
+		return nil
+	},
+}
+
+func init() {
+	verifyCmd.Flags().Duration("timeout", 0, fmt.Sprintf("How long to wait for the cluster to become healthy, 0 checks once. Installation waits %v.", utils.DefaultVerifyTimeout))
+	rootCmd.AddCommand(verifyCmd)
+}
@@ -0,0 +1,287 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"slices"
+	"strings"
+
+	"github.com/RomanBednar/install-tools/utils"
+	"github.com/manifoldco/promptui"
+	"github.com/spf13/cobra"
+	"golang.org/x/term"
+)
+
+// wizardDefaults are offered when creating a new config file, they match conf.env.template.
+var wizardDefaults = map[string]string{
+	"cloud":                   "aws",
+	"outputDir":               "./_output",
+	"cloudRegion":             "us-east-1",
+	"sshPublicKeyFile":        "${HOME}/.ssh/id_rsa.pub",
+	"pullSecretFile":          "$HOME/.config/containers/auth.json",
+	"vSphereBaseDomain":       "devqe.ibmc.devcluster.openshift.com",
+	"vSphereVCenterSubdomain": "vcenter-2",
+	"engine":                  "podman",
+}
+
+// validateClusterName checks the name the templates build from user and cluster name, so limits of the
+// selected cloud are reported before the installation starts.
+func (w *wizard) validateClusterName(s string) error {
+	if err := utils.ValidateDNSLabel(s); err != nil {
+		return err
+	}
+	_, err := utils.ValidateClusterName(&utils.Config{Cloud: w.values["cloud"], UserName: w.values["userName"], ClusterName: s})
+	return err
+}
+
+func validateNotEmpty(s string) error {
+	if strings.TrimSpace(s) == "" {
+		return fmt.Errorf("value is required")
+	}
+	return nil
+}
+
+func validateIP(s string) error {
+	if net.ParseIP(s) == nil {
+		return fmt.Errorf("not a valid IP address")
+	}
+	return nil
+}
+
+// validateFile checks that the file exists and its content passes check. Paths may contain environment
+// variables, like the paths in conf.env.
+func validateFile(check func([]byte) error) promptui.ValidateFunc {
+	return func(path string) error {
+		content, err := os.ReadFile(os.ExpandEnv(path))
+		if err != nil {
+			return fmt.Errorf("can not read file: %v", err)
+		}
+		return check(content)
+	}
+}
+
+func checkSSHPublicKey(content []byte) error {
+	fields := strings.Fields(string(content))
+	if len(fields) < 2 || !(strings.HasPrefix(fields[0], "ssh-") || strings.HasPrefix(fields[0], "ecdsa-") || strings.HasPrefix(fields[0], "sk-")) {
+		return fmt.Errorf("not an SSH public key, use the .pub file")
+	}
+	return nil
+}
+
+func checkPullSecret(content []byte) error {
+	var secret struct {
+		Auths map[string]json.RawMessage `json:"auths"`
+	}
+	if err := json.Unmarshal(content, &secret); err != nil {
+		return fmt.Errorf("not a valid JSON file: %v", err)
+	}
+	if len(secret.Auths) == 0 {
+		return fmt.Errorf("no registry credentials found in \"auths\"")
+	}
+	return nil
+}
+
+type wizard struct {
+	values map[string]string
+	// stdin is read by the prompts, os.Stdin is used if it's nil.
+	stdin io.ReadCloser
+}
+
+func (w *wizard) ask(key, label string, validate promptui.ValidateFunc) error {
+	prompt := promptui.Prompt{
+		Label:     label,
+		Default:   w.values[key],
+		AllowEdit: true,
+		Validate:  validate,
+		Stdin:     w.stdin,
+	}
+	result, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+	w.values[key] = strings.TrimSpace(result)
+	return nil
+}
+
+func (w *wizard) askCloud() error {
+	clouds := utils.GetCloudKeys()
+	slices.Sort(clouds)
+	prompt := promptui.Select{
+		Label:     "Cloud",
+		Items:     clouds,
+		CursorPos: max(slices.Index(clouds, w.values["cloud"]), 0),
+		Size:      len(clouds),
+		Stdin:     w.stdin,
+	}
+	_, result, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+	w.values["cloud"] = result
+	return nil
+}
+
+// askVSpherePassword stores the password in a file next to the config file, so it never ends up in conf.env.
+func (w *wizard) askVSpherePassword(configFile string) error {
+	passwordFile := w.values["vSpherePasswordFile"]
+	if passwordFile == "" {
+		passwordFile = filepath.Join(filepath.Dir(configFile), "vsphere-password")
+	}
+	_, err := os.Stat(os.ExpandEnv(passwordFile))
+	exists := err == nil || w.values["vSpherePassword"] != ""
+
+	label := "vCenter password"
+	if exists {
+		label += " (leave empty to keep the current one)"
+	}
+	prompt := promptui.Prompt{
+		Label: label,
+		Mask:  '*',
+		Stdin: w.stdin,
+		Validate: func(s string) error {
+			if s == "" && !exists {
+				return fmt.Errorf("value is required")
+			}
+			return nil
+		},
+	}
+	password, err := prompt.Run()
+	if err != nil {
+		return err
+	}
+	if password == "" {
+		return nil
+	}
+	// The directory of the config file is created only when it's written, after all questions.
+	if err := os.MkdirAll(filepath.Dir(os.ExpandEnv(passwordFile)), 0700); err != nil {
+		return fmt.Errorf("could not store vCenter password: %v", err)
+	}
+	if err := os.WriteFile(os.ExpandEnv(passwordFile), []byte(password), 0600); err != nil {
+		return fmt.Errorf("could not store vCenter password: %v", err)
+	}
+	w.values["vSpherePasswordFile"] = passwordFile
+	delete(w.values, "vSpherePassword")
+	return nil
+}
+
+func (w *wizard) run(configFile string) error {
+	if err := w.askCloud(); err != nil {
+		return err
+	}
+	steps := []struct {
+		key, label string
+		validate   promptui.ValidateFunc
+	}{
+		{"userName", "User name (kerberos ID for vSphere)", utils.ValidateDNSLabel},
+		{"clusterName", fmt.Sprintf("Cluster name (%q generates one)", utils.AutoClusterName), w.validateClusterName},
+		{"cloudRegion", fmt.Sprintf("Region for %s", w.values["cloud"]), validateNotEmpty},
+		{"outputDir", "Output directory", validateNotEmpty},
+		{"sshPublicKeyFile", "SSH public key file", validateFile(checkSSHPublicKey)},
+		{"pullSecretFile", "Pull secret file", validateFile(checkPullSecret)},
+		{"image", "Release image (can be passed with --image later)", nil},
+	}
+	for _, step := range steps {
+		if err := w.ask(step.key, step.label, step.validate); err != nil {
+			return err
+		}
+	}
+
+	if w.values["cloud"] != "vsphere" {
+		return nil
+	}
+	vsphereSteps := []struct {
+		key, label string
+		validate   promptui.ValidateFunc
+	}{
+		{"vSphereBaseDomain", "vSphere base domain", validateNotEmpty},
+		{"vSphereVCenterSubdomain", "vCenter subdomain", utils.ValidateDNSLabel},
+		{"vSphereApiVIP", "API VIP", validateIP},
+		{"vSphereIngressVIP", "Ingress VIP", func(s string) error {
+			if err := validateIP(s); err != nil {
+				return err
+			}
+			if s == w.values["vSphereApiVIP"] {
+				return fmt.Errorf("must be different from the API VIP")
+			}
+			return nil
+		}},
+	}
+	for _, step := range vsphereSteps {
+		if err := w.ask(step.key, step.label, step.validate); err != nil {
+			return err
+		}
+	}
+	return w.askVSpherePassword(configFile)
+}
+
+// chooseConfigFile asks where to write the config. The directory given by --config-path is used if set.
+func chooseConfigFile(cmd *cobra.Command) (string, error) {
+	fileName := utils.DefaultConfigFilename + ".env"
+	if dir, _ := cmd.Flags().GetString("config-path"); dir != "" {
+		return filepath.Join(os.ExpandEnv(dir), fileName), nil
+	}
+	var items []string
+	for _, dir := range utils.ConfigPaths {
+		items = append(items, filepath.Join(os.ExpandEnv(dir), fileName))
+	}
+	prompt := promptui.Select{Label: "Where to save the configuration", Items: items}
+	_, result, err := prompt.Run()
+	return result, err
+}
+
+var initCmd = &cobra.Command{
+	Use:   "init",
+	Short: "Create or edit conf.env interactively",
+	Long: `Walk through the required configuration values, validate each answer and write a commented conf.env.
+An existing file can be edited, its current values are offered as defaults and keys the wizard
+does not ask for are kept.`,
+	Args: cobra.NoArgs,
+	RunE: func(cmd *cobra.Command, args []string) error {
+		if !term.IsTerminal(int(os.Stdin.Fd())) {
+			return errors.New("init needs an interactive terminal")
+		}
+
+		configFile, err := chooseConfigFile(cmd)
+		if err != nil {
+			return err
+		}
+
+		w := &wizard{values: map[string]string{}}
+		if _, err := os.Stat(configFile); err == nil {
+			if !utils.UserConfirm(fmt.Sprintf("%s already exists. Edit it and keep its current values?", configFile)) {
+				fmt.Println("Nothing was changed.")
+				return nil
+			}
+			if w.values, err = utils.ReadConfigFile(configFile); err != nil {
+				return fmt.Errorf("could not read %s: %v", configFile, err)
+			}
+		} else {
+			for k, v := range wizardDefaults {
+				w.values[k] = v
+			}
+		}
+
+		if err := w.run(configFile); err != nil {
+			if errors.Is(err, promptui.ErrInterrupt) {
+				fmt.Println("Aborted, nothing was written.")
+				return nil
+			}
+			return err
+		}
+
+		if err := utils.WriteConfigFile(configFile, w.values); err != nil {
+			return err
+		}
+		fmt.Printf("Configuration written to %s\n", configFile)
+		return nil
+	},
+}
+
+func init() {
+	rootCmd.AddCommand(initCmd)
+}
@@ -0,0 +1,49 @@
+package main
+
+import (
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/RomanBednar/install-tools/utils"
+)
+
+func TestWizardEmptyHome(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	configFile := filepath.Join(os.ExpandEnv(utils.HomeConfigPath), utils.DefaultConfigFilename+".env")
+
+	w := &wizard{
+		values: map[string]string{"cloud": "vsphere", "userName": "jdoe"},
+		stdin:  io.NopCloser(strings.NewReader("s3cret\n")),
+	}
+	if err := w.askVSpherePassword(configFile); err != nil {
+		t.Fatal(err)
+	}
+	passwordFile := filepath.Join(filepath.Dir(configFile), "vsphere-password")
+	if w.values["vSpherePasswordFile"] != passwordFile {
+		t.Errorf("unexpected vSpherePasswordFile %q", w.values["vSpherePasswordFile"])
+	}
+	info, err := os.Stat(passwordFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if info.Mode().Perm() != 0600 {
+		t.Errorf("expected password file mode 0600, got %v", info.Mode().Perm())
+	}
+	if content, _ := os.ReadFile(passwordFile); string(content) != "s3cret" {
+		t.Errorf("unexpected password %q", content)
+	}
+
+	if err := utils.WriteConfigFile(configFile, w.values); err != nil {
+		t.Fatal(err)
+	}
+	values, err := utils.ReadConfigFile(configFile)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if values["vSpherePasswordFile"] != passwordFile || values["vSpherePassword"] != "" {
+		t.Errorf("unexpected values %v", values)
+	}
+}